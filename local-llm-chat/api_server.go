@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"local-llm-chat/events"
+)
+
+// apiSessionHeader names the chat session a proxied request belongs to. A
+// request without it starts a new session; the response always carries it.
+const apiSessionHeader = "X-Session-Id"
+
+// maxAPIRequestBytes caps the body of a proxied request.
+const maxAPIRequestBytes = 32 << 20
+
+// llmStartTimeout is how long a request waits for a model launched on demand.
+const llmStartTimeout = 2 * time.Minute
+
+// apiChatRequest is the part of an OpenAI chat completion request the proxy
+// reads. The request is forwarded to llama-server unchanged.
+type apiChatRequest struct {
+	Model    string           `json:"model"`
+	Messages []apiChatMessage `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Tools    json.RawMessage  `json:"tools,omitempty"`
+}
+
+// apiChatMessage is a message of a proxied request. Content is either a
+// string or a list of content parts.
+type apiChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Text returns the text of the message, joining the text parts of a
+// multi-part message.
+func (m apiChatMessage) Text() string {
+	text, _ := parseContent(m.Content)
+	return text
+}
+
+// startAPIServer serves an OpenAI-compatible API on localhost when
+// api_server_port is configured. Requests are forwarded to llama-server and
+// recorded as chat sessions.
+func (a *App) startAPIServer() {
+	if a.config.APIServerPort <= 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", a.handleAPIChatCompletions)
+	mux.HandleFunc("/v1/models", a.handleAPIModels)
+	mux.Handle("/v1/events", events.NewSSEHandler(a.bus))
+	a.apiServer = &http.Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", a.config.APIServerPort),
+		Handler: mux,
+	}
+	go func(apiServer *http.Server) {
+		if err := apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			a.logErrorf("API server on %s stopped: %v", apiServer.Addr, err)
+		}
+	}(a.apiServer)
+	a.logInfof("API server listening on http://%s/v1", a.apiServer.Addr)
+}
+
+// shutdownAPIServer stops the API server, if it is running.
+func (a *App) shutdownAPIServer() {
+	if a.apiServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	a.apiServer.Shutdown(ctx)
+}
+
+// writeAPIError writes an error in the OpenAI format.
+func writeAPIError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": message, "type": errorType},
+	})
+}
+
+// apiModelID is the ID a model file is listed under: its file name without
+// the .gguf extension.
+func apiModelID(modelPath string) string {
+	name := filepath.Base(modelPath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func (a *App) handleAPIModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "use GET")
+		return
+	}
+	models, err := a.GetModels()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	_, loadedModel, _ := a.llmServer()
+	data := make([]map[string]interface{}, 0, len(models))
+	for _, modelPath := range models {
+		data = append(data, map[string]interface{}{
+			"id":       apiModelID(modelPath),
+			"object":   "model",
+			"owned_by": "local-llm-chat",
+			"loaded":   loadedModel == modelPath,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+}
+
+// resolveModel finds the model file a request names, by ID, file name or path.
+func (a *App) resolveModel(name string) (string, error) {
+	models, err := a.GetModels()
+	if err != nil {
+		return "", err
+	}
+	for _, modelPath := range models {
+		if modelPath == name || strings.EqualFold(filepath.Base(modelPath), name) || strings.EqualFold(apiModelID(modelPath), name) {
+			return modelPath, nil
+		}
+	}
+	return "", fmt.Errorf("model %q not found in %s", name, a.config.ModelsDir)
+}
+
+// ensureModel makes sure llama-server serves the requested model, launching it
+// with its saved arguments if another model or none is running. Without a
+// name, a running server is used as is and the selected model is launched
+// otherwise.
+func (a *App) ensureModel(name string) error {
+	a.launchMu.Lock()
+	defer a.launchMu.Unlock()
+
+	modelPath := a.config.SelectedModel
+	if name != "" {
+		resolved, err := a.resolveModel(name)
+		if err != nil {
+			return err
+		}
+		modelPath = resolved
+	}
+	if _, loadedModel, _ := a.llmServer(); a.IsLLMLoaded() && (name == "" || loadedModel == modelPath) {
+		return nil
+	}
+	if name == "" {
+		// llama-server may have been started outside the app.
+		if status, err := a.HealthCheck(); err == nil && status == "ok" {
+			return nil
+		}
+	}
+	if modelPath == "" {
+		return fmt.Errorf("no model selected")
+	}
+
+	a.logInfof("Launching %s on demand", modelPath)
+	if _, err := a.LaunchLLM(modelPath, a.config.ModelSettings[modelPath].Args); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(llmStartTimeout)
+	for time.Now().Before(deadline) {
+		if status, err := a.HealthCheck(); err == nil && status == "ok" {
+			return nil
+		}
+		if !a.IsLLMLoaded() {
+			return fmt.Errorf("llama-server exited while loading %s", filepath.Base(modelPath))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("llama-server did not load %s within %s", filepath.Base(modelPath), llmStartTimeout)
+}
+
+// apiSession returns the chat session a proxied request is recorded in and
+// saves the request's new messages to it. A request for an existing session
+// adds its last user message; otherwise a session is created with the
+// request's system prompt and all of its messages.
+func (a *App) apiSession(sessionHeader string, messages []apiChatMessage) (int64, error) {
+	if sessionID, err := strconv.ParseInt(sessionHeader, 10, 64); err == nil {
+		if _, err := a.db.GetChatSession(sessionID); err != nil {
+			return 0, err
+		}
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "user" {
+				return sessionID, a.saveAPIMessages(sessionID, messages[i:i+1])
+			}
+		}
+		return sessionID, nil
+	}
+
+	systemPrompt := ""
+	if len(messages) > 0 && messages[0].Role == "system" {
+		systemPrompt = messages[0].Text()
+		messages = messages[1:]
+	}
+	sessionID, err := a.db.NewChatSession(systemPrompt)
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			if name, err := a.generateSessionName(msg.Text()); err == nil && name != "" {
+				a.db.UpdateChatSessionName(sessionID, name)
+			}
+			break
+		}
+	}
+	return sessionID, a.saveAPIMessages(sessionID, messages)
+}
+
+// saveAPIMessages saves the user and assistant messages of a request.
+func (a *App) saveAPIMessages(sessionID int64, messages []apiChatMessage) error {
+	for _, msg := range messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			continue
+		}
+		text := msg.Text()
+		if _, err := a.db.SaveChatMessage(sessionID, msg.Role, text); err != nil {
+			return err
+		}
+		a.appendToOpenConversation(sessionID, ChatMessage{Role: msg.Role, Content: stripThinkTags(text)})
+	}
+	return nil
+}
+
+// saveAPIReply saves the reply to a proxied request, with its reasoning in
+// <think> tags as streamHandler does.
+func (a *App) saveAPIReply(sessionID int64, content, reasoning string) {
+	reply := content
+	if reasoning != "" {
+		reply = fmt.Sprintf("<think>%s</think>\n%s", reasoning, content)
+	}
+	if _, err := a.db.SaveChatMessage(sessionID, "assistant", reply); err != nil {
+		a.logErrorf("API server: Error saving reply for session %d: %v", sessionID, err)
+		return
+	}
+	a.appendToOpenConversation(sessionID, ChatMessage{Role: "assistant", Content: stripThinkTags(harmonyVisibleText(reply))})
+}
+
+func (a *App) handleAPIChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "use POST")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAPIRequestBytes))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	var request apiChatRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid JSON: %v", err))
+		return
+	}
+	if len(request.Messages) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", "messages must not be empty")
+		return
+	}
+
+	// OpenAI clients always name a model, often one that is not here, such
+	// as "gpt-4o". Those get the running or selected model.
+	model := request.Model
+	if model != "" {
+		if _, err := a.resolveModel(model); err != nil {
+			a.logWarningf("API server: %v; answering with the running or selected model", err)
+			model = ""
+		}
+	}
+	if err := a.ensureModel(model); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	sessionID, err := a.apiSession(r.Header.Get(apiSessionHeader), request.Messages)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("session: %v", err))
+		return
+	}
+	w.Header().Set(apiSessionHeader, strconv.FormatInt(sessionID, 10))
+
+	// Run the Tool-Using Agent here unless the client brings its own tools.
+	if a.config.APIServerTools && len(request.Tools) == 0 && a.router != nil {
+		a.serveAPIToolAgent(w, sessionID, request)
+		return
+	}
+
+	resp, err := http.Post(llamaServerURL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "server_error", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	if request.Stream {
+		content, reasoning := forwardAPIStream(w, resp.Body)
+		a.saveAPIReply(sessionID, content, reasoning)
+		return
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.logErrorf("API server: Error reading llama-server response: %v", err)
+		return
+	}
+	w.Write(responseBody)
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content          string `json:"content"`
+				ReasoningContent string `json:"reasoning_content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(responseBody, &completion); err == nil && len(completion.Choices) > 0 {
+		a.saveAPIReply(sessionID, completion.Choices[0].Message.Content, completion.Choices[0].Message.ReasoningContent)
+	}
+}
+
+// forwardAPIStream copies a server-sent event stream to the client as it
+// arrives and returns the streamed content and reasoning.
+func forwardAPIStream(w http.ResponseWriter, body io.Reader) (string, string) {
+	flusher, _ := w.(http.Flusher)
+	var content, reasoning strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxAPIRequestBytes)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+			break // The client went away
+		}
+		if flusher != nil && line == "" {
+			flusher.Flush()
+		}
+
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err == nil && len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+			reasoning.WriteString(chunk.Choices[0].Delta.ReasoningContent)
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return content.String(), reasoning.String()
+}
+
+// serveAPIToolAgent answers a proxied request with the Tool-Using Agent. Tool
+// calls and results are saved to the session like in the chat window, and the
+// final answer is returned as a completion or, for streaming requests, as a
+// single chunk.
+func (a *App) serveAPIToolAgent(w http.ResponseWriter, sessionID int64, request apiChatRequest) {
+	var history []ChatMessage
+	var systemPrompts []string
+	message := ""
+	for _, msg := range request.Messages {
+		if msg.Role == "system" {
+			systemPrompts = append(systemPrompts, msg.Text())
+		}
+		if msg.Role == "user" || msg.Role == "assistant" {
+			text, images := parseContent(msg.Content)
+			history = append(history, ChatMessage{Role: msg.Role, Content: text, Images: images})
+		}
+		if msg.Role == "user" {
+			message = msg.Text()
+		}
+	}
+
+	response, err := a.apiToolAgent(sessionID, strings.Join(systemPrompts, "\n\n"), message, history)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "server_error", err.Error())
+		return
+	}
+	a.saveAPIReply(sessionID, response.Content, response.ReasoningContent)
+
+	model := request.Model
+	if model == "" {
+		_, loadedModel, _ := a.llmServer()
+		model = apiModelID(loadedModel)
+	}
+	id := fmt.Sprintf("chatcmpl-local-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+	assistant := map[string]interface{}{"role": "assistant", "content": response.Content}
+	if response.ReasoningContent != "" {
+		assistant["reasoning_content"] = response.ReasoningContent
+	}
+
+	if request.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunk, _ := json.Marshal(map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": assistant, "finish_reason": "stop"}},
+		})
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", chunk)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{{"index": 0, "message": assistant, "finish_reason": "stop"}},
+		"usage": map[string]int{
+			"prompt_tokens":     response.Usage.PromptTokens,
+			"completion_tokens": response.Usage.CompletionTokens,
+			"total_tokens":      response.Usage.PromptTokens + response.Usage.CompletionTokens,
+		},
+	})
+}
+
+// apiToolAgent runs the Tool-Using Agent without streaming and returns its
+// final answer: the first reply without a tool call, or a reply asked for
+// once the loop strategy stops the run. The client's system prompt, if any,
+// precedes the agent's tool instructions.
+func (a *App) apiToolAgent(sessionID int64, systemPrompt, message string, history []ChatMessage) (LLMResponse, error) {
+	run, err := a.newToolAgentRun(sessionID, message, nil, nil)
+	if err != nil {
+		return LLMResponse{}, err
+	}
+	// The tool prompt changes when more tools are offered, so it is rebuilt
+	// for every request.
+	system := func() ChatMessage {
+		if systemPrompt == "" {
+			return ChatMessage{Role: "system", Content: run.prompt}
+		}
+		return ChatMessage{Role: "system", Content: systemPrompt + "\n\n" + run.prompt}
+	}
+	sampling := a.samplingForSession(sessionID)
+	maxIterations := a.maxToolIterations()
+	note := ""
+	for i := 0; i < maxIterations; i++ {
+		messagesForLLM := append([]ChatMessage{system()}, a.pruneHistory(history)...)
+		llmResponse, err := a.makeLLMRequest(messagesForLLM, false, run.responseFormat, sampling)
+		if err != nil {
+			return LLMResponse{}, err
+		}
+		responseContent, reasoningContent, toolCallJSON := run.parseResponse(llmResponse)
+		if toolCallJSON == "" {
+			// Nothing to stream here, so this reply is the answer.
+			return LLMResponse{Content: responseContent, ReasoningContent: reasoningContent, Usage: llmResponse.Usage}, nil
+		}
+
+		messageToSave := responseContent
+		if reasoningContent != "" {
+			messageToSave = fmt.Sprintf("<think>%s</think>\n%s", reasoningContent, responseContent)
+		}
+		messageID, err := a.db.SaveChatMessage(sessionID, "assistant", messageToSave)
+		if err != nil {
+			a.logErrorf("API server: Error saving tool call message: %v", err)
+		}
+		step := run.executeToolCall(toolCallJSON, messageID)
+		if _, err := a.db.SaveChatMessage(sessionID, "user", step.Result); err != nil {
+			a.logErrorf("API server: Error saving tool message: %v", err)
+		}
+		history = append(history,
+			ChatMessage{Role: "assistant", Content: stripThinkTags(messageToSave)},
+			ChatMessage{Role: "user", Content: step.Result, Images: step.Images})
+		if step.FinalAnswer {
+			note = loopFinalAnswerPrompt
+			break
+		}
+		if i == maxIterations-1 {
+			return LLMResponse{Content: maxIterationsMessage(maxIterations)}, nil
+		}
+	}
+
+	finalMessages := append([]ChatMessage{system()}, a.pruneHistory(history)...)
+	if note != "" {
+		finalMessages = append(finalMessages, ChatMessage{Role: "system", Content: note})
+	}
+	return a.makeLLMRequest(finalMessages, false, nil, sampling)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestAPIChatCompletionsToolAgent posts the kind of request an OpenAI client
+// sends: a model that is not here and a system prompt of its own.
+func TestAPIChatCompletionsToolAgent(t *testing.T) {
+	var mu sync.Mutex
+	var systemPrompts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			fmt.Fprint(w, `{"status": "ok"}`)
+		case "/v1/chat/completions":
+			var request ChatCompletionRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			if len(request.Messages) > 0 && request.Messages[0].Role == "system" {
+				mu.Lock()
+				systemPrompts = append(systemPrompts, request.Messages[0].Content)
+				mu.Unlock()
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"message": map[string]string{"content": "Hi there."}}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	app := newTestApp(t, server.URL)
+	app.config.APIServerTools = true
+
+	body := `{"model": "gpt-4o", "messages": [
+		{"role": "system", "content": "Answer in French."},
+		{"role": "user", "content": "Hello"}
+	]}`
+	recorder := httptest.NewRecorder()
+	app.handleAPIChatCompletions(recorder, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if !strings.Contains(recorder.Body.String(), "Hi there.") {
+		t.Errorf("body = %s, want the reply", recorder.Body.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	forwarded := false
+	for _, prompt := range systemPrompts {
+		forwarded = forwarded || strings.HasPrefix(prompt, "Answer in French.")
+	}
+	if !forwarded {
+		t.Errorf("system prompts sent = %q, want the client's first", systemPrompts)
+	}
+}
@@ -13,35 +13,58 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/mark3labs/mcp-go/mcp"
-	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
-
 	"local-llm-chat/artifacts"
+	"local-llm-chat/events"
 	"local-llm-chat/mcpclient"
+	"local-llm-chat/nativetools"
+
+	"github.com/mark3labs/mcp-go/server"
 )
 
+// llamaServerURL is the address of the llama-server launched by LaunchLLM.
+// Tests point it at a fake server.
+var llamaServerURL = "http://localhost:8080"
+
 // App struct
 type App struct {
 	ctx             context.Context
+	bus             *events.Bus // Events and log messages; see events_bridge.go
 	config          Config
 	db              *Database
 	llmCmd          *exec.Cmd // This holds the command for the LLM process
+	embeddingCmd    *exec.Cmd // The embedding server launched by LaunchEmbeddingServer
 	mcpClients      map[string]*mcpclient.McpClient
 	conversations   map[int64]*Conversation
 	mu              sync.Mutex
 	ArtifactService *artifacts.ArtifactService
 	router          *Router
 	tokenCounter    *TokenCounter
+	mcpHTTPServer   *server.StreamableHTTPServer // Serves the app over MCP when mcp_server_port is set
+	apiServer       *http.Server                 // OpenAI-compatible API, when api_server_port is set
+	loadedModel     string                       // Model file llmCmd serves
+	loadedMMProj    string                       // Multimodal projector llmCmd was started with, if any
+	launchMu        sync.Mutex                   // Serializes model launches by the API server
+	indexMu         sync.Mutex                   // Serializes document indexing
+	whisperCmd      *exec.Cmd                    // whisper-server for speech to text
+	whisperModel    string                       // Model file whisperCmd serves
+	serverMu        sync.Mutex                   // Guards the server commands and models above; their Wait goroutines clear them
+	ragWatcher      *ragWatcher                  // Keeps document collections in sync with the disk
+	speech          SpeechBackend                // Overrides the text to speech server of the config
 }
 
 // ModelSettings struct to hold arguments for a specific model
 type ModelSettings struct {
-	Args            string `json:"args"`
-	UseHarmonyTools bool   `json:"use_harmony_tools,omitempty"`
+	Args            string             `json:"args"`
+	UseHarmonyTools bool               `json:"use_harmony_tools,omitempty"`
+	Sampling        *SamplingSettings  `json:"sampling,omitempty"`
+	ReasoningTags   []ReasoningTagPair `json:"reasoning_tags,omitempty"`
+	Vision          bool               `json:"vision,omitempty"` // Model accepts images (started with an mmproj)
+	MMProj          string             `json:"mmproj,omitempty"` // Multimodal projector; found next to the model if empty
 }
 
 // Config struct - Add the Theme field here
@@ -54,71 +77,124 @@ type Config struct {
 	McpConnectionStates map[string]bool          `json:"mcp_connection_states"`
 	ToolCallIterations  int                      `json:"tool_call_iterations"`
 	ToolCallCooldown    int                      `json:"tool_call_cooldown"`
+	// Router settings. RouterMode is "llm" (default) or "embedding".
+	RouterMode                string              `json:"router_mode,omitempty"`
+	EmbeddingServerURL        string              `json:"embedding_server_url,omitempty"`
+	RouterSimilarityThreshold float64             `json:"router_similarity_threshold,omitempty"`
+	RouterTopK                int                 `json:"router_top_k,omitempty"`
+	RouterExamples            map[string][]string `json:"router_examples,omitempty"`      // Example utterances per tool name
+	ToolSelectionLimit        int                 `json:"tool_selection_limit,omitempty"` // Tools offered per manifest; the rest via more_tools
+	ToolLoopStrategy          string              `json:"tool_loop_strategy,omitempty"`   // "hint" (default), "block" or "final_answer"
+	MaxToolResultTokens       int                 `json:"max_tool_result_tokens,omitempty"`
+	ToolResultTokenLimits     map[string]int      `json:"tool_result_token_limits,omitempty"` // Per-tool overrides of MaxToolResultTokens
+	DisableNativeTools        bool                `json:"disable_native_tools,omitempty"`
+	NativeToolsAllowedDirs    []string            `json:"native_tools_allowed_dirs,omitempty"` // Directories read_local_file may read from
+	MCPServerPort             int                 `json:"mcp_server_port,omitempty"`           // Serve the app's MCP server on localhost
+	APIServerPort             int                 `json:"api_server_port,omitempty"`           // Serve an OpenAI-compatible API on localhost
+	APIServerTools            bool                `json:"api_server_tools,omitempty"`          // Answer API requests with the Tool-Using Agent
+	// Document retrieval (RAG) settings. EmbeddingModel is served by a second
+	// llama-server on EmbeddingServerPort unless EmbeddingServerURL is set.
+	EmbeddingModel      string `json:"embedding_model,omitempty"`
+	EmbeddingModelArgs  string `json:"embedding_model_args,omitempty"`
+	EmbeddingServerPort int    `json:"embedding_server_port,omitempty"`
+	RAGTopK             int    `json:"rag_top_k,omitempty"`          // Chunks retrieved per message
+	RAGChunkSize        int    `json:"rag_chunk_size,omitempty"`     // Characters per chunk
+	RAGChunkOverlap     int    `json:"rag_chunk_overlap,omitempty"`  // Characters repeated from the previous chunk; negative turns the overlap off
+	RAGWatchInterval    int    `json:"rag_watch_interval,omitempty"` // Seconds between checks for changed files; negative turns the watcher off
+	RerankServerURL     string `json:"rerank_server_url,omitempty"`  // llama-server with a reranking model; reorders retrieved chunks
+	// Files attached to messages.
+	MaxAttachmentTokens int    `json:"max_attachment_tokens,omitempty"` // Tokens of text files inlined per message
+	AttachmentOverflow  string `json:"attachment_overflow,omitempty"`   // "truncate" (default) or "refuse" files over the budget
+	// Speech to text with whisper.cpp's whisper-server.
+	WhisperCppDir     string `json:"whisper_cpp_dir,omitempty"`
+	WhisperModel      string `json:"whisper_model,omitempty"` // ggml model file, e.g. ggml-base.en.bin
+	WhisperModelArgs  string `json:"whisper_model_args,omitempty"`
+	WhisperLanguage   string `json:"whisper_language,omitempty"` // Spoken language, e.g. "en"; "auto" (default) detects it
+	WhisperServerPort int    `json:"whisper_server_port,omitempty"`
+	// Text to speech with an OpenAI-compatible /v1/audio/speech server.
+	TTSServerURL string `json:"tts_server_url,omitempty"`
+	TTSModel     string `json:"tts_model,omitempty"`  // Default "tts-1"
+	TTSVoice     string `json:"tts_voice,omitempty"`  // Default "alloy"
+	TTSFormat    string `json:"tts_format,omitempty"` // mp3 (default), wav, opus, aac, flac or pcm
+	TTSStream    bool   `json:"tts_stream,omitempty"` // Speak replies sentence by sentence while they stream
 }
 
 // Conversation struct to hold the state of a single chat session
 type Conversation struct {
 	messages     []ChatMessage
 	systemPrompt string
+	sampling     *SamplingSettings // Per-session overrides of the model's sampling defaults
+	constraint   *OutputConstraint // Session-wide JSON Schema or grammar for replies
+	turnOptions  ChatOptions       // Options of the message currently being answered
+	trace        *agentTrace       // Trace of the turn currently being answered
+	citations    []Citation        // Document chunks retrieved for the turn currently being answered
 	httpResp     *http.Response
 	mu           sync.Mutex
 	TotalTokens  int
 }
 
-
 func NewApp() *App {
 	return &App{
 		conversations: make(map[int64]*Conversation),
 		mcpClients:    make(map[string]*mcpclient.McpClient),
+		bus:           events.NewBus(),
 	}
 }
 
 // startup is called when the app starts.
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+	subscribeWails(a.bus, ctx)
 	log.Println("App startup initiated.")
 	db, err := NewDatabase("chat.db")
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error opening database: %s", err.Error())
+		a.logErrorf("Error opening database: %s", err.Error())
 		return
 	}
 	a.db = db
 	err = a.db.Initialize()
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error initializing database: %s", err.Error())
+		a.logErrorf("Error initializing database: %s", err.Error())
 		return
 	}
 
 	userConfigDir, err := os.UserConfigDir()
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "App Startup: Failed to get user config directory for artifacts: %v", err)
-		a.ArtifactService = artifacts.NewArtifactService(nil, "")
+		a.logErrorf("App Startup: Failed to get user config directory for artifacts: %v", err)
+		a.ArtifactService = artifacts.NewArtifactService(a.bus, "")
 	} else {
 		artifactDataDir := filepath.Join(userConfigDir, "local-llm-chat", "artifacts")
-		a.ArtifactService = artifacts.NewArtifactService(ctx, artifactDataDir)
+		a.ArtifactService = artifacts.NewArtifactService(a.bus, artifactDataDir)
+	}
+	if err := a.ArtifactService.Restore(a.db); err != nil {
+		a.logErrorf("App Startup: Failed to restore artifacts: %v", err)
+	} else {
+		// Only the app cleans up the artifacts directory. Commands may run
+		// with another folder's chat.db, which does not know these files.
+		a.ArtifactService.RemoveOrphanFiles()
 	}
 
 	exePath, err := os.Executable()
 	if err == nil {
 		configFilePath := filepath.Join(filepath.Dir(exePath), "config.json")
-		wailsruntime.LogInfof(a.ctx, "Expected config.json path: %s", configFilePath)
+		a.logInfof("Expected config.json path: %s", configFilePath)
 	} else {
-		wailsruntime.LogErrorf(a.ctx, "Could not determine executable path for config.json logging: %v", err)
+		a.logErrorf("Could not determine executable path for config.json logging: %v", err)
 	}
 
 	settings, err := a.LoadSettings()
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error loading config: %s", err.Error())
+		a.logErrorf("Error loading config: %s", err.Error())
 	} else {
-		wailsruntime.LogInfof(a.ctx, "Raw settings loaded from config.json: %s", settings)
+		a.logInfof("Raw settings loaded from config.json: %s", settings)
 	}
 
 	var config Config
 	err = json.Unmarshal([]byte(settings), &config)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error unmarshalling settings string into Config struct: %s", err.Error())
+		a.logErrorf("Error unmarshalling settings string into Config struct: %s", err.Error())
 	} else {
-		wailsruntime.LogInfof(a.ctx, "Unmarshalled Config struct in startup: %+v", config)
+		a.logInfof("Unmarshalled Config struct in startup: %+v", config)
 	}
 
 	if config.ModelSettings == nil {
@@ -130,10 +206,20 @@ func (a *App) startup(ctx context.Context) {
 	a.router = NewRouter(a)
 
 	// Initialize the token counter
-	a.tokenCounter = NewTokenCounter(ctx)
+	a.tokenCounter = NewTokenCounter(a.bus)
+
+	// Register the built-in tools alongside the MCP servers
+	a.connectNativeTools()
+
+	// Let other programs reach the app over MCP
+	a.startMCPHTTPServer()
+	a.startAPIServer()
+
+	// Keep document collections in sync with the disk
+	a.startRAGWatcher()
 
 	log.Println("App startup complete.")
-	wailsruntime.LogInfof(a.ctx, "Final a.config state after startup: %+v", a.config)
+	a.logInfof("Final a.config state after startup: %+v", a.config)
 }
 
 // NewChat creates a new chat session.
@@ -148,7 +234,7 @@ func (a *App) NewChat(systemPrompt string) (int64, error) {
 		messages:     make([]ChatMessage, 0),
 		systemPrompt: systemPrompt,
 	}
-	wailsruntime.LogInfof(a.ctx, "New chat session %d created with system prompt: '%s'", id, systemPrompt)
+	a.logInfof("New chat session %d created with system prompt: '%s'", id, systemPrompt)
 	return id, nil
 }
 
@@ -162,7 +248,31 @@ func (a *App) DeleteChatSession(id int64) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	delete(a.conversations, id)
-	return a.db.DeleteChatSession(id)
+	if err := a.db.DeleteChatSession(id); err != nil {
+		return err
+	}
+	if a.ArtifactService != nil {
+		a.ArtifactService.DeleteSessionArtifacts(id)
+	}
+	return nil
+}
+
+// DeleteChatMessage deletes a message of a session with the artifacts it
+// produced or carried, and their files. A session loaded in memory is
+// reloaded from the database, so the model no longer sees the message.
+func (a *App) DeleteChatMessage(sessionID, messageID int64) error {
+	if err := a.db.DeleteChatMessage(sessionID, messageID); err != nil {
+		return err
+	}
+	if a.ArtifactService != nil {
+		a.ArtifactService.DeleteMessageArtifacts(messageID)
+	}
+	if _, ok := a.getConversation(sessionID); ok {
+		if _, err := a.LoadChatHistory(sessionID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // UpdateChatSystemPrompt updates the system prompt for an existing chat session.
@@ -172,7 +282,7 @@ func (a *App) UpdateChatSystemPrompt(sessionID int64, newSystemPrompt string) er
 
 	conv, ok := a.conversations[sessionID]
 	if !ok {
-		wailsruntime.LogErrorf(a.ctx, "UpdateChatSystemPrompt: Conversation with ID %d not found in memory.", sessionID)
+		a.logErrorf("UpdateChatSystemPrompt: Conversation with ID %d not found in memory.", sessionID)
 		return fmt.Errorf("conversation not found")
 	}
 
@@ -182,33 +292,42 @@ func (a *App) UpdateChatSystemPrompt(sessionID int64, newSystemPrompt string) er
 
 	err := a.db.UpdateChatSessionSystemPrompt(sessionID, newSystemPrompt)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "UpdateChatSystemPrompt: Error updating system prompt in DB for session %d: %s", sessionID, err.Error())
+		a.logErrorf("UpdateChatSystemPrompt: Error updating system prompt in DB for session %d: %s", sessionID, err.Error())
 		return err
 	}
-	wailsruntime.LogInfof(a.ctx, "UpdateChatSystemPrompt: System prompt for session %d updated to: '%s'", sessionID, newSystemPrompt)
+	a.logInfof("UpdateChatSystemPrompt: System prompt for session %d updated to: '%s'", sessionID, newSystemPrompt)
 	return nil
 }
 
+// llmServer returns the running llama-server and the model and projector it
+// serves. cmd is nil if no server is running.
+func (a *App) llmServer() (cmd *exec.Cmd, model, mmproj string) {
+	a.serverMu.Lock()
+	defer a.serverMu.Unlock()
+	return a.llmCmd, a.loadedModel, a.loadedMMProj
+}
+
 // IsLLMLoaded checks if the LLM process is currently running.
 func (a *App) IsLLMLoaded() bool {
-	if a.llmCmd != nil && a.llmCmd.Process != nil && a.llmCmd.ProcessState == nil {
-		wailsruntime.LogDebugf(a.ctx, "IsLLMLoaded: LLM process appears to be running (PID: %d).", a.llmCmd.Process.Pid)
+	// The server's Wait goroutine clears llmCmd when it exits.
+	if cmd, _, _ := a.llmServer(); cmd != nil && cmd.Process != nil {
+		a.logDebugf("IsLLMLoaded: LLM process appears to be running (PID: %d).", cmd.Process.Pid)
 		return true
 	}
-	wailsruntime.LogDebugf(a.ctx, "IsLLMLoaded: LLM process is not running.")
+	a.logDebugf("IsLLMLoaded: LLM process is not running.")
 	return false
 }
 
 // SaveSettings saves the configuration to a JSON file.
 func (a *App) SaveSettings(settings string) error {
-	wailsruntime.LogInfof(a.ctx, "SaveSettings called with raw settings string: %s", settings)
+	a.logInfof("SaveSettings called with raw settings string: %s", settings)
 	var config Config
 	err := json.Unmarshal([]byte(settings), &config)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error unmarshalling settings string in SaveSettings: %s", err.Error())
+		a.logErrorf("Error unmarshalling settings string in SaveSettings: %s", err.Error())
 		return err
 	}
-	wailsruntime.LogInfof(a.ctx, "Config struct after unmarshalling in SaveSettings: %+v", config)
+	a.logInfof("Config struct after unmarshalling in SaveSettings: %+v", config)
 
 	// Ensure the ModelSettings map in the existing config is not nil
 	if a.config.ModelSettings == nil {
@@ -229,12 +348,46 @@ func (a *App) SaveSettings(settings string) error {
 	a.config.Theme = config.Theme
 	a.config.ToolCallIterations = config.ToolCallIterations
 	a.config.ToolCallCooldown = config.ToolCallCooldown
+	a.config.RouterMode = config.RouterMode
+	a.config.EmbeddingServerURL = config.EmbeddingServerURL
+	a.config.RouterSimilarityThreshold = config.RouterSimilarityThreshold
+	a.config.RouterTopK = config.RouterTopK
+	a.config.RouterExamples = config.RouterExamples
+	a.config.ToolSelectionLimit = config.ToolSelectionLimit
+	a.config.ToolLoopStrategy = config.ToolLoopStrategy
+	a.config.MaxToolResultTokens = config.MaxToolResultTokens
+	a.config.ToolResultTokenLimits = config.ToolResultTokenLimits
+	a.config.DisableNativeTools = config.DisableNativeTools
+	a.config.NativeToolsAllowedDirs = config.NativeToolsAllowedDirs
+	a.config.MCPServerPort = config.MCPServerPort
+	a.config.APIServerPort = config.APIServerPort
+	a.config.APIServerTools = config.APIServerTools
+	a.config.EmbeddingModel = config.EmbeddingModel
+	a.config.EmbeddingModelArgs = config.EmbeddingModelArgs
+	a.config.EmbeddingServerPort = config.EmbeddingServerPort
+	a.config.RAGTopK = config.RAGTopK
+	a.config.RAGChunkSize = config.RAGChunkSize
+	a.config.RAGChunkOverlap = config.RAGChunkOverlap
+	a.config.RAGWatchInterval = config.RAGWatchInterval
+	a.config.RerankServerURL = config.RerankServerURL
+	a.config.MaxAttachmentTokens = config.MaxAttachmentTokens
+	a.config.AttachmentOverflow = config.AttachmentOverflow
+	a.config.WhisperCppDir = config.WhisperCppDir
+	a.config.WhisperModel = config.WhisperModel
+	a.config.WhisperModelArgs = config.WhisperModelArgs
+	a.config.WhisperLanguage = config.WhisperLanguage
+	a.config.WhisperServerPort = config.WhisperServerPort
+	a.config.TTSServerURL = config.TTSServerURL
+	a.config.TTSModel = config.TTSModel
+	a.config.TTSVoice = config.TTSVoice
+	a.config.TTSFormat = config.TTSFormat
+	a.config.TTSStream = config.TTSStream
 	// Note: McpConnectionStates is not managed here as it's transient state
-	wailsruntime.LogInfof(a.ctx, "a.config state before saving to file: %+v", a.config)
+	a.logInfof("a.config state before saving to file: %+v", a.config)
 
 	file, err := os.Create("config.json")
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error creating config.json file: %s", err.Error())
+		a.logErrorf("Error creating config.json file: %s", err.Error())
 		return err
 	}
 	defer file.Close()
@@ -242,10 +395,10 @@ func (a *App) SaveSettings(settings string) error {
 	encoder.SetIndent("", "  ")
 	encodeErr := encoder.Encode(a.config)
 	if encodeErr != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error encoding config to JSON file: %s", encodeErr.Error())
+		a.logErrorf("Error encoding config to JSON file: %s", encodeErr.Error())
 		return encodeErr
 	}
-	wailsruntime.LogInfo(a.ctx, "Config saved to config.json successfully.")
+	a.logInfo("Config saved to config.json successfully.")
 	return nil
 }
 
@@ -254,58 +407,58 @@ func (a *App) LoadSettings() (string, error) {
 	file, err := os.Open("config.json")
 	if err != nil {
 		if os.IsNotExist(err) {
-			wailsruntime.LogInfo(a.ctx, "config.json does not exist. Initializing with default config.")
+			a.logInfo("config.json does not exist. Initializing with default config.")
 			a.config = Config{}
 			a.config.Theme = "default"
 			saveErr := a.SaveSettings(`{"theme":"default"}`)
 			if saveErr != nil {
-				wailsruntime.LogErrorf(a.ctx, "Error saving default config.json: %s", saveErr.Error())
+				a.logErrorf("Error saving default config.json: %s", saveErr.Error())
 				return "", saveErr
 			}
 			return `{"theme":"default"}`, nil
 		}
-		wailsruntime.LogErrorf(a.ctx, "Error opening config.json: %s", err.Error())
+		a.logErrorf("Error opening config.json: %s", err.Error())
 		return "", err
 	}
 	defer file.Close()
 
 	fileContentBytes, readErr := os.ReadFile("config.json")
 	if readErr != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error reading content from config.json: %s", readErr.Error())
+		a.logErrorf("Error reading content from config.json: %s", readErr.Error())
 		return "", readErr
 	}
 	fileContent := string(fileContentBytes)
-	wailsruntime.LogInfof(a.ctx, "Content read from config.json: %s", fileContent)
+	a.logInfof("Content read from config.json: %s", fileContent)
 
 	decoder := json.NewDecoder(strings.NewReader(fileContent))
 	err = decoder.Decode(&a.config)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error decoding config.json content into Config struct: %s", err.Error())
+		a.logErrorf("Error decoding config.json content into Config struct: %s", err.Error())
 		return "", err
 	}
 	if a.config.Theme == "" {
 		a.config.Theme = "default"
-		wailsruntime.LogInfo(a.ctx, "Theme was empty, defaulted to 'default'.")
+		a.logInfo("Theme was empty, defaulted to 'default'.")
 	}
 	if a.config.ModelSettings == nil {
 		a.config.ModelSettings = make(map[string]ModelSettings)
-		wailsruntime.LogInfo(a.ctx, "ModelSettings was nil, initialized to empty map.")
+		a.logInfo("ModelSettings was nil, initialized to empty map.")
 	}
 	// Set default values for new tool settings if they are not present
 	if a.config.ToolCallIterations == 0 {
 		a.config.ToolCallIterations = 5 // Default to 5 iterations
-		wailsruntime.LogInfo(a.ctx, "ToolCallIterations was 0, defaulted to 5.")
+		a.logInfo("ToolCallIterations was 0, defaulted to 5.")
 	}
 	// ToolCallCooldown can default to 0, so no check is needed unless we want a different default.
 
-	wailsruntime.LogInfof(a.ctx, "a.config state after loading and decoding: %+v", a.config)
+	a.logInfof("a.config state after loading and decoding: %+v", a.config)
 
 	configBytes, err := json.Marshal(a.config)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error marshalling a.config to JSON string for frontend: %s", err.Error())
+		a.logErrorf("Error marshalling a.config to JSON string for frontend: %s", err.Error())
 		return "", err
 	}
-	wailsruntime.LogInfof(a.ctx, "Returning config JSON string to frontend: %s", string(configBytes))
+	a.logInfof("Returning config JSON string to frontend: %s", string(configBytes))
 	return string(configBytes), nil
 }
 
@@ -318,7 +471,7 @@ func (a *App) GetModels() ([]string, error) {
 	}
 	err := filepath.Walk(modelsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Error accessing path %s: %v", path, err)
+			a.logErrorf("Error accessing path %s: %v", path, err)
 			return nil
 		}
 		if !info.IsDir() && (strings.HasSuffix(info.Name(), ".gguf") || strings.HasSuffix(info.Name(), ".GGUF")) {
@@ -380,14 +533,14 @@ func (a *App) getServerConfig(serverName string) McpServerConfig {
 	// Load the MCP configuration
 	mcpConfigContent, err := a.GetMcpServers()
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error loading MCP servers config: %s", err.Error())
+		a.logErrorf("Error loading MCP servers config: %s", err.Error())
 		return McpServerConfig{}
 	}
 
 	var mcpConfig McpConfig
 	err = json.Unmarshal([]byte(mcpConfigContent), &mcpConfig)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error unmarshalling MCP config: %s", err.Error())
+		a.logErrorf("Error unmarshalling MCP config: %s", err.Error())
 		return McpServerConfig{}
 	}
 
@@ -404,21 +557,21 @@ func (a *App) GetMcpServers() (string, error) {
 	file, err := os.Open("mcp.json")
 	if err != nil {
 		if os.IsNotExist(err) {
-			wailsruntime.LogInfo(a.ctx, "mcp.json does not exist. Returning empty server list.")
+			a.logInfo("mcp.json does not exist. Returning empty server list.")
 			return "{}", nil
 		}
-		wailsruntime.LogErrorf(a.ctx, "Error opening mcp.json: %s", err.Error())
+		a.logErrorf("Error opening mcp.json: %s", err.Error())
 		return "", err
 	}
 	defer file.Close()
 
 	fileContentBytes, readErr := os.ReadFile("mcp.json")
 	if readErr != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error reading content from mcp.json: %s", readErr.Error())
+		a.logErrorf("Error reading content from mcp.json: %s", readErr.Error())
 		return "", readErr
 	}
 	fileContent := string(fileContentBytes)
-	wailsruntime.LogInfof(a.ctx, "Content read from mcp.json: %s", fileContent)
+	a.logInfof("Content read from mcp.json: %s", fileContent)
 
 	return fileContent, nil
 }
@@ -447,9 +600,7 @@ func (a *App) findExecutable(rootDir, exeName string) (string, error) {
 	var exePath string
 	exeFullName := exeName
 
-	env := wailsruntime.Environment(a.ctx)
-
-	if env.Platform == "windows" {
+	if runtime.GOOS == "windows" {
 		exeFullName += ".exe"
 	}
 
@@ -475,34 +626,75 @@ func (a *App) findExecutable(rootDir, exeName string) (string, error) {
 
 // LaunchLLM launches the LLM server in the background.
 func (a *App) LaunchLLM(modelPath string, modelArgs string) (string, error) {
-	if a.llmCmd != nil && a.llmCmd.Process != nil {
-		wailsruntime.LogInfo(a.ctx, "Terminating existing LLM server process...")
-		if err := a.llmCmd.Process.Kill(); err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Failed to terminate existing LLM server: %v", err)
+	if running, _, _ := a.llmServer(); running != nil && running.Process != nil {
+		a.logInfo("Terminating existing LLM server process...")
+		if err := running.Process.Kill(); err != nil {
+			a.logErrorf("Failed to terminate existing LLM server: %v", err)
 		}
 	}
-	serverPath, err := a.findExecutable(a.config.LlamaCppDir, "llama-server")
-	if err != nil {
-		return "", fmt.Errorf("could not find llama-server executable: %w", err)
-	}
 
 	// Construct the command arguments
 	args := []string{"-m", modelPath}
 	if modelArgs != "" {
 		args = append(args, strings.Fields(modelArgs)...)
 	}
+	mmproj, mmprojArgs := a.mmprojArgs(modelPath, args)
+	if len(mmprojArgs) > 0 {
+		a.logInfof("Launching %s with multimodal projector %s", filepath.Base(modelPath), mmproj)
+		args = append(args, mmprojArgs...)
+	}
+
+	cmd, err := a.startLlamaServer(args, "llm-server.log")
+	if err != nil {
+		return "", fmt.Errorf("failed to start LLM server: %w", err)
+	}
+	a.serverMu.Lock()
+	a.llmCmd = cmd
+	a.loadedModel = modelPath
+	a.loadedMMProj = mmproj
+	a.serverMu.Unlock()
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			a.logErrorf("LLM server exited with error: %v", err)
+		}
+		// A replaced server must not clear the state of its successor.
+		a.serverMu.Lock()
+		defer a.serverMu.Unlock()
+		if a.llmCmd == cmd {
+			a.llmCmd = nil
+			a.loadedModel = ""
+			a.loadedMMProj = ""
+		}
+	}()
+	return "LLM server launched successfully!", nil
+}
+
+// startLlamaServer starts llama-server from the llama.cpp directory with the
+// given arguments, logging to logName in the artifacts directory.
+func (a *App) startLlamaServer(args []string, logName string) (*exec.Cmd, error) {
+	return a.startServer(a.config.LlamaCppDir, "llama-server", args, logName)
+}
+
+// startServer starts the executable exeName found in dir or its
+// subdirectories with the given arguments, logging to logName in the
+// artifacts directory.
+func (a *App) startServer(dir, exeName string, args []string, logName string) (*exec.Cmd, error) {
+	serverPath, err := a.findExecutable(dir, exeName)
+	if err != nil {
+		return nil, fmt.Errorf("could not find %s executable: %w", exeName, err)
+	}
 
 	cmd := exec.Command(serverPath, args...)
 
 	userConfigDir, err := os.UserConfigDir()
 	if err != nil {
-		return "", fmt.Errorf("failed to get user config dir: %w", err)
+		return nil, fmt.Errorf("failed to get user config dir: %w", err)
 	}
 	artifactsDir := filepath.Join(userConfigDir, "local-llm-chat", "artifacts")
-	logFilePath := filepath.Join(artifactsDir, "llm-server.log")
+	logFilePath := filepath.Join(artifactsDir, logName)
 	logFile, err := os.Create(logFilePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create log file: %w", err)
+		return nil, fmt.Errorf("failed to create log file: %w", err)
 	}
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
@@ -510,21 +702,20 @@ func (a *App) LaunchLLM(modelPath string, modelArgs string) (string, error) {
 	setHideWindow(cmd)
 
 	if err := cmd.Start(); err != nil {
-		return "", fmt.Errorf("failed to start LLM server: %w", err)
+		logFile.Close()
+		return nil, err
 	}
-	a.llmCmd = cmd
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			wailsruntime.LogErrorf(a.ctx, "LLM server exited with error: %v", err)
-		}
-		a.llmCmd = nil
-	}()
-	return "LLM server launched successfully!", nil
+	return cmd, nil
 }
 
 // HealthCheck checks the health of the LLM server.
 func (a *App) HealthCheck() (string, error) {
-	resp, err := http.Get("http://localhost:8080/health")
+	return serverHealth(llamaServerURL)
+}
+
+// serverHealth returns the status reported by the llama-server at baseURL.
+func serverHealth(baseURL string) (string, error) {
+	resp, err := http.Get(baseURL + "/health")
 	if err != nil {
 		return "", err
 	}
@@ -542,11 +733,12 @@ func (a *App) HealthCheck() (string, error) {
 
 // ShutdownLLM attempts to gracefully shut down the LLM server.
 func (a *App) ShutdownLLM() error {
-	wailsruntime.LogInfo(a.ctx, "Attempting to shut down LLM server...")
-	if err := shutdownLLM(a.llmCmd); err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Failed to shut down LLM server: %v. Attempting to kill.", err)
-		if err := a.llmCmd.Process.Kill(); err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Failed to kill LLM server: %v", err)
+	a.logInfo("Attempting to shut down LLM server...")
+	cmd, _, _ := a.llmServer()
+	if err := shutdownLLM(cmd); err != nil {
+		a.logErrorf("Failed to shut down LLM server: %v. Attempting to kill.", err)
+		if err := cmd.Process.Kill(); err != nil {
+			a.logErrorf("Failed to kill LLM server: %v", err)
 			return err
 		}
 	}
@@ -554,7 +746,12 @@ func (a *App) ShutdownLLM() error {
 }
 
 func (a *App) shutdown(ctx context.Context) bool {
+	a.stopRAGWatcher()
 	a.ShutdownLLM()
+	a.ShutdownEmbeddingServer()
+	a.ShutdownWhisperServer()
+	a.shutdownMCPHTTPServer()
+	a.shutdownAPIServer()
 	for _, client := range a.mcpClients {
 		client.Disconnect()
 	}
@@ -566,6 +763,10 @@ func (a *App) shutdown(ctx context.Context) bool {
 
 // ConnectMcpClient connects to an MCP server.
 func (a *App) ConnectMcpClient(serverName string, command string, args []string) error {
+	if serverName == nativetools.ServerName {
+		return fmt.Errorf("server name %q is reserved for the built-in tools", serverName)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -582,8 +783,12 @@ func (a *App) ConnectMcpClient(serverName string, command string, args []string)
 	return nil
 }
 
-// DisconnectMcpClient disconnects from an MCP server.
+// DisconnectMcpClient disconnects from an MCP server. The built-in tools stay
+// connected; they are switched off with disable_native_tools.
 func (a *App) DisconnectMcpClient(serverName string) {
+	if serverName == nativetools.ServerName {
+		return
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -597,6 +802,9 @@ func (a *App) DisconnectMcpClient(serverName string) {
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Images are data URLs sent with Content to multimodal models. They are
+	// kept in memory only and never returned to the frontend.
+	Images []string `json:"-"`
 }
 
 // ResponseFormat struct to hold the response format for the LLM.
@@ -612,24 +820,42 @@ type ChatCompletionRequest struct {
 	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 	NPredict       int             `json:"n_predict,omitempty"`
 	AddBos         bool            `json:"add_bos"`
+	SamplingSettings
+	*OutputConstraint
+}
+
+// generationParams holds the per-request settings that shape a completion and
+// are recorded alongside the generated message.
+type generationParams struct {
+	sampling   SamplingSettings
+	constraint *OutputConstraint
+}
+
+// generationParamsForSession resolves the sampling and output constraint for the
+// next reply in a session.
+func (a *App) generationParamsForSession(sessionID int64) generationParams {
+	return generationParams{
+		sampling:   a.samplingForSession(sessionID),
+		constraint: a.constraintForSession(sessionID),
+	}
 }
 
 // LoadChatHistory loads the chat history for a given session into memory.
-func (a *App) LoadChatHistory(sessionId int64) ([]ChatMessage, error) {
+func (a *App) LoadChatHistory(sessionId int64) ([]ChatHistoryMessage, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	wailsruntime.LogInfof(a.ctx, "Loading chat history for session %d", sessionId)
+	a.logInfof("Loading chat history for session %d", sessionId)
 
 	history, err := a.db.GetChatMessages(sessionId)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error getting chat messages from db: %s", err.Error())
+		a.logErrorf("Error getting chat messages from db: %s", err.Error())
 		return nil, err
 	}
-	wailsruntime.LogInfof(a.ctx, "Loaded %d messages from db for session %d. Content: %+v", len(history), sessionId, history)
+	a.logInfof("Loaded %d messages from db for session %d. Content: %+v", len(history), sessionId, history)
 
 	session, err := a.db.GetChatSession(sessionId)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error getting chat session from db: %s", err.Error())
+		a.logErrorf("Error getting chat session from db: %s", err.Error())
 		return nil, err
 	}
 
@@ -642,7 +868,10 @@ func (a *App) LoadChatHistory(sessionId int64) ([]ChatMessage, error) {
 	}
 
 	// Create a cleaned version of the history for the in-memory context.
+	// User messages get their attachments back as they were sent; images only
+	// if the model can see them.
 	cleanedHistory := make([]ChatMessage, len(history))
+	sendImages := a.modelSupportsImages()
 	for i, msg := range history {
 		if msg.Role == "assistant" {
 			cleanedHistory[i] = ChatMessage{
@@ -650,18 +879,20 @@ func (a *App) LoadChatHistory(sessionId int64) ([]ChatMessage, error) {
 				Content: stripThinkTags(msg.Content),
 			}
 		} else {
-			cleanedHistory[i] = msg
+			cleanedHistory[i] = a.sentMessage(msg, sendImages)
 		}
 	}
 
 	conv.mu.Lock()
 	conv.messages = cleanedHistory // Use the cleaned history for the in-memory context
 	conv.systemPrompt = session.SystemPrompt
+	conv.sampling = session.Sampling
+	conv.constraint = session.OutputConstraint
 	conv.mu.Unlock()
-	wailsruntime.LogInfof(a.ctx, "Updated conversation in memory for session %d with cleaned history. System Prompt: '%s'", sessionId, conv.systemPrompt)
+	a.logInfof("Updated conversation in memory for session %d with cleaned history. System Prompt: '%s'", sessionId, conv.systemPrompt)
 
 	if history == nil {
-		return []ChatMessage{}, nil
+		return []ChatHistoryMessage{}, nil
 	}
 
 	// Return the original history to the frontend for display
@@ -669,21 +900,54 @@ func (a *App) LoadChatHistory(sessionId int64) ([]ChatMessage, error) {
 }
 
 // HandleChat is the main entry point for handling a user's message.
-func (a *App) HandleChat(sessionId int64, message string) {
+func (a *App) HandleChat(sessionId int64, message string) error {
+	return a.HandleChatWithOptions(sessionId, message, ChatOptions{})
+}
+
+// HandleChatWithOptions handles a user's message with per-message options, such
+// as an output constraint that applies to this reply only. It returns an error
+// if the message could not be sent, e.g. because its output constraint is
+// invalid or its attachments could not be read or exceed the token budget; the
+// reply itself is streamed.
+func (a *App) HandleChatWithOptions(sessionId int64, message string, options ChatOptions) error {
 	conv, ok := a.getConversation(sessionId)
 	if !ok {
-		wailsruntime.LogErrorf(a.ctx, "Conversation with ID %d not found.", sessionId)
-		return
+		a.logErrorf("Conversation with ID %d not found.", sessionId)
+		return fmt.Errorf("conversation with ID %d not found", sessionId)
+	}
+	if err := options.OutputConstraint.Validate(); err != nil {
+		a.logErrorf("Invalid output constraint for session %d: %v", sessionId, err)
+		return fmt.Errorf("invalid output constraint: %w", err)
+	}
+
+	files, attachments, images, err := a.prepareAttachments(sessionId, options.Attachments)
+	if err != nil {
+		a.logErrorf("Message to session %d not sent: %v", sessionId, err)
+		return err
 	}
 
-	userMessage := ChatMessage{Role: "user", Content: message}
+	userMessage := ChatMessage{Role: "user", Content: withAttachments(message, files), Images: images}
 	conv.mu.Lock()
+	conv.turnOptions = options
+	history := append([]ChatMessage(nil), conv.messages...)
 	conv.messages = append(conv.messages, userMessage)
-	if err := a.db.SaveChatMessage(sessionId, "user", message); err != nil {
+	userMessageID, err := a.db.SaveChatMessage(sessionId, "user", message)
+	if err != nil {
 		conv.mu.Unlock()
-		wailsruntime.LogErrorf(a.ctx, "Error saving user message: %s", err.Error())
-		return
+		a.logErrorf("Error saving user message: %s", err.Error())
+		return err
 	}
+	if err := a.db.SaveMessageAttachments(sessionId, userMessageID, attachments); err != nil {
+		a.logErrorf("Error saving attachments of message %d: %s", userMessageID, err.Error())
+	}
+	a.linkAttachments(attachments, userMessageID)
+	conv.mu.Unlock()
+
+	trace := a.startTrace(sessionId, userMessageID)
+	citations := a.retrieveForMessage(sessionId, userMessageID, message)
+	conv.mu.Lock()
+	conv.trace = trace
+	conv.citations = citations
 	conv.mu.Unlock()
 
 	// If this is the first user message, generate and set the session name
@@ -691,40 +955,61 @@ func (a *App) HandleChat(sessionId int64, message string) {
 		if len(conv.messages) == 1 {
 			newName, err := a.generateSessionName(message)
 			if err != nil {
-				wailsruntime.LogErrorf(a.ctx, "Error generating session name: %s", err.Error())
+				a.logErrorf("Error generating session name: %s", err.Error())
 				return
 			}
 			err = a.db.UpdateChatSessionName(sessionId, newName)
 			if err != nil {
-				wailsruntime.LogErrorf(a.ctx, "Error updating session name: %s", err.Error())
+				a.logErrorf("Error updating session name: %s", err.Error())
 				return
 			}
-			wailsruntime.EventsEmit(a.ctx, "sessionNameUpdated", map[string]interface{}{"sessionID": sessionId, "newName": newName})
+			a.emit(events.SessionNameUpdated, map[string]interface{}{"sessionID": sessionId, "newName": newName})
 		}
 	}()
 
 	// --- Two-Agent System Logic ---
-	needsTools, err := a.router.NeedsTools(message)
+	// The user can force or forbid tools for a single message; otherwise the
+	// Router Agent decides.
+	var decision RouterDecision
+	routeStarted := time.Now()
+	switch options.ToolMode {
+	case ToolModeForce:
+		decision = RouterDecision{NeedsTools: true, Mode: RouterModeUser, Reason: "tools forced by user"}
+	case ToolModeNone:
+		decision = RouterDecision{NeedsTools: false, Mode: RouterModeUser, Reason: "tools disabled by user"}
+	default:
+		decision, err = a.router.Route(message, history)
+		if err != nil {
+			a.logErrorf("Error checking for tool needs: %v", err)
+			decision = RouterDecision{Mode: a.config.RouterMode, Reason: fmt.Sprintf("router failed: %v", err)}
+		}
+	}
+
+	trace.RouterDecision(decision, routeStarted, err)
+	if errDb := a.db.SetChatMessageRouterDecision(userMessageID, &decision); errDb != nil {
+		a.logErrorf("Error saving router decision: %s", errDb.Error())
+	}
+
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error checking for tool needs: %v", err)
 		// Fallback to standard chat if router agent fails
 		a.standardChat(sessionId, message)
-		return
+		return nil
 	}
 
-	if needsTools {
-		wailsruntime.LogInfo(a.ctx, "Router Agent decided tools are needed. Starting Tool-Using Agent.")
-		a.toolAgentChat(sessionId)
+	if decision.NeedsTools {
+		a.logInfof("Router Agent decided tools are needed (%s). Starting Tool-Using Agent.", decision.Mode)
+		a.toolAgentChat(sessionId, message, decision.Tools)
 	} else {
-		wailsruntime.LogInfo(a.ctx, "Router Agent decided no tools are needed. Proceeding with standard chat.")
+		a.logInfof("Router Agent decided no tools are needed (%s). Proceeding with standard chat.", decision.Mode)
 		a.standardChat(sessionId, message)
 	}
+	return nil
 }
 
 func (a *App) standardChat(sessionId int64, message string) {
 	conv, ok := a.getConversation(sessionId)
 	if !ok {
-		wailsruntime.LogErrorf(a.ctx, "Conversation with ID %d not found.", sessionId)
+		a.logErrorf("Conversation with ID %d not found.", sessionId)
 		return
 	}
 
@@ -733,6 +1018,9 @@ func (a *App) standardChat(sessionId int64, message string) {
 	if conv.systemPrompt != "" {
 		messagesForLLM = append(messagesForLLM, ChatMessage{Role: "system", Content: conv.systemPrompt})
 	}
+	if retrieved, ok := retrievalMessage(conv.citations); ok {
+		messagesForLLM = append(messagesForLLM, retrieved)
+	}
 	messagesForLLM = append(messagesForLLM, conv.messages...)
 	conv.mu.Unlock()
 
@@ -740,84 +1028,62 @@ func (a *App) standardChat(sessionId int64, message string) {
 	a.streamResponse(sessionId, messagesForLLM, nil)
 }
 
-func (a *App) toolAgentChat(sessionId int64) {
+// toolAgentChat runs the Tool-Using Agent. Only the tools that rank best for
+// the message are offered; if allowedTools is not empty, those are offered
+// first. The model can call more_tools to see the rest.
+func (a *App) toolAgentChat(sessionId int64, message string, allowedTools []string) {
 	conv, ok := a.getConversation(sessionId)
 	if !ok {
-		wailsruntime.LogErrorf(a.ctx, "Conversation with ID %d not found.", sessionId)
+		a.logErrorf("Conversation with ID %d not found.", sessionId)
 		return
 	}
 
-	// Check if the current model uses the new Harmony (schema-based) tool format.
-	useHarmonyTools := false
-	if settings, ok := a.config.ModelSettings[a.config.SelectedModel]; ok {
-		useHarmonyTools = settings.UseHarmonyTools
-	}
-
-	var toolSystemPrompt string
-	var responseFormat *ResponseFormat
-
-	if useHarmonyTools {
-		wailsruntime.LogInfo(a.ctx, "Using Harmony (schema-based) tool calling.")
-		toolSchema, err := a.router.GetToolManifestSchema()
-		if err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Tool Agent: Error getting tool schema: %v", err)
-			a.standardChat(sessionId, "") // Fallback
-			return
-		}
-		responseFormat = &ResponseFormat{
-			Type:   "json_object",
-			Schema: toolSchema,
-		}
-		toolSystemPrompt = "You have access to a set of tools to answer the user's request. To use a tool, you must respond in a JSON format that adheres to the provided schema."
-	} else {
-		wailsruntime.LogInfo(a.ctx, "Using legacy (text-based) tool calling.")
-		manifestText, err := a.router.GetToolManifestText()
-		if err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Tool Agent: Error getting tool manifest text: %v", err)
-			a.standardChat(sessionId, "") // Fallback
-			return
-		}
-		toolSystemPrompt = manifestText
+	run, err := a.newToolAgentRun(sessionId, message, allowedTools, a.turnTrace(sessionId))
+	if err != nil {
+		a.logErrorf("Tool Agent: Error getting tool manifest: %v", err)
+		a.standardChat(sessionId, "") // Fallback
+		return
 	}
 
 	// Agentic loop
-	maxIterations := a.config.ToolCallIterations
-	if maxIterations <= 0 {
-		maxIterations = 5 // Default
-	}
+	maxIterations := a.maxToolIterations()
+	sampling := a.samplingForSession(sessionId)
 	for i := 0; i < maxIterations; i++ {
 		var messagesForLLM []ChatMessage
-		messagesForLLM = append(messagesForLLM, ChatMessage{Role: "system", Content: toolSystemPrompt})
+		messagesForLLM = append(messagesForLLM, ChatMessage{Role: "system", Content: run.prompt})
 		conv.mu.Lock()
+		if retrieved, ok := retrievalMessage(conv.citations); ok {
+			messagesForLLM = append(messagesForLLM, retrieved)
+		}
 		prunedHistory := a.pruneHistory(conv.messages)
 		conv.mu.Unlock()
 		messagesForLLM = append(messagesForLLM, prunedHistory...)
 
 		// Call LLM (non-streaming) with the appropriate response format
-		llmResponse, err := a.makeLLMRequest(messagesForLLM, false, responseFormat)
+		llmStarted := time.Now()
+		llmResponse, err := a.makeLLMRequest(messagesForLLM, false, run.responseFormat, sampling)
+		run.trace.LLMCall("tool_agent", llmStarted, llmResponse.Usage, err)
 		if err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Tool Agent: Error making LLM request: %v", err)
+			a.logErrorf("Tool Agent: Error making LLM request: %v", err)
+			a.finishTurnTrace(sessionId, TraceOutcomeError)
 			return
 		}
 
-		if llmResponse.ReasoningContent != "" {
-			wailsruntime.EventsEmit(a.ctx, "reasoning-stream", llmResponse.ReasoningContent)
-		}
-
-		var toolCallJSON string
-		firstBrace := strings.Index(llmResponse.Content, "{")
-		lastBrace := strings.LastIndex(llmResponse.Content, "}")
-		if firstBrace != -1 && lastBrace != -1 && lastBrace > firstBrace {
-			toolCallJSON = llmResponse.Content[firstBrace : lastBrace+1]
+		responseContent, reasoningContent, toolCallJSON := run.parseResponse(llmResponse)
+		if reasoningContent != "" {
+			a.emit(events.ReasoningStream, reasoningContent)
 		}
 
 		if toolCallJSON != "" {
-			messageToSave := llmResponse.Content
-			if llmResponse.ReasoningContent != "" {
-				messageToSave = fmt.Sprintf("<think>%s</think>\n%s", llmResponse.ReasoningContent, llmResponse.Content)
+			messageToSave := responseContent
+			if reasoningContent != "" {
+				messageToSave = fmt.Sprintf("<think>%s</think>\n%s", reasoningContent, responseContent)
 			}
-			if errDb := a.db.SaveChatMessage(sessionId, "assistant", messageToSave); errDb != nil {
-				wailsruntime.LogErrorf(a.ctx, "Error saving assistant's tool call message: %s", errDb.Error())
+			messageID, errDb := a.db.SaveChatMessage(sessionId, "assistant", messageToSave)
+			if errDb != nil {
+				a.logErrorf("Error saving assistant's tool call message: %s", errDb.Error())
+			} else if errDb := a.db.SetChatMessageSampling(messageID, &sampling); errDb != nil {
+				a.logErrorf("Error saving sampling params for tool call message: %s", errDb.Error())
 			}
 
 			cleanedResponse := stripThinkTags(messageToSave)
@@ -826,56 +1092,91 @@ func (a *App) toolAgentChat(sessionId int64) {
 			conv.messages = append(conv.messages, assistantMessage)
 			conv.mu.Unlock()
 
-			wailsruntime.LogInfof(a.ctx, "Tool Agent: Detected tool call: %s", toolCallJSON)
+			step := run.executeToolCall(toolCallJSON, messageID)
 
-			result, err := a.router.ExecuteToolCall(toolCallJSON)
-			var toolResultContent string
-			if err != nil {
-				wailsruntime.LogErrorf(a.ctx, "Tool Agent: Error executing tool call: %v", err)
-				toolResultContent = fmt.Sprintf("Error executing tool: %v", err)
-			} else {
-				var contentBuilder strings.Builder
-				for _, content := range result.Content {
-					if textContent, ok := content.(mcp.TextContent); ok {
-						contentBuilder.WriteString(textContent.Text)
-					}
-				}
-				toolResultContent = contentBuilder.String()
-			}
-
-			toolMessage := ChatMessage{Role: "user", Content: toolResultContent}
+			toolMessage := ChatMessage{Role: "user", Content: step.Result, Images: step.Images}
 			conv.mu.Lock()
 			conv.messages = append(conv.messages, toolMessage)
-			if err := a.db.SaveChatMessage(sessionId, "user", toolResultContent); err != nil {
-				wailsruntime.LogErrorf(a.ctx, "Error saving tool message: %s", err.Error())
+			if _, err := a.db.SaveChatMessage(sessionId, "user", step.Result); err != nil {
+				a.logErrorf("Error saving tool message: %s", err.Error())
 			}
 			conv.mu.Unlock()
-			wailsruntime.EventsEmit(a.ctx, "chat-stream", toolResultContent)
+			a.emit(events.ChatStream, step.Result)
+			if step.FinalAnswer {
+				a.logInfo("Tool Agent: Stopping the loop. Generating final answer via streaming.")
+				a.toolAgentFinalAnswer(sessionId, run.prompt, loopFinalAnswerPrompt)
+				return
+			}
 			continue
 		}
 
-		wailsruntime.LogInfo(a.ctx, "Tool Agent: No more tool calls detected. Generating final answer via streaming.")
-		var finalMessages []ChatMessage
-		finalMessages = append(finalMessages, ChatMessage{Role: "system", Content: toolSystemPrompt})
-		conv.mu.Lock()
-		prunedHistory = a.pruneHistory(conv.messages)
-		conv.mu.Unlock()
-		finalMessages = append(finalMessages, prunedHistory...)
-		a.streamResponse(sessionId, finalMessages, nil) // No response format for final answer
+		a.logInfo("Tool Agent: No more tool calls detected. Generating final answer via streaming.")
+		a.toolAgentFinalAnswer(sessionId, run.prompt, "")
 		return
 	}
 
-	wailsruntime.LogWarningf(a.ctx, "Tool Agent: Exceeded max iterations (%d). Ending loop.", maxIterations)
-	errorMessage := fmt.Sprintf("The assistant reached the maximum number of tool calls (%d) without providing a final answer. The task has been stopped.", maxIterations)
+	a.logWarningf("Tool Agent: Exceeded max iterations (%d). Ending loop.", maxIterations)
+	errorMessage := maxIterationsMessage(maxIterations)
 	assistantMessage := ChatMessage{Role: "assistant", Content: errorMessage}
 	conv.mu.Lock()
 	conv.messages = append(conv.messages, assistantMessage)
-	if err := a.db.SaveChatMessage(sessionId, "assistant", errorMessage); err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error saving max iterations error message: %s", err.Error())
+	if _, err := a.db.SaveChatMessage(sessionId, "assistant", errorMessage); err != nil {
+		a.logErrorf("Error saving max iterations error message: %s", err.Error())
 	}
 	conv.mu.Unlock()
-	wailsruntime.EventsEmit(a.ctx, "chat-stream", errorMessage)
-	wailsruntime.EventsEmit(a.ctx, "chat-stream", nil)
+	a.finishTurnTrace(sessionId, TraceOutcomeMaxIterations)
+	a.emit(events.ChatStream, errorMessage)
+	a.emit(events.ChatStream, nil)
+}
+
+// toolAgentFinalAnswer streams the Tool-Using Agent's final answer. A non-empty
+// note is appended as a system message, e.g. to stop a tool loop.
+func (a *App) toolAgentFinalAnswer(sessionId int64, toolSystemPrompt, note string) {
+	conv, ok := a.getConversation(sessionId)
+	if !ok {
+		a.logErrorf("Conversation with ID %d not found.", sessionId)
+		return
+	}
+	var finalMessages []ChatMessage
+	finalMessages = append(finalMessages, ChatMessage{Role: "system", Content: toolSystemPrompt})
+	conv.mu.Lock()
+	if retrieved, ok := retrievalMessage(conv.citations); ok {
+		finalMessages = append(finalMessages, retrieved)
+	}
+	prunedHistory := a.pruneHistory(conv.messages)
+	conv.mu.Unlock()
+	finalMessages = append(finalMessages, prunedHistory...)
+	if note != "" {
+		finalMessages = append(finalMessages, ChatMessage{Role: "system", Content: note})
+	}
+	a.streamResponse(sessionId, finalMessages, nil) // No response format for final answer
+}
+
+// toolAgentPrompt builds the Tool-Using Agent's system prompt for the selected
+// tools and, for Harmony models, the response format that constrains the call.
+func (a *App) toolAgentPrompt(selection *ToolSelection, useHarmonyTools bool) (string, *ResponseFormat, error) {
+	if useHarmonyTools {
+		a.logInfo("Using Harmony (schema-based) tool calling.")
+		toolSchema, err := a.router.GetToolManifestSchema(selection)
+		if err != nil {
+			return "", nil, err
+		}
+		prompt := "You have access to a set of tools to answer the user's request. To use a tool, you must respond in a JSON format that adheres to the provided schema."
+		if selection.Remaining > 0 {
+			prompt += fmt.Sprintf(" If none of the tools fit, call %s with a 'query' argument describing the tool you need.", moreToolsName)
+		}
+		if selection.Paging {
+			prompt += fmt.Sprintf(" To read the rest of a truncated result, call %s with its 'handle' and 'offset'.", readMoreName)
+		}
+		return prompt, &ResponseFormat{Type: "json_object", Schema: toolSchema}, nil
+	}
+
+	a.logInfo("Using legacy (text-based) tool calling.")
+	manifestText, err := a.router.GetToolManifestText(selection)
+	if err != nil {
+		return "", nil, err
+	}
+	return manifestText, nil, nil
 }
 
 func (a *App) pruneHistory(history []ChatMessage) []ChatMessage {
@@ -893,23 +1194,25 @@ func (a *App) pruneHistory(history []ChatMessage) []ChatMessage {
 type LLMResponse struct {
 	Content          string
 	ReasoningContent string
+	Usage            tokenUsage
 }
 
 // makeLLMRequest sends a request to the LLM and returns the complete response content.
-func (a *App) makeLLMRequest(messages []ChatMessage, stream bool, responseFormat *ResponseFormat) (LLMResponse, error) {
+func (a *App) makeLLMRequest(messages []ChatMessage, stream bool, responseFormat *ResponseFormat, sampling SamplingSettings) (LLMResponse, error) {
 	reqBody := ChatCompletionRequest{
-		Messages:       messages,
-		Stream:         stream,
-		ResponseFormat: responseFormat,
-		NPredict:       -1,
-		AddBos:         false,
+		Messages:         messages,
+		Stream:           stream,
+		ResponseFormat:   responseFormat,
+		NPredict:         sampling.nPredict(),
+		AddBos:           false,
+		SamplingSettings: sampling,
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		return LLMResponse{}, fmt.Errorf("error marshalling request body: %w", err)
 	}
 
-	resp, err := http.Post("http://localhost:8080/v1/chat/completions", "application/json", bytes.NewReader(jsonBody))
+	resp, err := http.Post(llamaServerURL+"/v1/chat/completions", "application/json", bytes.NewReader(jsonBody))
 	if err != nil {
 		return LLMResponse{}, fmt.Errorf("error making POST request to LLM: %w", err)
 	}
@@ -927,6 +1230,7 @@ func (a *App) makeLLMRequest(messages []ChatMessage, stream bool, responseFormat
 				ReasoningContent string `json:"reasoning_content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage tokenUsage `json:"usage"`
 	}
 
 	if err := json.Unmarshal(body, &result); err != nil {
@@ -937,6 +1241,7 @@ func (a *App) makeLLMRequest(messages []ChatMessage, stream bool, responseFormat
 		return LLMResponse{
 			Content:          result.Choices[0].Message.Content,
 			ReasoningContent: result.Choices[0].Message.ReasoningContent,
+			Usage:            result.Usage,
 		}, nil
 	}
 
@@ -947,33 +1252,45 @@ func (a *App) makeLLMRequest(messages []ChatMessage, stream bool, responseFormat
 func (a *App) streamResponse(sessionID int64, messages []ChatMessage, responseFormat *ResponseFormat) {
 	conv, ok := a.getConversation(sessionID)
 	if !ok {
-		wailsruntime.LogErrorf(a.ctx, "Conversation with ID %d not found.", sessionID)
+		a.logErrorf("Conversation with ID %d not found.", sessionID)
+		a.finishTurnTrace(sessionID, TraceOutcomeError)
+		a.emit(events.ChatStream, nil)
 		return
 	}
 
+	params := a.generationParamsForSession(sessionID)
+	started := time.Now()
 	reqBody := ChatCompletionRequest{
-		Messages:       messages,
-		Stream:         true,
-		ResponseFormat: responseFormat,
-		NPredict:       -1,
-		AddBos:         false,
+		Messages:         messages,
+		Stream:           true,
+		ResponseFormat:   responseFormat,
+		NPredict:         params.sampling.nPredict(),
+		AddBos:           false,
+		SamplingSettings: params.sampling,
+		OutputConstraint: params.constraint,
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error marshalling request body: %s", err.Error())
+		a.logErrorf("Error marshalling request body: %s", err.Error())
+		a.turnTrace(sessionID).LLMCall("answer", started, tokenUsage{}, err)
+		a.finishTurnTrace(sessionID, TraceOutcomeError)
+		a.emit(events.ChatStream, nil)
 		return
 	}
 
-	resp, err := http.Post("http://localhost:8080/v1/chat/completions", "application/json", strings.NewReader(string(jsonBody)))
+	resp, err := http.Post(llamaServerURL+"/v1/chat/completions", "application/json", strings.NewReader(string(jsonBody)))
 	if err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error making POST request to LLM: %s", err.Error())
+		a.logErrorf("Error making POST request to LLM: %s", err.Error())
+		a.turnTrace(sessionID).LLMCall("answer", started, tokenUsage{}, err)
+		a.finishTurnTrace(sessionID, TraceOutcomeError)
+		a.emit(events.ChatStream, nil)
 		return
 	}
 	conv.mu.Lock()
 	conv.httpResp = resp
 	conv.mu.Unlock()
 
-	go a.streamHandler(sessionID, resp)
+	go a.streamHandler(sessionID, resp, params, started)
 }
 
 // ChatCompletionChunk models a chunk from the LLM stream.
@@ -984,16 +1301,22 @@ type ChatCompletionChunk struct {
 			ReasoningContent string `json:"reasoning_content"`
 		} `json:"delta"`
 	} `json:"choices"`
+	// The last chunk carries llama-server's timings, and usage if the server reports it.
+	Usage   *tokenUsage `json:"usage,omitempty"`
+	Timings *struct {
+		PromptN    int `json:"prompt_n"`
+		PredictedN int `json:"predicted_n"`
+	} `json:"timings,omitempty"`
 }
 
-func (a *App) streamHandler(sessionID int64, resp *http.Response) {
+func (a *App) streamHandler(sessionID int64, resp *http.Response, params generationParams, started time.Time) {
 	defer resp.Body.Close()
 	scanner := bufio.NewScanner(resp.Body)
 
 	conv, ok := a.getConversation(sessionID)
 	if !ok {
-		wailsruntime.LogErrorf(a.ctx, "Conversation with ID %d not found.", sessionID)
-		wailsruntime.EventsEmit(a.ctx, "chat-stream", nil)
+		a.logErrorf("Conversation with ID %d not found.", sessionID)
+		a.emit(events.ChatStream, nil)
 		return
 	}
 
@@ -1011,20 +1334,58 @@ func (a *App) streamHandler(sessionID int64, resp *http.Response) {
 	defer ticker.Stop()
 
 	go func() {
-		defer wailsruntime.LogDebugf(a.ctx, "Batch sender goroutine for session %d exited.", sessionID)
+		defer a.logDebugf("Batch sender goroutine for session %d exited.", sessionID)
 		for range ticker.C {
 			mu.Lock()
 			if currentChunkBuffer.Len() > 0 {
 				chunkToSend := currentChunkBuffer.String()
 				currentChunkBuffer.Reset()
 				mu.Unlock()
-				wailsruntime.EventsEmit(a.ctx, "chat-stream", chunkToSend)
+				a.emit(events.ChatStream, chunkToSend)
 			} else {
 				mu.Unlock()
 			}
 		}
 	}()
 
+	// Models that emit their reasoning inline (e.g. <think>...</think>) are
+	// split here so the reasoning reaches the frontend live on its own channel.
+	// Harmony models are split by channel instead.
+	contentParser := a.newStreamContentParser(a.config.SelectedModel)
+
+	// With tts_stream, the reply is also spoken as it streams.
+	speaker := a.newReplySpeaker(sessionID)
+
+	appendContent := func(content string) {
+		if content == "" {
+			return
+		}
+		speaker.Feed(content)
+		mu.Lock()
+		currentChunkBuffer.WriteString(content)
+		fullResponseBuilder.WriteString(content)
+
+		if currentChunkBuffer.Len() >= maxBatchChars {
+			chunkToSend := currentChunkBuffer.String()
+			currentChunkBuffer.Reset()
+			mu.Unlock()
+			a.emit(events.ChatStream, chunkToSend)
+		} else {
+			mu.Unlock()
+		}
+	}
+
+	appendReasoning := func(reasoning string) {
+		if reasoning == "" {
+			return
+		}
+		mu.Lock()
+		fullReasoningBuilder.WriteString(reasoning)
+		mu.Unlock()
+		a.emit(events.ReasoningStream, reasoning)
+	}
+
+	var usage tokenUsage
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "data: ") {
@@ -1035,48 +1396,45 @@ func (a *App) streamHandler(sessionID int64, resp *http.Response) {
 
 			var chunk ChatCompletionChunk
 			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-				wailsruntime.LogErrorf(a.ctx, "Error unmarshalling stream data: %s", err.Error())
+				a.logErrorf("Error unmarshalling stream data: %s", err.Error())
 				continue
 			}
 
+			if chunk.Usage != nil {
+				usage = *chunk.Usage
+			} else if chunk.Timings != nil {
+				usage = tokenUsage{PromptTokens: chunk.Timings.PromptN, CompletionTokens: chunk.Timings.PredictedN}
+			}
+
 			if len(chunk.Choices) > 0 {
 				delta := chunk.Choices[0].Delta
 				if delta.Content != "" {
-					content := delta.Content
-					a.tokenCounter.CountAndMeasure(content)
-
-					mu.Lock()
-					currentChunkBuffer.WriteString(content)
-					fullResponseBuilder.WriteString(content)
-
-					if currentChunkBuffer.Len() >= maxBatchChars {
-						chunkToSend := currentChunkBuffer.String()
-						currentChunkBuffer.Reset()
-						mu.Unlock()
-						wailsruntime.EventsEmit(a.ctx, "chat-stream", chunkToSend)
-					} else {
-						mu.Unlock()
-					}
-				}
-				if delta.ReasoningContent != "" {
-					reasoning := delta.ReasoningContent
-					mu.Lock()
-					fullReasoningBuilder.WriteString(reasoning)
-					mu.Unlock()
-					wailsruntime.EventsEmit(a.ctx, "reasoning-stream", reasoning)
+					a.tokenCounter.CountAndMeasure(delta.Content)
+					content, reasoning := contentParser.Feed(delta.Content)
+					appendReasoning(reasoning)
+					appendContent(content)
 				}
+				appendReasoning(delta.ReasoningContent)
 			}
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error reading stream for session %d: %s", sessionID, err)
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		a.logErrorf("Error reading stream for session %d: %s", sessionID, scanErr)
 	}
+	a.turnTrace(sessionID).LLMCall("answer", started, usage, scanErr)
+
+	// Release anything the parser held back waiting for a possible tag.
+	remainingContent, remainingReasoning := contentParser.Flush()
+	appendReasoning(remainingReasoning)
+	appendContent(remainingContent)
+	speaker.Close()
 
 	// Flush any remaining text in the buffer
 	mu.Lock()
 	if currentChunkBuffer.Len() > 0 {
-		wailsruntime.EventsEmit(a.ctx, "chat-stream", currentChunkBuffer.String())
+		a.emit(events.ChatStream, currentChunkBuffer.String())
 	}
 	mu.Unlock()
 
@@ -1091,9 +1449,34 @@ func (a *App) streamHandler(sessionID int64, resp *http.Response) {
 		finalMessageToSave = fmt.Sprintf("<think>%s</think>\n%s", fullReasoning, fullResponse)
 	}
 
-	// Save the full message (with tags) to the database first.
-	if err := a.db.SaveChatMessage(sessionID, "assistant", finalMessageToSave); err != nil {
-		wailsruntime.LogErrorf(a.ctx, "Error saving assistant message: %s", err.Error())
+	// Save the full message (with tags) to the database first, along with the
+	// sampling parameters it was generated with.
+	messageID, err := a.db.SaveChatMessage(sessionID, "assistant", finalMessageToSave)
+	if err != nil {
+		a.logErrorf("Error saving assistant message: %s", err.Error())
+	} else if err := a.db.SetChatMessageSampling(messageID, &params.sampling); err != nil {
+		a.logErrorf("Error saving sampling params for assistant message: %s", err.Error())
+	}
+
+	// Check the reply against the JSON Schema it was constrained with. The
+	// sampler enforces the schema, but a reply can still be cut short by
+	// max_tokens, a stop string or a user abort.
+	if params.constraint != nil && len(params.constraint.JSONSchema) > 0 {
+		validationErrors := params.constraint.ValidateResponse(stripThinkTags(fullResponse))
+		if len(validationErrors) > 0 {
+			a.logWarningf("Reply for session %d failed schema validation: %v", sessionID, validationErrors)
+			if messageID != 0 {
+				if err := a.db.SetChatMessageValidationErrors(messageID, validationErrors); err != nil {
+					a.logErrorf("Error saving validation errors: %s", err.Error())
+				}
+			}
+		}
+		a.emit(events.OutputValidation, map[string]interface{}{
+			"sessionID": sessionID,
+			"messageID": messageID,
+			"valid":     len(validationErrors) == 0,
+			"errors":    validationErrors,
+		})
 	}
 
 	// Now, create a cleaned version for the in-memory context.
@@ -1105,16 +1488,17 @@ func (a *App) streamHandler(sessionID int64, resp *http.Response) {
 	conv.messages = append(conv.messages, assistantMessage)
 	conv.TotalTokens = a.tokenCounter.GetSessionTotal(sessionID)
 	conv.mu.Unlock()
+	a.finishTurnTrace(sessionID, TraceOutcomeAnswered)
 
 	// Finally, send the end-of-stream signal to the frontend
-	wailsruntime.EventsEmit(a.ctx, "chat-stream", nil)
+	a.emit(events.ChatStream, nil)
 }
 
 // StopStream stops the current chat stream.
 func (a *App) StopStream(sessionID int64) {
 	conv, ok := a.getConversation(sessionID)
 	if !ok {
-		wailsruntime.LogErrorf(a.ctx, "Conversation with ID %d not found.", sessionID)
+		a.logErrorf("Conversation with ID %d not found.", sessionID)
 		return
 	}
 	conv.mu.Lock()
@@ -1131,6 +1515,18 @@ func (a *App) getConversation(sessionID int64) (*Conversation, bool) {
 	return conv, ok
 }
 
+// appendToOpenConversation adds messages saved outside the chat window to the
+// in-memory context of a session, if the session is loaded.
+func (a *App) appendToOpenConversation(sessionID int64, messages ...ChatMessage) {
+	conv, ok := a.getConversation(sessionID)
+	if !ok {
+		return
+	}
+	conv.mu.Lock()
+	conv.messages = append(conv.messages, messages...)
+	conv.mu.Unlock()
+}
+
 // stripThinkTags removes <think> tags and surrounding whitespace from a string.
 func stripThinkTags(content string) string {
 	reThink := regexp.MustCompile(`(?s)<think>.*?</think>`)
@@ -1144,9 +1540,8 @@ func (a *App) generateSessionName(message string) (string, error) {
 	reThink := regexp.MustCompile(`(?s)<think>.*?</think>`)
 	cleanedMessage := reThink.ReplaceAllString(message, "")
 
-	// Strip <|...|> tags from the message
-	reChannel := regexp.MustCompile(`(?s)<\|.*?\|>`)
-	cleanedMessage = reChannel.ReplaceAllString(cleanedMessage, "")
+	// Keep only the user-facing text of a Harmony-formatted message
+	cleanedMessage = harmonyVisibleText(cleanedMessage)
 
 	// Also remove any leading/trailing whitespace that might be left
 	cleanedMessage = strings.TrimSpace(cleanedMessage)
@@ -1159,18 +1554,20 @@ func (a *App) generateSessionName(message string) (string, error) {
 }
 
 // Existing methods for artifacts should now delegate to the service:
-func (a *App) AddArtifact(sessionID string, artifactType artifacts.ArtifactType, name string, contentBase64 string) (*artifacts.Artifact, error) {
+func (a *App) AddArtifact(sessionID int64, artifactType artifacts.ArtifactType, name string, contentBase64 string) (*artifacts.Artifact, error) {
 	if a.ArtifactService == nil {
 		return nil, fmt.Errorf("artifact service not initialized")
 	}
 	return a.ArtifactService.AddArtifact(sessionID, artifactType, name, contentBase64)
 }
 
-func (a *App) ListArtifacts(sessionID string) ([]*artifacts.Artifact, error) {
+// ListArtifacts returns the artifacts of a session, or only those of one of
+// its messages if messageID is not 0.
+func (a *App) ListArtifacts(sessionID, messageID int64) ([]*artifacts.Artifact, error) {
 	if a.ArtifactService == nil {
 		return nil, fmt.Errorf("artifact service not initialized")
 	}
-	return a.ArtifactService.ListArtifacts(sessionID)
+	return a.ArtifactService.ListArtifacts(sessionID, messageID)
 }
 
 func (a *App) DeleteArtifact(artifactID string) error {
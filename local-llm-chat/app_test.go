@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"local-llm-chat/events"
+)
+
+// fakeLlamaServer streams reply as llama-server does, one word per chunk,
+// after a reasoning_content chunk.
+func fakeLlamaServer(t *testing.T, reasoning, reply string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		var request ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Stream {
+			http.Error(w, "expected a streaming chat completion", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeChunk := func(delta map[string]string) {
+			chunk, _ := json.Marshal(map[string]interface{}{"choices": []interface{}{map[string]interface{}{"delta": delta}}})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		writeChunk(map[string]string{"reasoning_content": reasoning})
+		for i, word := range strings.SplitAfter(reply, " ") {
+			writeChunk(map[string]string{"content": word})
+			if i == 0 {
+				w.(http.Flusher).Flush()
+			}
+		}
+		fmt.Fprint(w, "data: {\"choices\":[],\"timings\":{\"prompt_n\":12,\"predicted_n\":5}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestApp starts a headless app in a temporary directory, with its
+// llama-server at serverURL.
+func newTestApp(t *testing.T, serverURL string) *App {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	defaultURL := llamaServerURL
+	llamaServerURL = serverURL
+	t.Cleanup(func() { llamaServerURL = defaultURL })
+
+	app, err := newHeadlessApp(events.LogError, nil)
+	if err != nil {
+		t.Fatalf("newHeadlessApp: %v", err)
+	}
+	t.Cleanup(func() { app.shutdown(context.Background()) })
+	return app
+}
+
+// TestHandleChatHeadless drives a chat turn through the core without a window:
+// everything the frontend would see arrives on the event bus.
+func TestHandleChatHeadless(t *testing.T) {
+	const reply = "Paris is the capital of France."
+	server := fakeLlamaServer(t, "The user asks about geography.", reply)
+	app := newTestApp(t, server.URL)
+
+	var recorder events.Recorder
+	app.bus.Subscribe(recorder.Handle)
+	replyDone := make(chan struct{})
+	nameDone := make(chan struct{})
+	app.bus.Subscribe(func(event events.Event) {
+		switch {
+		case event.Name == events.ChatStream && event.Data == nil:
+			close(replyDone)
+		case event.Name == events.SessionNameUpdated:
+			close(nameDone)
+		}
+	}, events.ChatStream, events.SessionNameUpdated)
+
+	sessionID, err := app.NewChat("")
+	if err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if _, err := app.LoadChatHistory(sessionID); err != nil {
+		t.Fatalf("LoadChatHistory: %v", err)
+	}
+	if err := app.HandleChat(sessionID, "What is the capital of France?"); err != nil {
+		t.Fatalf("HandleChat: %v", err)
+	}
+	for _, done := range []chan struct{}{replyDone, nameDone} {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out; recorded events: %+v", recorder.Events())
+		}
+	}
+
+	var streamed strings.Builder
+	for _, event := range recorder.Events(events.ChatStream) {
+		if text, ok := event.Data.(string); ok {
+			streamed.WriteString(text)
+		}
+	}
+	if streamed.String() != reply {
+		t.Errorf("streamed reply = %q, want %q", streamed.String(), reply)
+	}
+	var reasoning strings.Builder
+	for _, event := range recorder.Events(events.ReasoningStream) {
+		reasoning.WriteString(event.Data.(string))
+	}
+	if reasoning.String() != "The user asks about geography." {
+		t.Errorf("streamed reasoning = %q", reasoning.String())
+	}
+	if len(recorder.Events(events.TokenStats)) == 0 {
+		t.Error("no token statistics were published")
+	}
+	nameEvent := recorder.Events(events.SessionNameUpdated)[0].Data.(map[string]interface{})
+	if nameEvent["newName"] != "What is the capital " {
+		t.Errorf("session name = %q", nameEvent["newName"])
+	}
+
+	history, err := app.db.GetChatMessages(sessionID)
+	if err != nil {
+		t.Fatalf("GetChatMessages: %v", err)
+	}
+	if len(history) != 2 || history[0].Role != "user" || history[1].Role != "assistant" {
+		t.Fatalf("saved history = %+v, want the question and the reply", history)
+	}
+	if !strings.Contains(history[1].Content, reply) || !strings.Contains(history[1].Content, "<think>The user asks about geography.</think>") {
+		t.Errorf("saved reply = %q", history[1].Content)
+	}
+	traces, err := app.GetAgentTraces(sessionID)
+	if err != nil || len(traces) != 1 || traces[0].Outcome != TraceOutcomeAnswered {
+		t.Errorf("traces = %+v, %v; want one answered trace", traces, err)
+	}
+}
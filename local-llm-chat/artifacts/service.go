@@ -3,7 +3,6 @@
 package artifacts // <--- ENSURE THIS IS 'package artifacts'
 
 import (
-	"context"
 	"encoding/base64"
 	"fmt"
 	"log"
@@ -13,23 +12,26 @@ import (
 	"sync"
 	"time"
 
+	"local-llm-chat/events"
+
 	"github.com/google/uuid"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // ArtifactService manages the lifecycle of artifacts within the application.
-// It handles storage of artifact metadata (in memory) and content (on disk).
+// It handles storage of artifact metadata (in memory, and in a Store for
+// persistent artifacts once Restore is called) and content (on disk).
 type ArtifactService struct {
 	artifacts    map[string]*Artifact // In-memory store for artifact metadata
 	mu           sync.RWMutex         // Mutex to protect access to the artifacts map
 	artifactsDir string               // Base directory where artifact content files are stored
-	// metadataPath string            // REMOVED: Metadata path for persistence
-	ctx context.Context // Wails runtime context for event emission
+	store        Store                // Keeps persistent artifacts across restarts; nil until Restore
+	bus          *events.Bus          // Receives artifactAdded and artifactDeleted events
+	created      time.Time            // When the service was created; RemoveOrphanFiles keeps newer files
 }
 
 // NewArtifactService creates a new instance of the ArtifactService.
-// It requires the base directory for storing artifact content and the Wails runtime context.
-func NewArtifactService(ctx context.Context, artifactsDir string) *ArtifactService {
+// It requires the event bus to announce changes on and the base directory for storing artifact content.
+func NewArtifactService(bus *events.Bus, artifactsDir string) *ArtifactService {
 	// Ensure the base artifacts directory exists.
 	if err := os.MkdirAll(artifactsDir, 0755); err != nil {
 		log.Printf("ArtifactService: Error creating artifacts directory %s: %v", artifactsDir, err)
@@ -42,37 +44,40 @@ func NewArtifactService(ctx context.Context, artifactsDir string) *ArtifactServi
 	service := &ArtifactService{
 		artifacts:    make(map[string]*Artifact),
 		artifactsDir: artifactsDir,
-		// metadataPath: filepath.Join(artifactsDir, "artifacts_metadata.json"), // REMOVED: Define metadata file path
-		ctx: ctx,
+		bus:          bus,
+		created:      time.Now(),
 	}
 
-	// REMOVED: load existing artifacts metadata on startup
-	// service.loadArtifactsMetadata()
+	// Artifacts of earlier runs are loaded by Restore, once the store is open.
 
 	return service
 }
 
-// REMOVED: saveArtifactsMetadata function definition entirely.
-// func (s *ArtifactService) saveArtifactsMetadata() { ... }
-
-// REMOVED: loadArtifactsMetadata function definition entirely.
-// func (s *ArtifactService) loadArtifactsMetadata() { ... }
-
 // AddArtifact creates and stores a new artifact.
 // It assumes contentBase64 is either actual base64 encoded string data (for files)
 // or simple text content (for tool notifications, etc.).
-func (s *ArtifactService) AddArtifact(sessionID string, artifactType ArtifactType, name string, contentBase64 string) (*Artifact, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *ArtifactService) AddArtifact(sessionID int64, artifactType ArtifactType, name string, contentBase64 string) (*Artifact, error) {
+	return s.AddArtifactWithMetadata(sessionID, artifactType, name, contentBase64, nil)
+}
+
+// AddArtifactWithMetadata works like AddArtifact and additionally stores the
+// given metadata (e.g. mime_type, source_tool) with the artifact.
+func (s *ArtifactService) AddArtifactWithMetadata(sessionID int64, artifactType ArtifactType, name string, contentBase64 string, extraMetadata map[string]interface{}) (*Artifact, error) {
+	return s.AddMessageArtifact(sessionID, 0, "", artifactType, name, contentBase64, extraMetadata)
+}
 
+// AddMessageArtifact works like AddArtifactWithMetadata for an artifact
+// produced by a message of the session, such as a reply read aloud, or by one
+// of its tool calls. toolCallID is empty for artifacts not from a tool.
+func (s *ArtifactService) AddMessageArtifact(sessionID, messageID int64, toolCallID string, artifactType ArtifactType, name string, contentBase64 string, extraMetadata map[string]interface{}) (*Artifact, error) {
 	id := uuid.New().String()
 	// Use a clean filename for the stored artifact. Append a UUID for uniqueness.
-	storedFileName := fmt.Sprintf("%s_%s_%s", sessionID, id, filepath.Base(name))
+	storedFileName := fmt.Sprintf("%d_%s_%s", sessionID, id, filepath.Base(name))
 	contentPath := "" // Initialize as empty, only set if it's a file type
 
 	var contentBytes []byte
-	// For IMAGE or VIDEO types, decode the base64 string and write to disk.
-	if artifactType == TypeImage || artifactType == TypeVideo {
+	// For file types (IMAGE, VIDEO, AUDIO, DOCUMENT), decode the base64 string and write to disk.
+	if artifactType.IsFile() {
 		decoded, decodeErr := base64.StdEncoding.DecodeString(contentBase64)
 		if decodeErr != nil {
 			log.Printf("ArtifactService: AddArtifact: Failed to decode artifact content (invalid base64 for type %s): %v", artifactType, decodeErr)
@@ -109,8 +114,11 @@ func (s *ArtifactService) AddArtifact(sessionID string, artifactType ArtifactTyp
 
 	// Populate metadata based on type
 	metadata := make(map[string]interface{})
+	for key, value := range extraMetadata {
+		metadata[key] = value
+	}
 	metadata["file_name"] = name // Critical for frontend rendering (for file types)
-	if artifactType == TypeImage || artifactType == TypeVideo {
+	if artifactType.IsFile() {
 		metadata["size_bytes"] = len(contentBytes)
 	} else if artifactType == TypeToolNotification {
 		metadata["message"] = contentBase64 // The 'contentBase64' param is the message for this type
@@ -119,37 +127,79 @@ func (s *ArtifactService) AddArtifact(sessionID string, artifactType ArtifactTyp
 	artifact := &Artifact{
 		ID:           id,
 		SessionID:    sessionID,
+		MessageID:    messageID,
+		ToolCallID:   toolCallID,
 		Type:         artifactType,
 		ContentPath:  contentPath,
 		URL:          artifactURL,
 		Metadata:     metadata,
 		Timestamp:    time.Now().Format(time.RFC3339),
-		IsPersistent: false, // Set to false when not using persistence
+		IsPersistent: artifactType.IsPersistent(),
 	}
 
-	s.artifacts[id] = artifact // Store in memory only for current session
+	s.mu.Lock()
+	s.artifacts[id] = artifact
 	log.Printf("ArtifactService: Added new artifact: %+v", artifact)
 
-	// REMOVED: s.saveArtifactsMetadata() // Removed call to save metadata
+	if artifact.IsPersistent && s.store != nil {
+		if err := s.store.SaveArtifact(artifact); err != nil {
+			// The artifact is still usable until the app exits.
+			log.Printf("ArtifactService: AddArtifact: Failed to save artifact %s: %v", id, err)
+		}
+	}
+	s.mu.Unlock()
 
 	// Notify the frontend that a new artifact has been added.
-	if s.ctx != nil {
-		runtime.EventsEmit(s.ctx, "artifactAdded", artifact)
-	}
+	s.bus.Publish(events.ArtifactAdded, artifact)
+
+	return artifact, nil
+}
 
+// GetArtifact returns the artifact with the given ID.
+func (s *ArtifactService) GetArtifact(id string) (*Artifact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	artifact, ok := s.artifacts[id]
+	if !ok {
+		return nil, fmt.Errorf("artifact with ID %s not found", id)
+	}
 	return artifact, nil
 }
 
+// ReadArtifactContent returns the stored content of a file-based artifact.
+func (s *ArtifactService) ReadArtifactContent(id string) ([]byte, error) {
+	artifact, err := s.GetArtifact(id)
+	if err != nil {
+		return nil, err
+	}
+	if artifact.ContentPath == "" {
+		return nil, fmt.Errorf("artifact with ID %s has no stored content", id)
+	}
+	return os.ReadFile(artifact.ContentPath)
+}
+
 // DeleteArtifact removes an artifact by its ID and cleans up its associated file.
 func (s *ArtifactService) DeleteArtifact(id string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	artifact, ok := s.artifacts[id]
 	if !ok {
+		s.mu.Unlock()
 		return fmt.Errorf("artifact with ID %s not found", id)
 	}
+	s.deleteLocked(artifact)
+	s.mu.Unlock()
+
+	// Notify the frontend of the deletion.
+	s.bus.Publish(events.ArtifactDeleted, id)
+	log.Printf("ArtifactService: Deleted artifact: ID=%s", id)
+	return nil
+}
 
+// deleteLocked removes an artifact, its file and its stored metadata. s.mu
+// must be held; the caller publishes the deletion once it is released, so
+// subscribers may call back into the service.
+func (s *ArtifactService) deleteLocked(artifact *Artifact) {
 	// Delete the actual content file from disk if ContentPath is set.
 	if artifact.ContentPath != "" {
 		if err := os.Remove(artifact.ContentPath); err != nil {
@@ -159,22 +209,78 @@ func (s *ArtifactService) DeleteArtifact(id string) error {
 		}
 	}
 
-	delete(s.artifacts, id)
-	log.Printf("ArtifactService: Deleted artifact: ID=%s", id)
+	delete(s.artifacts, artifact.ID)
+
+	if artifact.IsPersistent && s.store != nil {
+		if err := s.store.DeleteArtifact(artifact.ID); err != nil {
+			log.Printf("ArtifactService: Error forgetting deleted artifact %s: %v", artifact.ID, err)
+		}
+	}
+}
 
-	// REMOVED: s.saveArtifactsMetadata() // Removed call to save metadata
+// DeleteSessionArtifacts removes all artifacts of a session with their files,
+// for when the session is deleted.
+func (s *ArtifactService) DeleteSessionArtifacts(sessionID int64) {
+	s.deleteMatching(func(artifact *Artifact) bool { return artifact.SessionID == sessionID })
+}
 
-	// Notify the frontend of the deletion.
-	if s.ctx != nil {
-		runtime.EventsEmit(s.ctx, "artifactDeleted", id)
+// DeleteMessageArtifacts removes the artifacts of a message with their files,
+// for when the message is deleted.
+func (s *ArtifactService) DeleteMessageArtifacts(messageID int64) {
+	if messageID == 0 {
+		return
 	}
+	s.deleteMatching(func(artifact *Artifact) bool { return artifact.MessageID == messageID })
+}
+
+func (s *ArtifactService) deleteMatching(match func(*Artifact) bool) {
+	s.mu.Lock()
+	var deleted []string
+	for _, artifact := range s.artifacts {
+		if match(artifact) {
+			s.deleteLocked(artifact)
+			deleted = append(deleted, artifact.ID)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range deleted {
+		s.bus.Publish(events.ArtifactDeleted, id)
+	}
+	if len(deleted) > 0 {
+		log.Printf("ArtifactService: Deleted %d artifacts", len(deleted))
+	}
+}
 
+// LinkArtifacts records that the given artifacts belong to a message, for
+// artifacts stored before the message was, such as files attached to it.
+// Artifacts already linked to a message keep it.
+func (s *ArtifactService) LinkArtifacts(ids []string, messageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		artifact, ok := s.artifacts[id]
+		if !ok {
+			return fmt.Errorf("artifact with ID %s not found", id)
+		}
+		if artifact.MessageID != 0 {
+			continue
+		}
+		artifact.MessageID = messageID
+		if artifact.IsPersistent && s.store != nil {
+			if err := s.store.SaveArtifact(artifact); err != nil {
+				return fmt.Errorf("failed to save artifact %s: %w", id, err)
+			}
+		}
+	}
 	return nil
 }
 
 // ListArtifacts returns a slice of all artifacts for a given session,
-// sorted by timestamp.
-func (s *ArtifactService) ListArtifacts(sessionID string) ([]*Artifact, error) {
+// sorted by timestamp. If messageID is not 0, only the artifacts of that
+// message are returned.
+func (s *ArtifactService) ListArtifacts(sessionID, messageID int64) ([]*Artifact, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -182,7 +288,7 @@ func (s *ArtifactService) ListArtifacts(sessionID string) ([]*Artifact, error) {
 	for _, artifact := range s.artifacts {
 		// In the main app, you might choose to filter by IsPersistent here
 		// For now, we'll list all for the session.
-		if artifact.SessionID == sessionID {
+		if artifact.SessionID == sessionID && (messageID == 0 || artifact.MessageID == messageID) {
 			result = append(result, artifact)
 		}
 	}
@@ -192,17 +298,30 @@ func (s *ArtifactService) ListArtifacts(sessionID string) ([]*Artifact, error) {
 		return result[i].Timestamp < result[j].Timestamp
 	})
 
-	log.Printf("ArtifactService: Listed %d artifacts for session %s", len(result), sessionID)
+	log.Printf("ArtifactService: Listed %d artifacts for session %d", len(result), sessionID)
 	return result, nil
 }
 
+// AllArtifacts returns the artifacts of every session, sorted by timestamp.
+func (s *ArtifactService) AllArtifacts() []*Artifact {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*Artifact, 0, len(s.artifacts))
+	for _, artifact := range s.artifacts {
+		result = append(result, artifact)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp < result[j].Timestamp
+	})
+	return result
+}
+
 // CleanupNonPersistentArtifacts iterates through all artifacts for the given session
 // and removes any that are not marked as persistent. This is intended to be
 // called at the end of a session or on application exit.
-func (s *ArtifactService) CleanupNonPersistentArtifacts(sessionID string) {
+func (s *ArtifactService) CleanupNonPersistentArtifacts(sessionID int64) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	var idsToDelete []string
 	for id, artifact := range s.artifacts {
 		// Only cleanup if it's for the current session and not persistent
@@ -221,19 +340,29 @@ func (s *ArtifactService) CleanupNonPersistentArtifacts(sessionID string) {
 		}
 		delete(s.artifacts, id)
 		log.Printf("ArtifactService: Cleaned up non-persistent artifact: ID=%s", id)
+	}
+	s.mu.Unlock()
 
-		// Notify frontend if needed (e.g., if panel is open during cleanup)
-		if s.ctx != nil {
-			runtime.EventsEmit(s.ctx, "artifactDeleted", id)
-		}
+	// Notify frontend if needed (e.g., if panel is open during cleanup)
+	for _, id := range idsToDelete {
+		s.bus.Publish(events.ArtifactDeleted, id)
 	}
-	// Removed s.saveArtifactsMetadata() call here, as per previous agreement.
 }
 
 // Shutdown is a method to be called when the application is shutting down.
-// It ensures that artifact metadata is saved.
+// Persistent artifacts are already in the store; the files of the others are
+// deleted.
 func (s *ArtifactService) Shutdown() {
-	log.Println("ArtifactService: Shutting down. (No explicit metadata save here)")
+	log.Println("ArtifactService: Shutting down.")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, artifact := range s.artifacts {
+		if !artifact.IsPersistent && artifact.ContentPath != "" {
+			if err := os.Remove(artifact.ContentPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("ArtifactService: Error deleting non-persistent artifact file %s: %v", artifact.ContentPath, err)
+			}
+		}
+	}
 	log.Println("ArtifactService: Shutdown complete.")
 }
 
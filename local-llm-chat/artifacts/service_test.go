@@ -0,0 +1,47 @@
+package artifacts
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"local-llm-chat/events"
+)
+
+// TestEventsPublishedOutsideLock subscribes a handler that reads the service
+// back, as the frontend bridge may; it would deadlock if events were
+// published with the lock held.
+func TestEventsPublishedOutsideLock(t *testing.T) {
+	bus := events.NewBus()
+	service := NewArtifactService(bus, t.TempDir())
+	var seen []int
+	bus.Subscribe(func(event events.Event) {
+		seen = append(seen, len(service.AllArtifacts()))
+	}, events.ArtifactAdded, events.ArtifactDeleted)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		content := base64.StdEncoding.EncodeToString([]byte("content"))
+		first, err := service.AddMessageArtifact(1, 10, "", TypeImage, "a.png", content, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		service.AddMessageArtifact(1, 11, "", TypeImage, "b.png", content, nil)
+		service.AddArtifact(2, TypeToolNotification, "note", "Tool ran")
+		service.DeleteArtifact(first.ID)
+		service.DeleteMessageArtifacts(11)
+		service.CleanupNonPersistentArtifacts(2)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a subscriber reading the service deadlocked")
+	}
+
+	if want := []int{1, 2, 3, 2, 1, 0}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("subscriber saw %v artifacts, want %v", seen, want)
+	}
+}
@@ -0,0 +1,116 @@
+package artifacts
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// storedFileName matches the names AddArtifact stores content under:
+// session ID, artifact ID and file name.
+var storedFileName = regexp.MustCompile(`^(\d+)_[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}_`)
+
+// Store keeps the metadata of persistent artifacts, so they survive a
+// restart. The app keeps it in chat.db, next to the sessions the artifacts
+// belong to.
+type Store interface {
+	// SaveArtifact stores a new artifact.
+	SaveArtifact(artifact *Artifact) error
+	// DeleteArtifact forgets an artifact.
+	DeleteArtifact(id string) error
+	// LoadArtifacts returns the stored artifacts of sessions that still exist.
+	LoadArtifacts() ([]*Artifact, error)
+	// SessionIDs returns the IDs of the chat sessions that exist.
+	SessionIDs() (map[int64]bool, error)
+}
+
+// Restore loads the artifacts kept in store and saves every persistent
+// artifact added from then on to it. Artifacts whose file is gone are
+// forgotten.
+func (s *ArtifactService) Restore(store Store) error {
+	loaded, err := store.LoadArtifacts()
+	if err != nil {
+		return fmt.Errorf("failed to load artifacts: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+
+	for _, artifact := range loaded {
+		if artifact.ContentPath != "" {
+			if _, err := os.Stat(artifact.ContentPath); err != nil {
+				log.Printf("ArtifactService: Restore: Dropping artifact %s, its file is gone: %v", artifact.ID, err)
+				if err := store.DeleteArtifact(artifact.ID); err != nil {
+					log.Printf("ArtifactService: Restore: Error forgetting artifact %s: %v", artifact.ID, err)
+				}
+				continue
+			}
+		}
+		s.artifacts[artifact.ID] = artifact
+	}
+	log.Printf("ArtifactService: Restored %d artifacts", len(loaded))
+	return nil
+}
+
+// RemoveOrphanFiles deletes the artifact files in the artifacts directory
+// that belong to chat sessions that no longer exist, left behind by deleted
+// sessions or a crash. Files of existing sessions are kept even when no
+// artifact refers to them, as are files written since the service was
+// created: another process on the same directory, such as a running serve or
+// mcp-server, may still use them. Other files, such as the server logs, are
+// kept. Call it after Restore.
+func (s *ArtifactService) RemoveOrphanFiles() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.artifactsDir == "" || s.store == nil {
+		return
+	}
+	referenced := make(map[string]bool)
+	for _, artifact := range s.artifacts {
+		if artifact.ContentPath != "" {
+			referenced[filepath.Clean(artifact.ContentPath)] = true
+		}
+	}
+	entries, err := os.ReadDir(s.artifactsDir)
+	if err != nil {
+		log.Printf("ArtifactService: Error listing artifacts directory %s: %v", s.artifactsDir, err)
+		return
+	}
+	// Sessions are listed after the files, so a file of a session created in
+	// the meantime finds its session.
+	sessions, err := s.store.SessionIDs()
+	if err != nil {
+		log.Printf("ArtifactService: Error listing chat sessions: %v", err)
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		match := storedFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		path := filepath.Join(s.artifactsDir, entry.Name())
+		if referenced[filepath.Clean(path)] {
+			continue
+		}
+		if sessionID, err := strconv.ParseInt(match[1], 10, 64); err != nil || sessions[sessionID] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(s.created) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("ArtifactService: Error deleting orphaned artifact file %s: %v", path, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("ArtifactService: Deleted %d orphaned artifact files", removed)
+	}
+}
@@ -0,0 +1,55 @@
+package artifacts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store that keeps nothing but the sessions it is given.
+type memoryStore struct {
+	sessions map[int64]bool
+}
+
+func (m *memoryStore) SaveArtifact(*Artifact) error        { return nil }
+func (m *memoryStore) DeleteArtifact(string) error         { return nil }
+func (m *memoryStore) LoadArtifacts() ([]*Artifact, error) { return nil, nil }
+func (m *memoryStore) SessionIDs() (map[int64]bool, error) { return m.sessions, nil }
+
+func TestRemoveOrphanFiles(t *testing.T) {
+	dir := t.TempDir()
+	const id = "0123abcd-0123-4567-89ab-0123456789ab"
+	past := time.Now().Add(-time.Hour)
+	write := func(name string, modTime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("content"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	deletedSession := write("7_"+id+"_old.png", past)
+	liveSession := write("3_"+id+"_other-process.png", past)
+	log := write("llama-server.log", past)
+
+	service := NewArtifactService(nil, dir)
+	if err := service.Restore(&memoryStore{sessions: map[int64]bool{3: true}}); err != nil {
+		t.Fatal(err)
+	}
+	// Written by another process after this one started.
+	recent := write("9_"+id+"_new.png", time.Now().Add(time.Minute))
+
+	service.RemoveOrphanFiles()
+
+	if _, err := os.Stat(deletedSession); !os.IsNotExist(err) {
+		t.Errorf("file of a deleted session was kept: %v", err)
+	}
+	for _, path := range []string{liveSession, log, recent} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was deleted: %v", filepath.Base(path), err)
+		}
+	}
+}
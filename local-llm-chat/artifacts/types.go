@@ -3,14 +3,16 @@ package artifacts
 // Artifact represents a piece of content (image, video, tool notification, etc.)
 // generated or uploaded within a chat session.
 type Artifact struct {
-	ID           string                 `json:"id"`            // Unique identifier for the artifact
-	SessionID    string                 `json:"session_id"`    // ID of the chat session it belongs to
-	Type         ArtifactType           `json:"type"`          // Type of the artifact (e.g., "IMAGE", "VIDEO")
-	ContentPath  string                 `json:"content_path"`  // File path on disk where content is stored (if applicable)
-	URL          string                 `json:"url"`           // URL for frontend to access the content via asset server
-	Metadata     map[string]interface{} `json:"metadata"`      // Additional metadata (e.g., file_name, size_bytes, message)
-	Timestamp    string                 `json:"timestamp"`     // When the artifact was created, in RFC3339 format
-	IsPersistent bool                   `json:"is_persistent"` // Whether the artifact should persist across sessions
+	ID           string                 `json:"id"`                     // Unique identifier for the artifact
+	SessionID    int64                  `json:"session_id"`             // ID of the chat session it belongs to
+	MessageID    int64                  `json:"message_id,omitempty"`   // ID of the message that produced or carried it, if any
+	ToolCallID   string                 `json:"tool_call_id,omitempty"` // ID of the tool call whose result it is, if any
+	Type         ArtifactType           `json:"type"`                   // Type of the artifact (e.g., "IMAGE", "VIDEO")
+	ContentPath  string                 `json:"content_path"`           // File path on disk where content is stored (if applicable)
+	URL          string                 `json:"url"`                    // URL for frontend to access the content via asset server
+	Metadata     map[string]interface{} `json:"metadata"`               // Additional metadata (e.g., file_name, size_bytes, message)
+	Timestamp    string                 `json:"timestamp"`              // When the artifact was created, in RFC3339 format
+	IsPersistent bool                   `json:"is_persistent"`          // Whether the artifact should persist across sessions
 }
 
 // ArtifactType defines the type of content an artifact represents.
@@ -19,6 +21,25 @@ type ArtifactType string
 const (
 	TypeImage            ArtifactType = "IMAGE"
 	TypeVideo            ArtifactType = "VIDEO"
+	TypeAudio            ArtifactType = "AUDIO"
+	TypeDocument         ArtifactType = "DOCUMENT"
 	TypeToolNotification ArtifactType = "TOOL_NOTIFICATION"
 	TypeLogView          ArtifactType = "LOG_VIEW"
 )
+
+// IsFile reports whether artifacts of this type store their content in a file
+// on disk, passed to AddArtifact as base64.
+func (t ArtifactType) IsFile() bool {
+	switch t {
+	case TypeImage, TypeVideo, TypeAudio, TypeDocument:
+		return true
+	}
+	return false
+}
+
+// IsPersistent reports whether artifacts of this type are kept across
+// restarts. Content stored in files is; notifications and panels such as the
+// log view are only shown while the app runs.
+func (t ArtifactType) IsPersistent() bool {
+	return t.IsFile()
+}
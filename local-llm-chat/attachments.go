@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"local-llm-chat/artifacts"
+)
+
+// Files sent with user messages are stored as artifacts and recorded in
+// message_attachments, so they are sent to the model again when the chat is
+// reloaded. Images are sent as OpenAI-style content parts, which llama-server
+// accepts when it runs the model with a multimodal projector (mmproj). Text
+// and code files are inlined into the message as fenced blocks.
+
+// Attachment kinds.
+const (
+	AttachmentImage = "image"
+	AttachmentFile  = "file"
+)
+
+// Attachment overflow modes: what happens to a message whose files exceed
+// max_attachment_tokens.
+const (
+	AttachmentOverflowTruncate = "truncate" // Cut the files that do not fit
+	AttachmentOverflowRefuse   = "refuse"   // Do not send the message
+)
+
+// defaultMaxAttachmentTokens is how many tokens of files are inlined into a
+// message when max_attachment_tokens is not set.
+const defaultMaxAttachmentTokens = 8000
+
+// attachmentSniffBytes is how much of a file is read to tell images apart.
+const attachmentSniffBytes = 512
+
+// MessageAttachment is something sent along with a user message.
+type MessageAttachment struct {
+	ID         int64  `json:"id"`
+	MessageID  int64  `json:"message_id"`
+	Kind       string `json:"kind"`
+	ArtifactID string `json:"artifact_id,omitempty"`
+	Name       string `json:"name"`
+	MIMEType   string `json:"mime_type"`
+	Path       string `json:"path"`             // Stored content of the attachment
+	Source     string `json:"source,omitempty"` // The file the attachment was read from
+	Tokens     int    `json:"tokens,omitempty"` // Tokens of a text file inlined into the message
+	Truncated  bool   `json:"truncated,omitempty"`
+}
+
+// ContentPart is a part of a multi-part message: text, or an image given as
+// a data URL.
+type ContentPart struct {
+	Type     string        `json:"type"` // "text" or "image_url"
+	Text     string        `json:"text,omitempty"`
+	ImageURL *ContentImage `json:"image_url,omitempty"`
+}
+
+// ContentImage is the image of an image_url content part.
+type ContentImage struct {
+	URL string `json:"url"`
+}
+
+// contentParts returns the message as content parts: its text followed by
+// its images.
+func (m ChatMessage) contentParts() []ContentPart {
+	parts := []ContentPart{{Type: "text", Text: m.Content}}
+	for _, image := range m.Images {
+		parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ContentImage{URL: image}})
+	}
+	return parts
+}
+
+// parseContent reads message content that is either a string or a list of
+// content parts, and returns its text, with text parts joined by newlines,
+// and its image URLs.
+func parseContent(raw json.RawMessage) (string, []string) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []ContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil
+	}
+	var texts, images []string
+	for _, part := range parts {
+		switch {
+		case part.Type == "text":
+			texts = append(texts, part.Text)
+		case part.Type == "image_url" && part.ImageURL != nil:
+			images = append(images, part.ImageURL.URL)
+		}
+	}
+	return strings.Join(texts, "\n"), images
+}
+
+// imageDataURL encodes an image as a data URL.
+func imageDataURL(mimeType string, content []byte) string {
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(content))
+}
+
+// imageMIMEType returns the MIME type of an image from its file name or, if
+// the name does not tell, its content. It returns "" for other files.
+func imageMIMEType(name string, content []byte) string {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(content)
+	}
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ""
+	}
+	return mimeType
+}
+
+// AttachImage stores an image for a chat as an artifact. Pass the ID of the
+// returned artifact in ChatOptions.Attachments to send the image with a
+// message.
+func (a *App) AttachImage(sessionID int64, name string, contentBase64 string) (*artifacts.Artifact, error) {
+	if a.ArtifactService == nil {
+		return nil, fmt.Errorf("artifact service not initialized")
+	}
+	content, err := base64.StdEncoding.DecodeString(contentBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image data: %w", err)
+	}
+	mimeType := imageMIMEType(name, content)
+	if mimeType == "" {
+		return nil, fmt.Errorf("%s is not an image", name)
+	}
+	metadata := map[string]interface{}{"mime_type": mimeType, "source": "user"}
+	return a.ArtifactService.AddArtifactWithMetadata(sessionID, artifacts.TypeImage, name, contentBase64, metadata)
+}
+
+// maxAttachmentTokens returns how many tokens of files may be inlined into
+// one message.
+func (a *App) maxAttachmentTokens() int {
+	if a.config.MaxAttachmentTokens > 0 {
+		return a.config.MaxAttachmentTokens
+	}
+	return defaultMaxAttachmentTokens
+}
+
+// attachmentSource is a file to attach: a stored artifact, or a file on disk
+// that is stored as one when the message is sent.
+type attachmentSource struct {
+	artifact *artifacts.Artifact
+	path     string
+	name     string
+}
+
+// resolveAttachment finds the file a reference names: an artifact ID or a
+// file path. An artifact must belong to the session, as it is deleted with
+// the session it belongs to.
+func (a *App) resolveAttachment(sessionID int64, ref string) (attachmentSource, error) {
+	if a.ArtifactService != nil {
+		if artifact, err := a.ArtifactService.GetArtifact(ref); err == nil {
+			if artifact.SessionID != sessionID {
+				return attachmentSource{}, fmt.Errorf("artifact %s belongs to another chat", ref)
+			}
+			if artifact.ContentPath == "" {
+				return attachmentSource{}, fmt.Errorf("artifact %s has no content to attach", ref)
+			}
+			name, _ := artifact.Metadata["file_name"].(string)
+			if name == "" {
+				name = filepath.Base(artifact.ContentPath)
+			}
+			return attachmentSource{artifact: artifact, path: artifact.ContentPath, name: name}, nil
+		}
+	}
+	info, err := os.Stat(ref)
+	if err != nil {
+		return attachmentSource{}, fmt.Errorf("%s is neither an artifact nor a readable file: %w", ref, err)
+	}
+	if info.IsDir() {
+		return attachmentSource{}, fmt.Errorf("%s is a folder; attach files, or add the folder to a collection", ref)
+	}
+	return attachmentSource{path: ref, name: filepath.Base(ref)}, nil
+}
+
+// prepareAttachments reads the files attached to a message. It returns the
+// text to append to the message, the attachments to record and the images as
+// data URLs. Text files share the max_attachment_tokens budget, in order;
+// files that do not fit are cut, or left out once the budget is used up, or,
+// with attachment_overflow "refuse", make the message fail. Images are left
+// out if the model cannot see them. Files are stored only once all of them
+// could be read; if storing one fails, those stored before it are deleted.
+func (a *App) prepareAttachments(sessionID int64, refs []string) (string, []MessageAttachment, []string, error) {
+	type pendingAttachment struct {
+		source     attachmentSource
+		attachment MessageAttachment
+		text       string // Inlined text of a text file
+	}
+	var pending []pendingAttachment
+	remaining := a.maxAttachmentTokens()
+	for _, ref := range refs {
+		source, err := a.resolveAttachment(sessionID, ref)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		head, err := readFileHead(source.path, attachmentSniffBytes)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if mimeType := imageMIMEType(source.name, head); mimeType != "" {
+			if source.artifact != nil && source.artifact.Type != artifacts.TypeImage {
+				return "", nil, nil, fmt.Errorf("artifact %s is a %s, not an image", source.artifact.ID, strings.ToLower(string(source.artifact.Type)))
+			}
+			pending = append(pending, pendingAttachment{source: source, attachment: MessageAttachment{Kind: AttachmentImage, Name: source.name, MIMEType: mimeType}})
+			continue
+		}
+
+		text, err := readDocumentText(source.path)
+		if errors.Is(err, errUnindexable) {
+			// Binary or too large: say why without the indexing wording.
+			return "", nil, nil, fmt.Errorf("cannot attach %s: %s", source.name, strings.TrimPrefix(err.Error(), errUnindexable.Error()+": "))
+		}
+		if err != nil {
+			return "", nil, nil, err
+		}
+		attachment := MessageAttachment{Kind: AttachmentFile, Name: source.name, MIMEType: textMIMEType(source.name)}
+		sent, tokens := a.tokenCounter.TruncateToTokens(text, remaining)
+		if tokens > remaining {
+			if a.config.AttachmentOverflow == AttachmentOverflowRefuse {
+				return "", nil, nil, fmt.Errorf("%s has %d tokens, more than the %d left of the %d allowed per message (max_attachment_tokens)", source.name, tokens, remaining, a.maxAttachmentTokens())
+			}
+			if strings.TrimSpace(sent) == "" {
+				a.logWarningf("Attachment %s has %d tokens and none are left of the %d allowed per message; leaving it out", source.name, tokens, a.maxAttachmentTokens())
+				continue
+			}
+			a.logWarningf("Attachment %s has %d tokens; sending the first %d", source.name, tokens, remaining)
+			attachment.Truncated = true
+			tokens = remaining
+		}
+		attachment.Tokens = tokens
+		remaining -= tokens
+		pending = append(pending, pendingAttachment{source: source, attachment: attachment, text: sent})
+	}
+
+	var blocks, images []string
+	var attachments []MessageAttachment
+	var stored []string // Artifacts created here from files on disk
+	fail := func(err error) (string, []MessageAttachment, []string, error) {
+		for _, id := range stored {
+			if deleteErr := a.ArtifactService.DeleteArtifact(id); deleteErr != nil {
+				a.logWarningf("Could not delete attachment artifact %s: %v", id, deleteErr)
+			}
+		}
+		return "", nil, nil, err
+	}
+	sendImages := a.modelSupportsImages()
+	for _, p := range pending {
+		attachment := p.attachment
+		if err := a.storeAttachment(sessionID, p.source, &attachment); err != nil {
+			return fail(err)
+		}
+		if p.source.artifact == nil {
+			stored = append(stored, attachment.ArtifactID)
+		}
+		attachments = append(attachments, attachment)
+		switch {
+		case attachment.Kind == AttachmentFile:
+			blocks = append(blocks, attachmentBlock(attachment, p.text))
+		case sendImages:
+			content, err := os.ReadFile(attachment.Path)
+			if err != nil {
+				return fail(err)
+			}
+			images = append(images, imageDataURL(attachment.MIMEType, content))
+		default:
+			a.logWarningf("The selected model cannot see images; sending the message without %s. Launch it with an mmproj file to enable vision.", attachment.Name)
+		}
+	}
+	return strings.Join(blocks, "\n\n"), attachments, images, nil
+}
+
+// readFileHead returns up to n bytes from the start of a file.
+func readFileHead(path string, n int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	head := make([]byte, n)
+	read, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:read], nil
+}
+
+// textMIMEType returns the MIME type of a text file from its name.
+func textMIMEType(name string) string {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	if mimeType == "" {
+		return "text/plain"
+	}
+	return mimeType
+}
+
+// storeAttachment fills in where an attachment is stored. A file from disk is
+// copied into an artifact first, so the message can be rebuilt from it even
+// if the file changes.
+func (a *App) storeAttachment(sessionID int64, source attachmentSource, attachment *MessageAttachment) error {
+	if source.artifact != nil {
+		attachment.ArtifactID = source.artifact.ID
+		attachment.Path = source.artifact.ContentPath
+		return nil
+	}
+	if a.ArtifactService == nil {
+		return fmt.Errorf("cannot attach %s: artifact service not initialized", source.name)
+	}
+	content, err := os.ReadFile(source.path)
+	if err != nil {
+		return err
+	}
+	artifactType := artifacts.TypeDocument
+	if attachment.Kind == AttachmentImage {
+		artifactType = artifacts.TypeImage
+	}
+	metadata := map[string]interface{}{"mime_type": attachment.MIMEType, "source": "user", "source_path": source.path}
+	artifact, err := a.ArtifactService.AddArtifactWithMetadata(sessionID, artifactType, source.name, base64.StdEncoding.EncodeToString(content), metadata)
+	if err != nil {
+		return err
+	}
+	attachment.ArtifactID = artifact.ID
+	attachment.Path = artifact.ContentPath
+	attachment.Source = source.path
+	return nil
+}
+
+// attachmentBlock formats the text of a file for the model: a header with the
+// file name and the text in a fenced code block.
+func attachmentBlock(attachment MessageAttachment, text string) string {
+	header := "File: " + attachment.Name
+	if attachment.Truncated {
+		header += fmt.Sprintf(" (truncated to its first %d tokens)", attachment.Tokens)
+	}
+	fence := codeFence(text)
+	return fmt.Sprintf("%s\n%s%s\n%s\n%s", header, fence, fenceLanguage(attachment.Name), strings.TrimRight(text, "\n"), fence)
+}
+
+// codeFence returns a fence of backticks longer than any run of backticks in
+// text, so the text cannot close the block early.
+func codeFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// fenceLanguages maps file extensions to the language names used on code
+// fences, where they differ.
+var fenceLanguages = map[string]string{
+	"py":  "python",
+	"js":  "javascript",
+	"ts":  "typescript",
+	"rs":  "rust",
+	"rb":  "ruby",
+	"md":  "markdown",
+	"sh":  "bash",
+	"yml": "yaml",
+	"txt": "",
+	"pdf": "",
+}
+
+// fenceLanguage returns the language of a file for its code fence.
+func fenceLanguage(name string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	if language, ok := fenceLanguages[ext]; ok {
+		return language
+	}
+	return ext
+}
+
+// sentMessage rebuilds a stored user message as it was sent to the model:
+// its text files inlined and, if the model can see them, its images.
+func (a *App) sentMessage(msg ChatHistoryMessage, sendImages bool) ChatMessage {
+	sent := msg.ChatMessage
+	var blocks []string
+	for _, attachment := range msg.Attachments {
+		switch attachment.Kind {
+		case AttachmentImage:
+			if !sendImages {
+				continue
+			}
+			content, err := os.ReadFile(attachment.Path)
+			if err != nil {
+				a.logWarningf("Could not read image %s of message %d: %v", attachment.Name, attachment.MessageID, err)
+				continue
+			}
+			sent.Images = append(sent.Images, imageDataURL(attachment.MIMEType, content))
+		case AttachmentFile:
+			text, err := readDocumentText(attachment.Path)
+			if err != nil {
+				a.logWarningf("Could not read file %s of message %d: %v", attachment.Name, attachment.MessageID, err)
+				blocks = append(blocks, fmt.Sprintf("File: %s (no longer available)", attachment.Name))
+				continue
+			}
+			if attachment.Truncated {
+				text, _ = a.tokenCounter.TruncateToTokens(text, attachment.Tokens)
+			}
+			blocks = append(blocks, attachmentBlock(attachment, text))
+		}
+	}
+	sent.Content = withAttachments(sent.Content, strings.Join(blocks, "\n\n"))
+	return sent
+}
+
+// withAttachments appends the inlined files to the text of a message.
+func withAttachments(message, files string) string {
+	if files == "" {
+		return message
+	}
+	return message + "\n\n" + files
+}
+
+// linkAttachments links the artifacts attached to a message to it, once the
+// message is saved.
+func (a *App) linkAttachments(attachments []MessageAttachment, messageID int64) {
+	if a.ArtifactService == nil || len(attachments) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(attachments))
+	for _, attachment := range attachments {
+		if attachment.ArtifactID != "" {
+			ids = append(ids, attachment.ArtifactID)
+		}
+	}
+	if err := a.ArtifactService.LinkArtifacts(ids, messageID); err != nil {
+		a.logWarningf("Could not link the attachments of message %d: %v", messageID, err)
+	}
+}
+
+// mmprojArgs returns the multimodal projector to launch a model with and the
+// arguments that load it. Projectors given in the model's arguments are used
+// as they are; otherwise the one in the model's settings, or one found next
+// to the model file, is added.
+func (a *App) mmprojArgs(modelPath string, args []string) (string, []string) {
+	for i, arg := range args {
+		switch {
+		case arg == "--no-mmproj":
+			return "", nil
+		case (arg == "--mmproj" || arg == "-mm" || arg == "--mmproj-url" || arg == "-mmu") && i+1 < len(args):
+			return args[i+1], nil
+		case strings.HasPrefix(arg, "--mmproj=") || strings.HasPrefix(arg, "--mmproj-url="):
+			return arg[strings.Index(arg, "=")+1:], nil
+		}
+	}
+	mmproj := a.config.ModelSettings[modelPath].MMProj
+	if mmproj == "" {
+		mmproj = findMMProj(modelPath)
+	}
+	if mmproj == "" {
+		return "", nil
+	}
+	return mmproj, []string{"--mmproj", mmproj}
+}
+
+// findMMProj looks for the multimodal projector of a model in its folder: a
+// file named mmproj*.gguf. Of several, the one whose name shares the longest
+// prefix with the model's is taken. A projector must share at least the first
+// word of the model's name unless it is the only model in the folder, so the
+// projector of another model is never used.
+func findMMProj(modelPath string) string {
+	dir := filepath.Dir(modelPath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	modelName := strings.ToLower(filepath.Base(modelPath))
+	var projectors []string
+	models := 0
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if entry.IsDir() || filepath.Ext(name) != ".gguf" {
+			continue
+		}
+		if strings.HasPrefix(name, "mmproj") {
+			projectors = append(projectors, entry.Name())
+		} else {
+			models++
+		}
+	}
+
+	best, bestLength := "", -1
+	for _, projector := range projectors {
+		name := strings.TrimLeft(strings.TrimPrefix(strings.ToLower(projector), "mmproj"), "-_.")
+		length := commonPrefixLength(name, modelName)
+		if length > bestLength {
+			best, bestLength = projector, length
+		}
+	}
+	firstWord := strings.IndexAny(modelName, "-_.")
+	if firstWord < 0 {
+		firstWord = len(modelName)
+	}
+	if best == "" || (bestLength < firstWord && !(len(projectors) == 1 && models == 1)) {
+		return ""
+	}
+	return filepath.Join(dir, best)
+}
+
+func commonPrefixLength(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
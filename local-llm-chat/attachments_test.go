@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"local-llm-chat/artifacts"
+)
+
+func TestResolveAttachmentSession(t *testing.T) {
+	app := NewApp()
+	app.ArtifactService = artifacts.NewArtifactService(nil, t.TempDir())
+	image, err := app.AttachImage(1, "photo.png", base64.StdEncoding.EncodeToString([]byte("not really a png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := app.resolveAttachment(1, image.ID)
+	if err != nil || source.artifact != image || source.name != "photo.png" {
+		t.Errorf("own artifact: got %+v, %v", source, err)
+	}
+	if _, err := app.resolveAttachment(2, image.ID); err == nil || !strings.Contains(err.Error(), "another chat") {
+		t.Errorf("artifact of another chat: error = %v", err)
+	}
+}
+
+// newAttachmentApp returns an app that stores artifacts in a temporary
+// folder, and a function that writes a file to attach.
+func newAttachmentApp(t *testing.T) (*App, func(name, content string) string) {
+	t.Helper()
+	app := NewApp()
+	app.tokenCounter = NewTokenCounter(nil)
+	app.ArtifactService = artifacts.NewArtifactService(nil, t.TempDir())
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	return app, write
+}
+
+func TestPrepareAttachmentsBudget(t *testing.T) {
+	app, write := newAttachmentApp(t)
+	first := write("a.txt", strings.Repeat("alpha ", 40))
+	second := write("b.txt", strings.Repeat("beta ", 40))
+	third := write("c.txt", "gamma")
+	firstTokens := app.tokenCounter.CountTokens(strings.Repeat("alpha ", 40))
+	app.config.MaxAttachmentTokens = firstTokens + 10
+
+	// The first file fits, the second is cut to what is left and the third
+	// is left out.
+	text, attachments, images, err := app.prepareAttachments(1, []string{first, second, third})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 || len(images) != 0 {
+		t.Fatalf("got %d attachments and %d images, want 2 and 0", len(attachments), len(images))
+	}
+	if a := attachments[0]; a.Tokens != firstTokens || a.Truncated || a.Source != first || a.ArtifactID == "" {
+		t.Errorf("first attachment = %+v", a)
+	}
+	if b := attachments[1]; b.Tokens != 10 || !b.Truncated {
+		t.Errorf("second attachment = %+v, want it cut to 10 tokens", b)
+	}
+	if !strings.Contains(text, "File: a.txt\n") || !strings.Contains(text, "File: b.txt (truncated to its first 10 tokens)") || strings.Contains(text, "gamma") {
+		t.Errorf("text = %q", text)
+	}
+	if stored := app.ArtifactService.AllArtifacts(); len(stored) != 2 {
+		t.Errorf("%d artifacts stored, want 2", len(stored))
+	}
+}
+
+func TestPrepareAttachmentsRefuse(t *testing.T) {
+	app, write := newAttachmentApp(t)
+	app.config.AttachmentOverflow = AttachmentOverflowRefuse
+	app.config.MaxAttachmentTokens = 20
+	small := write("small.txt", "A few words.")
+	large := write("large.txt", strings.Repeat("many words ", 20))
+
+	_, _, _, err := app.prepareAttachments(1, []string{small, large})
+	if err == nil || !strings.Contains(err.Error(), "large.txt has") || !strings.Contains(err.Error(), "max_attachment_tokens") {
+		t.Errorf("error = %v, want large.txt refused", err)
+	}
+	if stored := app.ArtifactService.AllArtifacts(); len(stored) != 0 {
+		t.Errorf("a refused message stored %d artifacts", len(stored))
+	}
+}
+
+func TestPrepareAttachmentsStorageFailure(t *testing.T) {
+	app, write := newAttachmentApp(t)
+	earlier, err := app.AttachImage(1, "earlier.png", base64.StdEncoding.EncodeToString([]byte("image")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := app.AttachImage(1, "photo.png", base64.StdEncoding.EncodeToString([]byte("image")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := write("stored.txt", "Stored before the failure.")
+	// The name fits the file system, but not with the prefix the artifact
+	// file name gets, so storing this file fails.
+	failing := write(strings.Repeat("n", 240)+".txt", "Never stored.")
+
+	_, _, _, err = app.prepareAttachments(1, []string{image.ID, stored, failing})
+	if err == nil || !strings.Contains(err.Error(), "failed to write artifact content") {
+		t.Fatalf("error = %v, want storing the last file to fail", err)
+	}
+	// The file stored in this call is deleted; the attached image and other
+	// artifacts of the chat are kept.
+	left := make(map[string]bool)
+	for _, artifact := range app.ArtifactService.AllArtifacts() {
+		left[artifact.ID] = true
+	}
+	if len(left) != 2 || !left[earlier.ID] || !left[image.ID] {
+		t.Errorf("artifacts left = %v, want %s and %s", left, earlier.ID, image.ID)
+	}
+}
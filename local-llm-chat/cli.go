@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"local-llm-chat/events"
+)
+
+// commands are the subcommands that run without a window, by name. Any other
+// first argument starts the desktop app.
+var commands = map[string]func(args []string) error{
+	"chat":        cmdChat,
+	"sessions":    cmdSessions,
+	"collections": cmdCollections,
+	"models":      cmdModels,
+	"transcribe":  cmdTranscribe,
+	"speak":       cmdSpeak,
+	"serve":       cmdServe,
+	"mcp":         cmdMCP,
+	"mcp-server":  cmdMCPServer,
+	"help":        cmdHelp,
+}
+
+const usage = `Usage: local-llm-chat [command]
+
+Without a command, the desktop app starts. Commands run in the current folder,
+which must hold chat.db and config.json like the app's own folder.
+
+  chat [flags] MESSAGE        Send a message and print the reply ("-" reads stdin)
+  sessions list               List chat sessions
+  sessions export [flags] ID  Print a session with its messages
+  collections list            List the document collections
+  collections add NAME PATH   Index a file or folder into a collection
+  collections attach NAME ID  Let a chat session retrieve from a collection
+  collections sync            Re-index the files that changed on disk
+  collections search NAME Q   Show what retrieval finds for Q, with its scores
+  models list                 List the models in the models directory
+  transcribe FILE             Print what is said in a recording (whisper.cpp)
+  speak -o FILE TEXT          Save TEXT spoken by the tts_server_url server
+  serve [flags]               Run the API and MCP servers without a window
+  mcp list-tools [flags]      List the tools of the MCP servers
+  mcp-server [-port N]        Serve the app over MCP on stdio or HTTP
+
+Run a command with -h to see its flags.
+`
+
+func cmdHelp(args []string) error {
+	fmt.Print(usage)
+	return nil
+}
+
+// consoleStream prints streamed reply text to out and reports the end of a
+// streamed reply on done. Subscribe its Handle with events.ChatStream.
+type consoleStream struct {
+	out      io.Writer
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newConsoleStream(out io.Writer) *consoleStream {
+	return &consoleStream{out: out, done: make(chan struct{})}
+}
+
+func (s *consoleStream) Handle(event events.Event) {
+	if event.Data == nil {
+		fmt.Fprintln(s.out)
+		s.doneOnce.Do(func() { close(s.done) })
+		return
+	}
+	if text, ok := event.Data.(string); ok {
+		fmt.Fprint(s.out, text)
+	}
+}
+
+// mcpServerList splits the value of an -mcp flag.
+func mcpServerList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// verboseLevel is the log level of a command: debug with -v, else level.
+func verboseLevel(verbose bool, level events.LogLevel) events.LogLevel {
+	if verbose {
+		return events.LogDebug
+	}
+	return level
+}
+
+// newHeadlessApp creates an app without a window, with the router, the
+// built-in tools and the given MCP servers of mcp.json connected. Log
+// messages at or above minLevel go to the standard logger.
+func newHeadlessApp(minLevel events.LogLevel, mcpServers []string) (*App, error) {
+	app := NewApp()
+	app.bus.Subscribe(events.NewLogger(log.Default(), minLevel), events.Log)
+	if err := app.initHeadless(); err != nil {
+		return nil, err
+	}
+	app.router = NewRouter(app)
+	app.tokenCounter = NewTokenCounter(app.bus)
+	app.connectNativeTools()
+	app.connectMcpServers(mcpServers)
+	return app, nil
+}
+
+// connectMcpServers connects the named servers of mcp.json; "all" connects
+// every server. Without names, the servers switched on in
+// mcp_connection_states are connected.
+func (a *App) connectMcpServers(names []string) {
+	mcpConfigContent, err := a.GetMcpServers()
+	if err != nil {
+		a.logErrorf("Error loading MCP servers config: %v", err)
+		return
+	}
+	var mcpConfig McpConfig
+	if err := json.Unmarshal([]byte(mcpConfigContent), &mcpConfig); err != nil {
+		a.logErrorf("Error unmarshalling MCP config: %v", err)
+		return
+	}
+
+	if len(names) == 0 {
+		for name, connected := range a.config.McpConnectionStates {
+			if connected {
+				names = append(names, name)
+			}
+		}
+	} else if len(names) == 1 && names[0] == "all" {
+		names = nil
+		for name := range mcpConfig.McpServers {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		serverConfig, ok := mcpConfig.McpServers[name]
+		if !ok {
+			a.logWarningf("MCP server %q is not in mcp.json", name)
+			continue
+		}
+		if err := a.ConnectMcpClient(name, serverConfig.Command, serverConfig.Args); err != nil {
+			a.logErrorf("Error connecting to MCP server %q: %v", name, err)
+		}
+	}
+}
+
+func cmdChat(args []string) error {
+	flags := flag.NewFlagSet("chat", flag.ExitOnError)
+	sessionID := flags.Int64("session", 0, "continue this chat session (default: start a new one)")
+	systemPrompt := flags.String("system", "", "system prompt of a new session")
+	toolMode := flags.String("tools", ToolModeAuto, "tool use: auto, force or none")
+	model := flags.String("model", "", "model to launch if llama-server is not running it (default: the running or selected model)")
+	mcpServers := flags.String("mcp", "", "comma-separated MCP servers of mcp.json to connect, or \"all\"")
+	attach := flags.String("attach", "", "comma-separated files to send with the message: text is inlined, images need a vision model")
+	verbose := flags.Bool("v", false, "log what the app does to stderr")
+	flags.Parse(args)
+
+	message := strings.Join(flags.Args(), " ")
+	if message == "-" {
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		message = string(input)
+	}
+	if strings.TrimSpace(message) == "" {
+		return errors.New("no message given")
+	}
+
+	app, err := newHeadlessApp(verboseLevel(*verbose, events.LogWarning), mcpServerList(*mcpServers))
+	if err != nil {
+		return err
+	}
+	stream := newConsoleStream(os.Stdout)
+	app.bus.Subscribe(stream.Handle, events.ChatStream)
+	defer app.shutdown(context.Background())
+
+	if *sessionID == 0 {
+		if *sessionID, err = app.NewChat(*systemPrompt); err != nil {
+			return err
+		}
+	} else if _, err := app.LoadChatHistory(*sessionID); err != nil {
+		return err
+	}
+	if err := app.ensureModel(*model); err != nil {
+		return err
+	}
+
+	options := ChatOptions{ToolMode: *toolMode}
+	for _, path := range strings.Split(*attach, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			options.Attachments = append(options.Attachments, path)
+		}
+	}
+
+	if err := app.HandleChatWithOptions(*sessionID, message, options); err != nil {
+		return err
+	}
+
+	// The reply streams in the background; wait for its end unless none was
+	// started, e.g. because the request failed.
+	conv, _ := app.getConversation(*sessionID)
+	conv.mu.Lock()
+	streaming := conv.httpResp != nil
+	conv.mu.Unlock()
+	select {
+	case <-stream.done:
+	default:
+		if !streaming {
+			return fmt.Errorf("no reply in session %d; run with -v for details", *sessionID)
+		}
+		<-stream.done
+	}
+	log.Printf("session %d", *sessionID)
+	return nil
+}
+
+func cmdSessions(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: sessions list | sessions export [-format json|markdown] ID")
+	}
+	app := NewApp()
+	if err := app.initHeadless(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		sessions, err := app.db.GetChatSessions()
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			fmt.Printf("%d\t%s\t%s\n", session.ID, session.CreatedAt, session.Name)
+		}
+		return nil
+	case "export":
+		flags := flag.NewFlagSet("sessions export", flag.ExitOnError)
+		format := flags.String("format", "json", "output format: json or markdown")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return errors.New("usage: sessions export [-format json|markdown] ID")
+		}
+		var sessionID int64
+		if _, err := fmt.Sscan(flags.Arg(0), &sessionID); err != nil {
+			return fmt.Errorf("invalid session ID %q", flags.Arg(0))
+		}
+		export, err := app.exportSession(sessionID)
+		if err != nil {
+			return err
+		}
+		switch *format {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(export)
+		case "markdown":
+			fmt.Print(export.Markdown())
+			return nil
+		}
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return fmt.Errorf("unknown sessions command %q", args[0])
+}
+
+// SessionExport is a chat session with its messages.
+type SessionExport struct {
+	*ChatSession
+	Messages []ChatHistoryMessage `json:"messages"`
+}
+
+// exportSession loads a session with its messages.
+func (a *App) exportSession(sessionID int64) (*SessionExport, error) {
+	session, err := a.db.GetChatSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := a.db.GetChatMessages(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []ChatHistoryMessage{}
+	}
+	return &SessionExport{ChatSession: session, Messages: messages}, nil
+}
+
+// Markdown renders the session as a Markdown document, one section per message.
+func (e *SessionExport) Markdown() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# %s\n\n", e.Name))
+	if e.SystemPrompt != "" {
+		b.WriteString(fmt.Sprintf("## system\n\n%s\n\n", e.SystemPrompt))
+	}
+	for _, msg := range e.Messages {
+		b.WriteString(fmt.Sprintf("## %s\n\n%s\n\n", msg.Role, msg.Content))
+	}
+	return b.String()
+}
+
+func cmdCollections(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: collections list | add NAME PATH | attach NAME SESSION | sync | search NAME QUERY")
+	}
+	app := NewApp()
+	app.bus.Subscribe(events.NewLogger(log.Default(), events.LogInfo), events.Log)
+	if err := app.initHeadless(); err != nil {
+		return err
+	}
+	defer app.shutdown(context.Background()) // Stops an embedding server started for indexing
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		collections, err := app.ListCollections()
+		if err != nil {
+			return err
+		}
+		for _, c := range collections {
+			fmt.Printf("%d\t%s\t%d documents\t%d chunks\n", c.ID, c.Name, c.Documents, c.Chunks)
+		}
+		return nil
+	case args[0] == "add" && len(args) == 3:
+		collection, err := app.db.GetRAGCollectionByName(args[1])
+		if err != nil {
+			return err
+		}
+		collectionID := int64(0)
+		if collection != nil {
+			collectionID = collection.ID
+		} else if collectionID, err = app.CreateCollection(args[1]); err != nil {
+			return err
+		}
+		count, err := app.AddToCollection(collectionID, args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d files indexed\n", count)
+		return nil
+	case args[0] == "attach" && len(args) == 3:
+		collection, err := app.db.GetRAGCollectionByName(args[1])
+		if err != nil {
+			return err
+		}
+		if collection == nil {
+			return fmt.Errorf("no collection named %q", args[1])
+		}
+		var sessionID int64
+		if _, err := fmt.Sscan(args[2], &sessionID); err != nil {
+			return fmt.Errorf("invalid session ID %q", args[2])
+		}
+		if _, err := app.db.GetChatSession(sessionID); err != nil {
+			return fmt.Errorf("session %d: %w", sessionID, err)
+		}
+		return app.AttachCollection(sessionID, collection.ID)
+	case args[0] == "search" && len(args) >= 3:
+		collection, err := app.db.GetRAGCollectionByName(args[1])
+		if err != nil {
+			return err
+		}
+		if collection == nil {
+			return fmt.Errorf("no collection named %q", args[1])
+		}
+		citations, err := app.SearchCollection(collection.ID, strings.Join(args[2:], " "), 0)
+		if err != nil {
+			return err
+		}
+		for _, c := range citations {
+			s := c.Scores
+			rerank := "-"
+			if s.Rerank != nil {
+				rerank = fmt.Sprintf("%.3f", *s.Rerank)
+			}
+			fmt.Printf("[%d] %s (part %d)\n    vector %.3f (#%d)  %s bm25 %.3f (#%d)  fused %.4f  rerank %s\n",
+				c.Index, c.Path, c.Seq+1, s.Vector, s.VectorRank, s.BM25Source, s.BM25, s.BM25Rank, s.Fused, rerank)
+		}
+		return nil
+	case args[0] == "sync" && len(args) == 1:
+		results, err := app.SyncCollections()
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			fmt.Printf("%s\t%d indexed\t%d unchanged\t%d removed\t%d failed\t%s\n", r.Source, r.Indexed, r.Unchanged, r.Removed, r.Failed, r.Error)
+		}
+		return nil
+	}
+	return errors.New("usage: collections list | add NAME PATH | attach NAME SESSION | sync | search NAME QUERY")
+}
+
+func cmdModels(args []string) error {
+	if len(args) != 1 || args[0] != "list" {
+		return errors.New("usage: models list")
+	}
+	app := NewApp()
+	if err := app.initHeadless(); err != nil {
+		return err
+	}
+	models, err := app.GetModels()
+	if err != nil {
+		return err
+	}
+	for _, modelPath := range models {
+		selected := ""
+		if modelPath == app.config.SelectedModel {
+			selected = "\t(selected)"
+		}
+		fmt.Printf("%s\t%s%s\n", apiModelID(modelPath), modelPath, selected)
+	}
+	return nil
+}
+
+func cmdTranscribe(args []string) error {
+	flags := flag.NewFlagSet("transcribe", flag.ExitOnError)
+	language := flags.String("language", "", "spoken language, e.g. en (default: whisper_language)")
+	verbose := flags.Bool("v", false, "log what the app does to stderr")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: transcribe [flags] FILE")
+	}
+
+	app := NewApp()
+	app.bus.Subscribe(events.NewLogger(log.Default(), verboseLevel(*verbose, events.LogWarning)), events.Log)
+	if err := app.initHeadless(); err != nil {
+		return err
+	}
+	defer app.ShutdownWhisperServer()
+	if *language != "" {
+		app.config.WhisperLanguage = *language
+	}
+	content, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	text, err := app.transcribe(flags.Arg(0), content)
+	if err != nil {
+		return err
+	}
+	fmt.Println(text)
+	return nil
+}
+
+func cmdSpeak(args []string) error {
+	flags := flag.NewFlagSet("speak", flag.ExitOnError)
+	output := flags.String("o", "", "file to write the audio to")
+	voice := flags.String("voice", "", "voice to speak with (default: tts_voice)")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *output == "" {
+		return errors.New("usage: speak -o FILE [flags] TEXT")
+	}
+
+	app := NewApp()
+	if err := app.initHeadless(); err != nil {
+		return err
+	}
+	if *voice != "" {
+		app.config.TTSVoice = *voice
+	}
+	backend, err := app.speechBackend()
+	if err != nil {
+		return err
+	}
+	audio, _, err := backend.Synthesize(context.Background(), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, audio, 0644)
+}
+
+func cmdServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	apiPort := flags.Int("api-port", 0, "serve the OpenAI-compatible API on this port (default: api_server_port)")
+	mcpPort := flags.Int("mcp-port", 0, "serve the MCP server over HTTP on this port (default: mcp_server_port)")
+	mcpServers := flags.String("mcp", "", "comma-separated MCP servers of mcp.json to connect, or \"all\"")
+	verbose := flags.Bool("v", false, "log debug messages")
+	flags.Parse(args)
+
+	app, err := newHeadlessApp(verboseLevel(*verbose, events.LogInfo), mcpServerList(*mcpServers))
+	if err != nil {
+		return err
+	}
+	if *apiPort > 0 {
+		app.config.APIServerPort = *apiPort
+	}
+	if *mcpPort > 0 {
+		app.config.MCPServerPort = *mcpPort
+	}
+	if app.config.APIServerPort <= 0 && app.config.MCPServerPort <= 0 {
+		return errors.New("nothing to serve: set -api-port or -mcp-port, or api_server_port or mcp_server_port in config.json")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	app.startAPIServer()
+	app.startMCPHTTPServer()
+	app.startRAGWatcher()
+	<-ctx.Done()
+	app.shutdown(context.Background())
+	return nil
+}
+
+func cmdMCP(args []string) error {
+	if len(args) == 0 || args[0] != "list-tools" {
+		return errors.New("usage: mcp list-tools [-mcp NAMES]")
+	}
+	flags := flag.NewFlagSet("mcp list-tools", flag.ExitOnError)
+	mcpServers := flags.String("mcp", "all", "comma-separated MCP servers of mcp.json to connect, or \"all\"")
+	verbose := flags.Bool("v", false, "log what the app does to stderr")
+	flags.Parse(args[1:])
+
+	app, err := newHeadlessApp(verboseLevel(*verbose, events.LogWarning), mcpServerList(*mcpServers))
+	if err != nil {
+		return err
+	}
+	defer app.shutdown(context.Background())
+
+	var serverNames []string
+	for name := range app.mcpClients {
+		serverNames = append(serverNames, name)
+	}
+	sort.Strings(serverNames)
+	for _, name := range serverNames {
+		tools, err := app.mcpClients[name].ListTools(context.Background())
+		if err != nil {
+			app.logErrorf("Error listing tools for server '%s': %v", name, err)
+			continue
+		}
+		for _, tool := range tools {
+			fmt.Printf("%s\t%s\t%s\n", name, tool.Name, tool.Description)
+		}
+	}
+	return nil
+}
+
+func cmdMCPServer(args []string) error {
+	flags := flag.NewFlagSet("mcp-server", flag.ExitOnError)
+	port := flags.Int("port", 0, "serve over HTTP on this localhost port instead of stdio")
+	flags.Parse(args)
+	return runMCPServer(*port)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OutputConstraint restricts what the model may generate. llama-server turns a
+// JSON Schema into a grammar itself and rejects a request that has both, so at
+// most one of the two may be set.
+type OutputConstraint struct {
+	JSONSchema json.RawMessage `json:"json_schema,omitempty"`
+	Grammar    string          `json:"grammar,omitempty"`
+}
+
+// IsEmpty reports whether the constraint restricts nothing.
+func (c *OutputConstraint) IsEmpty() bool {
+	return c == nil || (len(c.JSONSchema) == 0 && strings.TrimSpace(c.Grammar) == "")
+}
+
+// Validate checks that the constraint is well formed before it is stored or sent.
+func (c *OutputConstraint) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.JSONSchema) > 0 && strings.TrimSpace(c.Grammar) != "" {
+		return fmt.Errorf("set either json_schema or grammar, not both")
+	}
+	if len(c.JSONSchema) > 0 {
+		var schema map[string]interface{}
+		if err := json.Unmarshal(c.JSONSchema, &schema); err != nil {
+			return fmt.Errorf("json_schema must be a JSON object: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidateResponse checks a finished reply against the JSON Schema, if any.
+// Grammar-only constraints are enforced by the sampler and are not re-checked.
+func (c *OutputConstraint) ValidateResponse(response string) []string {
+	if c == nil || len(c.JSONSchema) == 0 {
+		return nil
+	}
+	return validateJSONText(c.JSONSchema, response)
+}
+
+// parseOutputConstraint decodes a constraint from its JSON form. Empty input or
+// a constraint with neither field set yields nil.
+func parseOutputConstraint(raw string) (*OutputConstraint, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var constraint OutputConstraint
+	if err := json.Unmarshal([]byte(raw), &constraint); err != nil {
+		return nil, fmt.Errorf("invalid output constraint: %w", err)
+	}
+	if constraint.IsEmpty() {
+		return nil, nil
+	}
+	if err := constraint.Validate(); err != nil {
+		return nil, err
+	}
+	return &constraint, nil
+}
+
+// Tool modes let the user override the Router Agent for a single message.
+const (
+	ToolModeAuto  = "auto"  // Let the router decide (default)
+	ToolModeForce = "force" // Always run the Tool-Using Agent
+	ToolModeNone  = "none"  // Never use tools
+)
+
+// ChatOptions carries per-message settings that apply to a single turn only.
+type ChatOptions struct {
+	// OutputConstraint overrides the session's constraint for this message.
+	OutputConstraint *OutputConstraint `json:"output_constraint,omitempty"`
+	// ToolMode is one of ToolModeAuto, ToolModeForce or ToolModeNone.
+	ToolMode string `json:"tool_mode,omitempty"`
+	// Attachments are files sent with the message, as paths or artifact IDs.
+	// Text files are inlined; images are sent to models that can see them.
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// GetSessionOutputConstraint returns the output constraint stored for a chat session as a JSON string.
+func (a *App) GetSessionOutputConstraint(sessionID int64) (string, error) {
+	session, err := a.db.GetChatSession(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if session.OutputConstraint == nil {
+		return "{}", nil
+	}
+	constraintBytes, err := json.Marshal(session.OutputConstraint)
+	if err != nil {
+		return "", err
+	}
+	return string(constraintBytes), nil
+}
+
+// UpdateSessionOutputConstraint attaches a JSON Schema or GBNF grammar to a chat
+// session. An empty string or "{}" removes the constraint.
+func (a *App) UpdateSessionOutputConstraint(sessionID int64, constraintJSON string) error {
+	constraint, err := parseOutputConstraint(constraintJSON)
+	if err != nil {
+		return err
+	}
+
+	if err := a.db.UpdateChatSessionOutputConstraint(sessionID, constraint); err != nil {
+		a.logErrorf("UpdateSessionOutputConstraint: Error updating constraint for session %d: %s", sessionID, err.Error())
+		return err
+	}
+
+	if conv, ok := a.getConversation(sessionID); ok {
+		conv.mu.Lock()
+		conv.constraint = constraint
+		conv.mu.Unlock()
+	}
+	return nil
+}
+
+// constraintForSession returns the constraint for the current turn: the
+// per-message override if one was given, otherwise the session's constraint.
+func (a *App) constraintForSession(sessionID int64) *OutputConstraint {
+	conv, ok := a.getConversation(sessionID)
+	if !ok {
+		return nil
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+	if !conv.turnOptions.OutputConstraint.IsEmpty() {
+		return conv.turnOptions.OutputConstraint
+	}
+	if !conv.constraint.IsEmpty() {
+		return conv.constraint
+	}
+	return nil
+}
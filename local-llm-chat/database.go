@@ -2,15 +2,21 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"local-llm-chat/artifacts"
+	"local-llm-chat/nativetools"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Database struct
 type Database struct {
-	db *sql.DB
+	db   *sql.DB
+	fts5 bool // SQLite was built with FTS5; see initRAGFullText
 }
 
 // NewDatabase creates a new Database struct
@@ -43,16 +49,257 @@ func (d *Database) Initialize() error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY(session_id) REFERENCES chat_sessions(id)
 		);
+
+		CREATE TABLE IF NOT EXISTS agent_traces (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP,
+			outcome TEXT DEFAULT '',
+			FOREIGN KEY(session_id) REFERENCES chat_sessions(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS agent_trace_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			trace_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			name TEXT DEFAULT '',
+			started_at TEXT NOT NULL,
+			duration_ms INTEGER DEFAULT 0,
+			prompt_tokens INTEGER DEFAULT 0,
+			completion_tokens INTEGER DEFAULT 0,
+			details TEXT DEFAULT '',
+			error TEXT DEFAULT '',
+			FOREIGN KEY(trace_id) REFERENCES agent_traces(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS rag_collections (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			session_id INTEGER DEFAULT 0, -- The chat that owns the collection; 0 for named collections
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS rag_collection_sessions (
+			collection_id INTEGER NOT NULL,
+			session_id INTEGER NOT NULL,
+			PRIMARY KEY(collection_id, session_id),
+			FOREIGN KEY(collection_id) REFERENCES rag_collections(id),
+			FOREIGN KEY(session_id) REFERENCES chat_sessions(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS rag_sources (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			collection_id INTEGER NOT NULL,
+			path TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(collection_id, path),
+			FOREIGN KEY(collection_id) REFERENCES rag_collections(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS rag_documents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			collection_id INTEGER NOT NULL,
+			source_id INTEGER NOT NULL,
+			path TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(collection_id, path),
+			FOREIGN KEY(collection_id) REFERENCES rag_collections(id),
+			FOREIGN KEY(source_id) REFERENCES rag_sources(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS rag_chunks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			document_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			embedding BLOB NOT NULL, -- float32 values, little-endian
+			FOREIGN KEY(document_id) REFERENCES rag_documents(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_rag_chunks_document ON rag_chunks(document_id);
+
+		CREATE TABLE IF NOT EXISTS message_attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id INTEGER NOT NULL,
+			session_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			artifact_id TEXT DEFAULT '',
+			name TEXT DEFAULT '',
+			mime_type TEXT DEFAULT '',
+			path TEXT DEFAULT '', -- Stored content of the attachment
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(message_id) REFERENCES chat_messages(id),
+			FOREIGN KEY(session_id) REFERENCES chat_sessions(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_message_attachments_session ON message_attachments(session_id);
+
+		CREATE TABLE IF NOT EXISTS artifacts (
+			id TEXT PRIMARY KEY,
+			session_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			content_path TEXT DEFAULT '',
+			url TEXT DEFAULT '',
+			metadata TEXT DEFAULT '', -- JSON object
+			created_at TEXT NOT NULL, -- RFC 3339, as the artifact's Timestamp
+			FOREIGN KEY(session_id) REFERENCES chat_sessions(id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_artifacts_session ON artifacts(session_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema. Older databases are migrated in place.
+	columns := []struct{ table, column, definition string }{
+		{"chat_sessions", "sampling_params", "TEXT DEFAULT ''"},
+		{"chat_sessions", "output_constraint", "TEXT DEFAULT ''"},
+		{"chat_messages", "sampling_params", "TEXT DEFAULT ''"},
+		{"chat_messages", "validation_errors", "TEXT DEFAULT ''"},
+		{"chat_messages", "router_decision", "TEXT DEFAULT ''"},
+		{"chat_messages", "citations", "TEXT DEFAULT ''"},
+		{"rag_sources", "scanned_at", "TEXT DEFAULT ''"},
+		{"rag_documents", "size", "INTEGER DEFAULT 0"},
+		{"rag_documents", "mod_time", "INTEGER DEFAULT 0"},
+		{"message_attachments", "source", "TEXT DEFAULT ''"},
+		{"message_attachments", "tokens", "INTEGER DEFAULT 0"},
+		{"message_attachments", "truncated", "INTEGER DEFAULT 0"},
+		{"artifacts", "message_id", "INTEGER DEFAULT 0"},
+		{"artifacts", "tool_call_id", "TEXT DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := d.ensureColumn(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := d.db.Exec("CREATE INDEX IF NOT EXISTS idx_artifacts_message ON artifacts(message_id)"); err != nil {
+		return err
+	}
+	return d.initRAGFullText()
+}
+
+// initRAGFullText indexes document chunks with FTS5 if SQLite was built with
+// it (go build -tags sqlite_fts5). Triggers keep the index in sync with
+// rag_chunks. Without FTS5 the triggers are dropped, because they would make
+// every change to rag_chunks fail, and keyword scores are computed in Go.
+func (d *Database) initRAGFullText() error {
+	if err := d.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&d.fts5); err != nil {
+		return err
+	}
+	if !d.fts5 {
+		_, err := d.db.Exec(`
+			DROP TRIGGER IF EXISTS rag_chunks_fts_insert;
+			DROP TRIGGER IF EXISTS rag_chunks_fts_delete;
+		`)
+		return err
+	}
+
+	var triggers int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'rag_chunks_fts_insert'").Scan(&triggers); err != nil {
+		return err
+	}
+	if triggers > 0 {
+		return nil
+	}
+	// First run with FTS5, or chunks changed while it was unavailable: build
+	// the index from scratch.
+	_, err := d.db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS rag_chunks_fts USING fts5(content, content='rag_chunks', content_rowid='id');
+
+		CREATE TRIGGER IF NOT EXISTS rag_chunks_fts_insert AFTER INSERT ON rag_chunks BEGIN
+			INSERT INTO rag_chunks_fts(rowid, content) VALUES (new.id, new.content);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS rag_chunks_fts_delete AFTER DELETE ON rag_chunks BEGIN
+			INSERT INTO rag_chunks_fts(rag_chunks_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		END;
+
+		INSERT INTO rag_chunks_fts(rag_chunks_fts) VALUES ('rebuild');
 	`)
 	return err
 }
 
+// ensureColumn adds a column to a table if it does not already exist.
+func (d *Database) ensureColumn(table, column, definition string) error {
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // ChatSession struct
 type ChatSession struct {
-	ID           int64  `json:"id"`
-	Name         string `json:"name"`
-	SystemPrompt string `json:"system_prompt"` // Added SystemPrompt field
-	CreatedAt    string `json:"created_at"`
+	ID               int64             `json:"id"`
+	Name             string            `json:"name"`
+	SystemPrompt     string            `json:"system_prompt"` // Added SystemPrompt field
+	Sampling         *SamplingSettings `json:"sampling,omitempty"`
+	OutputConstraint *OutputConstraint `json:"output_constraint,omitempty"`
+	CreatedAt        string            `json:"created_at"`
+}
+
+// ChatHistoryMessage is a chat message as stored in the database, together with
+// the metadata recorded when it was generated.
+type ChatHistoryMessage struct {
+	ChatMessage
+	ID               int64               `json:"id"`
+	Sampling         *SamplingSettings   `json:"sampling,omitempty"`
+	ValidationErrors []string            `json:"validation_errors,omitempty"`
+	RouterDecision   *RouterDecision     `json:"router_decision,omitempty"`
+	Citations        []Citation          `json:"citations,omitempty"`   // Document chunks retrieved for a user message
+	Attachments      []MessageAttachment `json:"attachments,omitempty"` // Files and images sent with a user message
+}
+
+// decodeSampling parses a stored sampling_params column. Empty values yield nil.
+func decodeSampling(raw string) (*SamplingSettings, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var sampling SamplingSettings
+	if err := json.Unmarshal([]byte(raw), &sampling); err != nil {
+		return nil, err
+	}
+	return &sampling, nil
+}
+
+// encodeSampling serializes sampling parameters for storage. nil yields an empty string.
+func encodeSampling(sampling *SamplingSettings) (string, error) {
+	if sampling == nil {
+		return "", nil
+	}
+	samplingBytes, err := json.Marshal(sampling)
+	if err != nil {
+		return "", err
+	}
+	return string(samplingBytes), nil
 }
 
 // NewChatSession creates a new chat session with an optional system prompt
@@ -72,7 +319,7 @@ func (d *Database) NewChatSession(systemPrompt string) (int64, error) { // Modif
 
 // GetChatSessions retrieves all chat sessions.
 func (d *Database) GetChatSessions() ([]ChatSession, error) {
-	rows, err := d.db.Query("SELECT id, name, system_prompt, created_at FROM chat_sessions ORDER BY created_at DESC") // Select system_prompt
+	rows, err := d.db.Query("SELECT id, name, system_prompt, sampling_params, output_constraint, created_at FROM chat_sessions ORDER BY created_at DESC") // Select system_prompt
 	if err != nil {
 		return nil, err
 	}
@@ -81,11 +328,21 @@ func (d *Database) GetChatSessions() ([]ChatSession, error) {
 	var sessions []ChatSession
 	for rows.Next() {
 		var session ChatSession
+		var samplingParams, outputConstraint string
 		var createdAt time.Time
 		// Scan system_prompt
-		if err := rows.Scan(&session.ID, &session.Name, &session.SystemPrompt, &createdAt); err != nil {
+		if err := rows.Scan(&session.ID, &session.Name, &session.SystemPrompt, &samplingParams, &outputConstraint, &createdAt); err != nil {
 			return nil, err
 		}
+		sampling, err := decodeSampling(samplingParams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sampling params for session %d: %w", session.ID, err)
+		}
+		session.Sampling = sampling
+		session.OutputConstraint, err = parseOutputConstraint(outputConstraint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output constraint for session %d: %w", session.ID, err)
+		}
 		session.CreatedAt = createdAt.Format(time.RFC3339)
 		sessions = append(sessions, session)
 	}
@@ -95,15 +352,24 @@ func (d *Database) GetChatSessions() ([]ChatSession, error) {
 // GetChatSession retrieves a single chat session by ID.
 func (d *Database) GetChatSession(id int64) (*ChatSession, error) {
 	var session ChatSession
+	var samplingParams, outputConstraint string
 	var createdAt time.Time
 	// Select system_prompt
-	err := d.db.QueryRow("SELECT id, name, system_prompt, created_at FROM chat_sessions WHERE id = ?", id).Scan(&session.ID, &session.Name, &session.SystemPrompt, &createdAt)
+	err := d.db.QueryRow("SELECT id, name, system_prompt, sampling_params, output_constraint, created_at FROM chat_sessions WHERE id = ?", id).Scan(&session.ID, &session.Name, &session.SystemPrompt, &samplingParams, &outputConstraint, &createdAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("chat session with ID %d not found", id)
 		}
 		return nil, err
 	}
+	session.Sampling, err = decodeSampling(samplingParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sampling params for session %d: %w", id, err)
+	}
+	session.OutputConstraint, err = parseOutputConstraint(outputConstraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output constraint for session %d: %w", id, err)
+	}
 	session.CreatedAt = createdAt.Format(time.RFC3339)
 	return &session, nil
 }
@@ -114,23 +380,85 @@ func (d *Database) UpdateChatSessionSystemPrompt(sessionID int64, systemPrompt s
 	return err
 }
 
+// UpdateChatSessionSampling updates the sampling overrides for a given chat session.
+func (d *Database) UpdateChatSessionSampling(sessionID int64, sampling *SamplingSettings) error {
+	samplingParams, err := encodeSampling(sampling)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec("UPDATE chat_sessions SET sampling_params = ? WHERE id = ?", samplingParams, sessionID)
+	return err
+}
+
+// UpdateChatSessionOutputConstraint updates the output constraint for a given chat session.
+func (d *Database) UpdateChatSessionOutputConstraint(sessionID int64, constraint *OutputConstraint) error {
+	outputConstraint := ""
+	if constraint != nil {
+		constraintBytes, err := json.Marshal(constraint)
+		if err != nil {
+			return err
+		}
+		outputConstraint = string(constraintBytes)
+	}
+	_, err := d.db.Exec("UPDATE chat_sessions SET output_constraint = ? WHERE id = ?", outputConstraint, sessionID)
+	return err
+}
+
 // UpdateChatSessionName updates the name for a given chat session.
 func (d *Database) UpdateChatSessionName(sessionID int64, name string) error {
 	_, err := d.db.Exec("UPDATE chat_sessions SET name = ? WHERE id = ?", name, sessionID)
 	return err
 }
 
-// DeleteChatSession deletes a chat session and its messages
+// DeleteChatSession deletes a chat session, its messages, its agent traces,
+// its artifacts and the document collection it owns
 func (d *Database) DeleteChatSession(id int64) error {
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec("DELETE FROM agent_trace_events WHERE trace_id IN (SELECT id FROM agent_traces WHERE session_id = ?)", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM agent_traces WHERE session_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM message_attachments WHERE session_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM artifacts WHERE session_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	_, err = tx.Exec("DELETE FROM chat_messages WHERE session_id = ?", id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	_, err = tx.Exec("DELETE FROM rag_collection_sessions WHERE session_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var collectionID int64
+	err = tx.QueryRow("SELECT id FROM rag_collections WHERE session_id = ?", id).Scan(&collectionID)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return err
+	}
+	if err == nil {
+		if err := deleteRAGCollection(tx, collectionID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	_, err = tx.Exec("DELETE FROM chat_sessions WHERE id = ?", id)
 	if err != nil {
 		tx.Rollback()
@@ -139,27 +467,709 @@ func (d *Database) DeleteChatSession(id int64) error {
 	return tx.Commit()
 }
 
-// SaveChatMessage saves a single chat message to the database.
-func (d *Database) SaveChatMessage(sessionID int64, sender, message string) error {
-	_, err := d.db.Exec("INSERT INTO chat_messages (session_id, sender, message) VALUES (?, ?, ?)", sessionID, sender, message)
+// DeleteChatMessage deletes a message of a session with its attachments, its
+// artifacts and the agent trace of the turn it started.
+func (d *Database) DeleteChatMessage(sessionID, messageID int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	statements := []string{
+		"DELETE FROM agent_trace_events WHERE trace_id IN (SELECT id FROM agent_traces WHERE session_id = ? AND message_id = ?)",
+		"DELETE FROM agent_traces WHERE session_id = ? AND message_id = ?",
+		"DELETE FROM message_attachments WHERE session_id = ? AND message_id = ?",
+		"DELETE FROM artifacts WHERE session_id = ? AND message_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, sessionID, messageID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM chat_messages WHERE session_id = ? AND id = ?", sessionID, messageID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return fmt.Errorf("no message %d in session %d", messageID, sessionID)
+	}
+	return tx.Commit()
+}
+
+// SaveChatMessage saves a single chat message to the database and returns its ID.
+func (d *Database) SaveChatMessage(sessionID int64, sender, message string) (int64, error) {
+	result, err := d.db.Exec("INSERT INTO chat_messages (session_id, sender, message) VALUES (?, ?, ?)", sessionID, sender, message)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// SetChatMessageSampling records the sampling parameters a message was generated with.
+func (d *Database) SetChatMessageSampling(messageID int64, sampling *SamplingSettings) error {
+	samplingParams, err := encodeSampling(sampling)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec("UPDATE chat_messages SET sampling_params = ? WHERE id = ?", samplingParams, messageID)
 	return err
 }
 
+// SetChatMessageValidationErrors records the schema violations found in a generated message.
+func (d *Database) SetChatMessageValidationErrors(messageID int64, validationErrors []string) error {
+	stored := ""
+	if len(validationErrors) > 0 {
+		errorsBytes, err := json.Marshal(validationErrors)
+		if err != nil {
+			return err
+		}
+		stored = string(errorsBytes)
+	}
+	_, err := d.db.Exec("UPDATE chat_messages SET validation_errors = ? WHERE id = ?", stored, messageID)
+	return err
+}
+
+// SetChatMessageRouterDecision records how the Router Agent handled a user message.
+func (d *Database) SetChatMessageRouterDecision(messageID int64, decision *RouterDecision) error {
+	stored := ""
+	if decision != nil {
+		decisionBytes, err := json.Marshal(decision)
+		if err != nil {
+			return err
+		}
+		stored = string(decisionBytes)
+	}
+	_, err := d.db.Exec("UPDATE chat_messages SET router_decision = ? WHERE id = ?", stored, messageID)
+	return err
+}
+
+// SetChatMessageCitations records the document chunks retrieved for a user message.
+func (d *Database) SetChatMessageCitations(messageID int64, citations []Citation) error {
+	stored := ""
+	if len(citations) > 0 {
+		citationsBytes, err := json.Marshal(citations)
+		if err != nil {
+			return err
+		}
+		stored = string(citationsBytes)
+	}
+	_, err := d.db.Exec("UPDATE chat_messages SET citations = ? WHERE id = ?", stored, messageID)
+	return err
+}
+
+// SaveMessageAttachments records what was sent along with a message.
+func (d *Database) SaveMessageAttachments(sessionID, messageID int64, attachments []MessageAttachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		_, err := tx.Exec("INSERT INTO message_attachments (message_id, session_id, kind, artifact_id, name, mime_type, path, source, tokens, truncated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			messageID, sessionID, attachment.Kind, attachment.ArtifactID, attachment.Name, attachment.MIMEType, attachment.Path, attachment.Source, attachment.Tokens, attachment.Truncated)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getSessionAttachments returns the attachments of a session's messages by message ID.
+func (d *Database) getSessionAttachments(sessionID int64) (map[int64][]MessageAttachment, error) {
+	rows, err := d.db.Query("SELECT id, message_id, kind, artifact_id, name, mime_type, path, source, tokens, truncated FROM message_attachments WHERE session_id = ? ORDER BY id", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[int64][]MessageAttachment)
+	for rows.Next() {
+		var attachment MessageAttachment
+		if err := rows.Scan(&attachment.ID, &attachment.MessageID, &attachment.Kind, &attachment.ArtifactID, &attachment.Name, &attachment.MIMEType, &attachment.Path, &attachment.Source, &attachment.Tokens, &attachment.Truncated); err != nil {
+			return nil, err
+		}
+		attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// SaveArtifact stores a persistent artifact of a chat session. It implements
+// artifacts.Store.
+func (d *Database) SaveArtifact(artifact *artifacts.Artifact) error {
+	if artifact.SessionID <= 0 {
+		return fmt.Errorf("artifact %s does not belong to a chat session", artifact.ID)
+	}
+	metadataBytes, err := json.Marshal(artifact.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec("INSERT OR REPLACE INTO artifacts (id, session_id, message_id, tool_call_id, type, content_path, url, metadata, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		artifact.ID, artifact.SessionID, artifact.MessageID, artifact.ToolCallID, string(artifact.Type), artifact.ContentPath, artifact.URL, string(metadataBytes), artifact.Timestamp)
+	return err
+}
+
+// DeleteArtifact forgets a stored artifact. It implements artifacts.Store.
+func (d *Database) DeleteArtifact(id string) error {
+	_, err := d.db.Exec("DELETE FROM artifacts WHERE id = ?", id)
+	return err
+}
+
+// LoadArtifacts returns the stored artifacts, oldest first. Artifacts of
+// sessions that were deleted are forgotten. It implements artifacts.Store.
+func (d *Database) LoadArtifacts() ([]*artifacts.Artifact, error) {
+	if _, err := d.db.Exec("DELETE FROM artifacts WHERE session_id NOT IN (SELECT id FROM chat_sessions)"); err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query("SELECT id, session_id, message_id, tool_call_id, type, content_path, url, metadata, created_at FROM artifacts ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*artifacts.Artifact
+	for rows.Next() {
+		var artifact artifacts.Artifact
+		var artifactType, metadata string
+		if err := rows.Scan(&artifact.ID, &artifact.SessionID, &artifact.MessageID, &artifact.ToolCallID, &artifactType, &artifact.ContentPath, &artifact.URL, &metadata, &artifact.Timestamp); err != nil {
+			return nil, err
+		}
+		artifact.Type = artifacts.ArtifactType(artifactType)
+		if metadata != "" {
+			if err := json.Unmarshal([]byte(metadata), &artifact.Metadata); err != nil {
+				return nil, fmt.Errorf("invalid metadata for artifact %s: %w", artifact.ID, err)
+			}
+		}
+		artifact.IsPersistent = true
+		result = append(result, &artifact)
+	}
+	return result, rows.Err()
+}
+
+// SessionIDs returns the IDs of the existing chat sessions. It implements
+// artifacts.Store.
+func (d *Database) SessionIDs() (map[int64]bool, error) {
+	rows, err := d.db.Query("SELECT id FROM chat_sessions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
 // GetChatMessages retrieves all chat messages for a given session, ordered by creation time.
-func (d *Database) GetChatMessages(sessionID int64) ([]ChatMessage, error) {
-	rows, err := d.db.Query("SELECT sender, message FROM chat_messages WHERE session_id = ? ORDER BY created_at ASC", sessionID)
+func (d *Database) GetChatMessages(sessionID int64) ([]ChatHistoryMessage, error) {
+	attachments, err := d.getSessionAttachments(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query("SELECT id, sender, message, sampling_params, validation_errors, router_decision, citations FROM chat_messages WHERE session_id = ? ORDER BY created_at ASC, id ASC", sessionID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []ChatMessage
+	var messages []ChatHistoryMessage
 	for rows.Next() {
-		var msg ChatMessage
-		if err := rows.Scan(&msg.Role, &msg.Content); err != nil {
+		var msg ChatHistoryMessage
+		var samplingParams, validationErrors, routerDecision, citations string
+		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content, &samplingParams, &validationErrors, &routerDecision, &citations); err != nil {
 			return nil, err
 		}
+		sampling, err := decodeSampling(samplingParams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sampling params for message %d: %w", msg.ID, err)
+		}
+		msg.Sampling = sampling
+		if validationErrors != "" {
+			if err := json.Unmarshal([]byte(validationErrors), &msg.ValidationErrors); err != nil {
+				return nil, fmt.Errorf("invalid validation errors for message %d: %w", msg.ID, err)
+			}
+		}
+		if routerDecision != "" {
+			msg.RouterDecision = &RouterDecision{}
+			if err := json.Unmarshal([]byte(routerDecision), msg.RouterDecision); err != nil {
+				return nil, fmt.Errorf("invalid router decision for message %d: %w", msg.ID, err)
+			}
+		}
+		if citations != "" {
+			if err := json.Unmarshal([]byte(citations), &msg.Citations); err != nil {
+				return nil, fmt.Errorf("invalid citations for message %d: %w", msg.ID, err)
+			}
+		}
+		msg.Attachments = attachments[msg.ID]
 		messages = append(messages, msg)
 	}
 	return messages, nil
 }
+
+// CreateAgentTrace starts a trace for the user message that began an agent run.
+func (d *Database) CreateAgentTrace(sessionID, messageID int64) (int64, error) {
+	result, err := d.db.Exec("INSERT INTO agent_traces (session_id, message_id) VALUES (?, ?)", sessionID, messageID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// AddAgentTraceEvent appends an event to a trace.
+func (d *Database) AddAgentTraceEvent(traceID int64, event TraceEvent) error {
+	_, err := d.db.Exec(`INSERT INTO agent_trace_events
+		(trace_id, seq, kind, name, started_at, duration_ms, prompt_tokens, completion_tokens, details, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		traceID, event.Seq, event.Kind, event.Name, event.StartedAt, event.DurationMs,
+		event.PromptTokens, event.CompletionTokens, string(event.Details), event.Error)
+	return err
+}
+
+// FinishAgentTrace records the end time and outcome of a trace.
+func (d *Database) FinishAgentTrace(traceID int64, outcome string) error {
+	_, err := d.db.Exec("UPDATE agent_traces SET finished_at = CURRENT_TIMESTAMP, outcome = ? WHERE id = ?", outcome, traceID)
+	return err
+}
+
+// GetAgentTraces retrieves all traces of a session with their events, oldest first.
+func (d *Database) GetAgentTraces(sessionID int64) ([]AgentTrace, error) {
+	rows, err := d.db.Query("SELECT id, session_id, message_id, started_at, finished_at, outcome FROM agent_traces WHERE session_id = ? ORDER BY id ASC", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var traces []AgentTrace
+	index := make(map[int64]int)
+	for rows.Next() {
+		var trace AgentTrace
+		var finishedAt sql.NullString
+		if err := rows.Scan(&trace.ID, &trace.SessionID, &trace.MessageID, &trace.StartedAt, &finishedAt, &trace.Outcome); err != nil {
+			return nil, err
+		}
+		trace.FinishedAt = finishedAt.String
+		trace.Events = []TraceEvent{}
+		index[trace.ID] = len(traces)
+		traces = append(traces, trace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	eventRows, err := d.db.Query(`SELECT e.id, e.trace_id, e.seq, e.kind, e.name, e.started_at, e.duration_ms,
+		e.prompt_tokens, e.completion_tokens, e.details, e.error
+		FROM agent_trace_events e JOIN agent_traces t ON e.trace_id = t.id
+		WHERE t.session_id = ? ORDER BY e.trace_id ASC, e.seq ASC`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer eventRows.Close()
+
+	for eventRows.Next() {
+		var event TraceEvent
+		var traceID int64
+		var details string
+		if err := eventRows.Scan(&event.ID, &traceID, &event.Seq, &event.Kind, &event.Name, &event.StartedAt, &event.DurationMs,
+			&event.PromptTokens, &event.CompletionTokens, &details, &event.Error); err != nil {
+			return nil, err
+		}
+		if details != "" {
+			event.Details = json.RawMessage(details)
+		}
+		if i, ok := index[traceID]; ok {
+			traces[i].Events = append(traces[i].Events, event)
+		}
+	}
+	return traces, eventRows.Err()
+}
+
+// SearchChatMessages finds messages in all sessions that contain query, newest first.
+func (d *Database) SearchChatMessages(query string, limit int) ([]nativetools.MessageMatch, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := d.db.Query(`SELECT m.session_id, s.name, m.id, m.sender, m.message, m.created_at
+		FROM chat_messages m JOIN chat_sessions s ON m.session_id = s.id
+		WHERE m.message LIKE ? ESCAPE '\'
+		ORDER BY m.created_at DESC, m.id DESC LIMIT ?`, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []nativetools.MessageMatch
+	for rows.Next() {
+		var match nativetools.MessageMatch
+		if err := rows.Scan(&match.SessionID, &match.SessionName, &match.MessageID, &match.Sender, &match.Content, &match.CreatedAt); err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// CreateRAGCollection creates a document collection. A collection created for
+// a chat is owned by it and attached to it.
+func (d *Database) CreateRAGCollection(name string, sessionID int64) (int64, error) {
+	result, err := d.db.Exec("INSERT INTO rag_collections (name, session_id) VALUES (?, ?)", name, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if sessionID != 0 {
+		if err := d.AttachRAGCollection(id, sessionID); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+const ragCollectionColumns = `c.id, c.name, c.session_id, c.created_at,
+	(SELECT COUNT(*) FROM rag_documents WHERE collection_id = c.id AND hash != ''),
+	(SELECT COUNT(*) FROM rag_chunks WHERE document_id IN (SELECT id FROM rag_documents WHERE collection_id = c.id))`
+
+func scanRAGCollections(rows *sql.Rows) ([]RAGCollection, error) {
+	defer rows.Close()
+	var collections []RAGCollection
+	for rows.Next() {
+		var c RAGCollection
+		if err := rows.Scan(&c.ID, &c.Name, &c.SessionID, &c.CreatedAt, &c.Documents, &c.Chunks); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// GetRAGCollections lists all document collections by name.
+func (d *Database) GetRAGCollections() ([]RAGCollection, error) {
+	rows, err := d.db.Query("SELECT " + ragCollectionColumns + " FROM rag_collections c ORDER BY c.name")
+	if err != nil {
+		return nil, err
+	}
+	return scanRAGCollections(rows)
+}
+
+// GetSessionRAGCollections lists the document collections attached to a chat.
+func (d *Database) GetSessionRAGCollections(sessionID int64) ([]RAGCollection, error) {
+	rows, err := d.db.Query("SELECT "+ragCollectionColumns+` FROM rag_collections c
+		JOIN rag_collection_sessions cs ON cs.collection_id = c.id
+		WHERE cs.session_id = ? ORDER BY c.name`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRAGCollections(rows)
+}
+
+// GetRAGCollectionByName finds a collection by name. It returns nil if there is none.
+func (d *Database) GetRAGCollectionByName(name string) (*RAGCollection, error) {
+	rows, err := d.db.Query("SELECT "+ragCollectionColumns+" FROM rag_collections c WHERE c.name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	collections, err := scanRAGCollections(rows)
+	if err != nil || len(collections) == 0 {
+		return nil, err
+	}
+	return &collections[0], nil
+}
+
+// GetSessionOwnedRAGCollectionID returns the collection owned by a chat, or 0.
+func (d *Database) GetSessionOwnedRAGCollectionID(sessionID int64) (int64, error) {
+	var id int64
+	err := d.db.QueryRow("SELECT id FROM rag_collections WHERE session_id = ?", sessionID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// AttachRAGCollection makes a chat retrieve from a collection.
+func (d *Database) AttachRAGCollection(collectionID, sessionID int64) error {
+	_, err := d.db.Exec("INSERT OR IGNORE INTO rag_collection_sessions (collection_id, session_id) VALUES (?, ?)", collectionID, sessionID)
+	return err
+}
+
+// DetachRAGCollection stops a chat from retrieving from a collection.
+func (d *Database) DetachRAGCollection(collectionID, sessionID int64) error {
+	_, err := d.db.Exec("DELETE FROM rag_collection_sessions WHERE collection_id = ? AND session_id = ?", collectionID, sessionID)
+	return err
+}
+
+// DeleteRAGCollection deletes a collection with its sources, documents and chunks.
+func (d *Database) DeleteRAGCollection(id int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := deleteRAGCollection(tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteRAGCollection(tx *sql.Tx, id int64) error {
+	statements := []string{
+		"DELETE FROM rag_chunks WHERE document_id IN (SELECT id FROM rag_documents WHERE collection_id = ?)",
+		"DELETE FROM rag_documents WHERE collection_id = ?",
+		"DELETE FROM rag_sources WHERE collection_id = ?",
+		"DELETE FROM rag_collection_sessions WHERE collection_id = ?",
+		"DELETE FROM rag_collections WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRAGSource registers a file or folder of a collection and returns its ID.
+// Adding a registered path returns the existing source.
+func (d *Database) AddRAGSource(collectionID int64, path string) (int64, error) {
+	if _, err := d.db.Exec("INSERT OR IGNORE INTO rag_sources (collection_id, path) VALUES (?, ?)", collectionID, path); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := d.db.QueryRow("SELECT id FROM rag_sources WHERE collection_id = ? AND path = ?", collectionID, path).Scan(&id)
+	return id, err
+}
+
+// GetRAGSources lists the files and folders of all collections.
+func (d *Database) GetRAGSources() ([]RAGSource, error) {
+	rows, err := d.db.Query("SELECT id, collection_id, path, scanned_at FROM rag_sources ORDER BY collection_id, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []RAGSource
+	for rows.Next() {
+		var source RAGSource
+		if err := rows.Scan(&source.ID, &source.CollectionID, &source.Path, &source.ScannedAt); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// SetRAGSourceScanned records that a source was synced completely.
+func (d *Database) SetRAGSourceScanned(sourceID int64) error {
+	_, err := d.db.Exec("UPDATE rag_sources SET scanned_at = ? WHERE id = ?", time.Now().UTC().Format(time.RFC3339), sourceID)
+	return err
+}
+
+// DeleteRAGSource removes a file or folder from a collection, with its documents.
+func (d *Database) DeleteRAGSource(collectionID int64, path string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	statements := []string{
+		"DELETE FROM rag_chunks WHERE document_id IN (SELECT id FROM rag_documents WHERE source_id IN (SELECT id FROM rag_sources WHERE collection_id = ? AND path = ?))",
+		"DELETE FROM rag_documents WHERE source_id IN (SELECT id FROM rag_sources WHERE collection_id = ? AND path = ?)",
+		"DELETE FROM rag_sources WHERE collection_id = ? AND path = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, collectionID, path); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRAGSourceDocuments returns the stat of each document of a source, by path.
+func (d *Database) GetRAGSourceDocuments(sourceID int64) (map[string]RAGFileStat, error) {
+	rows, err := d.db.Query("SELECT path, size, mod_time FROM rag_documents WHERE source_id = ?", sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := make(map[string]RAGFileStat)
+	for rows.Next() {
+		var path string
+		var stat RAGFileStat
+		if err := rows.Scan(&path, &stat.Size, &stat.ModTime); err != nil {
+			return nil, err
+		}
+		documents[path] = stat
+	}
+	return documents, rows.Err()
+}
+
+// DeleteRAGDocument removes a document and its chunks from a collection.
+func (d *Database) DeleteRAGDocument(collectionID int64, path string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM rag_chunks WHERE document_id IN (SELECT id FROM rag_documents WHERE collection_id = ? AND path = ?)", collectionID, path)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM rag_documents WHERE collection_id = ? AND path = ?", collectionID, path)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateRAGDocumentStat records the stat of a document whose content did not change.
+func (d *Database) UpdateRAGDocumentStat(collectionID int64, path string, stat RAGFileStat) error {
+	_, err := d.db.Exec("UPDATE rag_documents SET size = ?, mod_time = ? WHERE collection_id = ? AND path = ?", stat.Size, stat.ModTime, collectionID, path)
+	return err
+}
+
+// GetRAGDocumentHash returns the content hash a document was indexed with, or
+// "" if it is not indexed.
+func (d *Database) GetRAGDocumentHash(collectionID int64, path string) (string, error) {
+	var hash string
+	err := d.db.QueryRow("SELECT hash FROM rag_documents WHERE collection_id = ? AND path = ?", collectionID, path).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+// ReplaceRAGDocument stores the chunks of a document, replacing those of an
+// earlier version. A document that cannot be indexed is stored with an empty
+// hash and no chunks.
+func (d *Database) ReplaceRAGDocument(collectionID, sourceID int64, path, hash string, stat RAGFileStat, chunks []RAGChunk) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO rag_documents (collection_id, source_id, path, hash, size, mod_time) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(collection_id, path) DO UPDATE SET source_id = excluded.source_id, hash = excluded.hash,
+			size = excluded.size, mod_time = excluded.mod_time, indexed_at = CURRENT_TIMESTAMP`,
+		collectionID, sourceID, path, hash, stat.Size, stat.ModTime)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var documentID int64
+	if err := tx.QueryRow("SELECT id FROM rag_documents WHERE collection_id = ? AND path = ?", collectionID, path).Scan(&documentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM rag_chunks WHERE document_id = ?", documentID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, chunk := range chunks {
+		_, err := tx.Exec("INSERT INTO rag_chunks (document_id, seq, content, embedding) VALUES (?, ?, ?, ?)",
+			documentID, chunk.Seq, chunk.Content, encodeVector(chunk.Embedding))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRAGChunks loads the chunks of the given collections, with their embeddings.
+func (d *Database) GetRAGChunks(collectionIDs []int64) ([]RAGChunk, error) {
+	if len(collectionIDs) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inClause(collectionIDs)
+	rows, err := d.db.Query(`SELECT ch.id, ch.seq, ch.content, ch.embedding, doc.path, c.name
+		FROM rag_chunks ch
+		JOIN rag_documents doc ON ch.document_id = doc.id
+		JOIN rag_collections c ON doc.collection_id = c.id
+		WHERE doc.collection_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []RAGChunk
+	for rows.Next() {
+		var chunk RAGChunk
+		var embedding []byte
+		if err := rows.Scan(&chunk.ID, &chunk.Seq, &chunk.Content, &embedding, &chunk.Path, &chunk.Collection); err != nil {
+			return nil, err
+		}
+		chunk.Embedding = decodeVector(embedding)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// HasFullTextSearch reports whether SearchRAGChunks can be used.
+func (d *Database) HasFullTextSearch() bool {
+	return d.fts5
+}
+
+// SearchRAGChunks ranks the chunks of the given collections that match query
+// by FTS5's BM25, best first. The scores are BM25 scores, higher is better.
+func (d *Database) SearchRAGChunks(collectionIDs []int64, query string, limit int) ([]chunkScore, error) {
+	match := ftsQuery(query)
+	if len(collectionIDs) == 0 || match == "" {
+		return nil, nil
+	}
+	placeholders, args := inClause(collectionIDs)
+	args = append([]interface{}{match}, args...)
+	args = append(args, limit)
+	rows, err := d.db.Query(`SELECT f.rowid, -bm25(rag_chunks_fts)
+		FROM rag_chunks_fts f
+		JOIN rag_chunks ch ON ch.id = f.rowid
+		JOIN rag_documents doc ON ch.document_id = doc.id
+		WHERE rag_chunks_fts MATCH ? AND doc.collection_id IN (`+placeholders+`)
+		ORDER BY bm25(rag_chunks_fts) LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scores []chunkScore
+	for rows.Next() {
+		var score chunkScore
+		if err := rows.Scan(&score.ChunkID, &score.Score); err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+	}
+	return scores, rows.Err()
+}
+
+// ftsQuery turns a user message into an FTS5 query that matches any of its
+// words. Each word is quoted, so identifiers such as PROJ-1234 or
+// parse_config match as phrases and FTS5 operators have no effect.
+func ftsQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		word = strings.Trim(word, ".,;:!?()[]{}<>'\"`")
+		if word == "" {
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// inClause returns the placeholders and arguments for an IN (...) clause.
+func inClause(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addTestTurn saves a user message with an attached file, a paged tool result
+// and an agent trace, as a chat turn leaves them. It returns the message ID
+// and the files of its artifacts.
+func addTestTurn(t *testing.T, app *App, sessionID int64, name string) (int64, []string) {
+	t.Helper()
+	messageID, err := app.db.SaveChatMessage(sessionID, "user", "Read "+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), name+".txt")
+	if err := os.WriteFile(path, []byte("Notes of "+name), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, attachments, _, err := app.prepareAttachments(sessionID, []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.db.SaveMessageAttachments(sessionID, messageID, attachments); err != nil {
+		t.Fatal(err)
+	}
+	app.linkAttachments(attachments, messageID)
+
+	origin := toolCallOrigin{SessionID: sessionID, MessageID: messageID, ID: "call-" + name, ToolName: "fetch"}
+	if _, paged := app.pageToolResult(origin, strings.Repeat("A long page. ", 50)); !paged {
+		t.Fatal("tool result was not paged")
+	}
+
+	trace := app.startTrace(sessionID, messageID)
+	trace.ToolCall(ToolCall{ToolName: "fetch"}, time.Now(), 650, false, nil)
+	trace.Finish(TraceOutcomeAnswered)
+
+	stored, err := app.ArtifactService.ListArtifacts(sessionID, messageID)
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("turn %s has %d artifacts, want the attachment and the paged result: %v", name, len(stored), err)
+	}
+	var files []string
+	for _, artifact := range stored {
+		files = append(files, artifact.ContentPath)
+	}
+	return messageID, files
+}
+
+func TestDeleteCascades(t *testing.T) {
+	app := newTestApp(t, "http://127.0.0.1:1")
+	app.config.MaxToolResultTokens = 5
+
+	sessionID, err := app.db.NewChatSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned, err := app.db.CreateRAGCollection("chat files", sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceID, err := app.db.AddRAGSource(owned, "/docs/a.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.db.ReplaceRAGDocument(owned, sourceID, "/docs/a.md", "hash", RAGFileStat{}, []RAGChunk{{Content: "text"}}); err != nil {
+		t.Fatal(err)
+	}
+	named, err := app.db.CreateRAGCollection("handbook", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.db.AttachRAGCollection(named, sessionID); err != nil {
+		t.Fatal(err)
+	}
+
+	first, firstFiles := addTestTurn(t, app, sessionID, "first")
+	second, secondFiles := addTestTurn(t, app, sessionID, "second")
+
+	count := func(query string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		if err := app.db.db.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+	rowsOf := func(messageID int64) map[string]int {
+		return map[string]int{
+			"message":     count("SELECT COUNT(*) FROM chat_messages WHERE id = ?", messageID),
+			"attachments": count("SELECT COUNT(*) FROM message_attachments WHERE message_id = ?", messageID),
+			"artifacts":   count("SELECT COUNT(*) FROM artifacts WHERE message_id = ?", messageID),
+			"traces":      count("SELECT COUNT(*) FROM agent_traces WHERE message_id = ?", messageID),
+			"trace events": count(`SELECT COUNT(*) FROM agent_trace_events WHERE trace_id IN
+				(SELECT id FROM agent_traces WHERE message_id = ?)`, messageID),
+		}
+	}
+	checkFiles := func(files []string, exist bool) {
+		t.Helper()
+		for _, file := range files {
+			if _, err := os.Stat(file); (err == nil) != exist {
+				t.Errorf("%s: exists %v, want %v", filepath.Base(file), err == nil, exist)
+			}
+		}
+	}
+	for table, n := range rowsOf(first) {
+		if n == 0 {
+			t.Fatalf("no %s rows were saved for the first turn", table)
+		}
+	}
+
+	if err := app.DeleteChatMessage(sessionID, first); err != nil {
+		t.Fatal(err)
+	}
+	for table, n := range rowsOf(first) {
+		if n != 0 {
+			t.Errorf("deleted message left %d %s rows", n, table)
+		}
+	}
+	for table, n := range rowsOf(second) {
+		if n == 0 {
+			t.Errorf("deleting the first message deleted the second's %s rows", table)
+		}
+	}
+	checkFiles(firstFiles, false)
+	checkFiles(secondFiles, true)
+
+	if err := app.DeleteChatSession(sessionID); err != nil {
+		t.Fatal(err)
+	}
+	for table, n := range rowsOf(second) {
+		if n != 0 {
+			t.Errorf("deleted session left %d %s rows", n, table)
+		}
+	}
+	for _, table := range []string{"chat_messages", "message_attachments", "artifacts", "agent_traces", "rag_collection_sessions"} {
+		if n := count("SELECT COUNT(*) FROM "+table+" WHERE session_id = ?", sessionID); n != 0 {
+			t.Errorf("deleted session left %d rows in %s", n, table)
+		}
+	}
+	if n := count("SELECT COUNT(*) FROM rag_collections WHERE id = ?", owned); n != 0 {
+		t.Error("the collection owned by the session was kept")
+	}
+	if n := count("SELECT COUNT(*) FROM rag_documents WHERE collection_id = ?", owned); n != 0 {
+		t.Error("the documents of the session's collection were kept")
+	}
+	if n := count("SELECT COUNT(*) FROM rag_collections WHERE id = ?", named); n != 1 {
+		t.Error("a named collection attached to the session was deleted")
+	}
+	checkFiles(secondFiles, false)
+	if left := app.ArtifactService.AllArtifacts(); len(left) != 0 {
+		t.Errorf("%d artifacts left in memory", len(left))
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmbeddingClient requests embeddings from llama-server's /embedding endpoint.
+// The server must be started with --embedding (or --embeddings).
+type EmbeddingClient struct {
+	BaseURL    string
+	httpClient *http.Client
+}
+
+// NewEmbeddingClient creates a client for the llama-server at baseURL.
+func NewEmbeddingClient(baseURL string) *EmbeddingClient {
+	return &EmbeddingClient{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Embed returns one embedding vector per input text, in input order.
+func (c *EmbeddingClient) Embed(texts []string) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{"content": texts})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling embedding request: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(c.BaseURL+"/embedding", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("error making embedding request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading embedding response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var results []struct {
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
+	}
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, fmt.Errorf("error unmarshalling embedding response: %w", err)
+	}
+	if len(results) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(results))
+	}
+
+	vectors := make([][]float64, len(texts))
+	for _, result := range results {
+		if result.Index < 0 || result.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", result.Index)
+		}
+		vector, err := decodeEmbeddingVector(result.Embedding)
+		if err != nil {
+			return nil, err
+		}
+		vectors[result.Index] = vector
+	}
+	return vectors, nil
+}
+
+// decodeEmbeddingVector accepts both the pooled form [[...]] returned by recent
+// llama-server versions and the flat form [...] returned by older ones.
+func decodeEmbeddingVector(raw json.RawMessage) ([]float64, error) {
+	var nested [][]float64
+	if err := json.Unmarshal(raw, &nested); err == nil {
+		if len(nested) != 1 {
+			return nil, fmt.Errorf("expected a pooled embedding, got %d token vectors (start the server with --pooling mean)", len(nested))
+		}
+		return nested[0], nil
+	}
+	var flat []float64
+	if err := json.Unmarshal(raw, &flat); err != nil {
+		return nil, fmt.Errorf("error decoding embedding vector: %w", err)
+	}
+	return flat, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors, or 0 if
+// either is empty or their lengths differ.
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// defaultEmbeddingServerPort is the port of the server launched by
+// LaunchEmbeddingServer, next to the chat server's 8080.
+const defaultEmbeddingServerPort = 8081
+
+// embeddingClient returns a client for the configured embedding server. It
+// defaults to the server launched by LaunchEmbeddingServer, if one runs, and
+// otherwise to the chat llama-server.
+func (a *App) embeddingClient() *EmbeddingClient {
+	baseURL := a.config.EmbeddingServerURL
+	if baseURL == "" && a.IsEmbeddingServerLoaded() {
+		baseURL = a.embeddingServerURL()
+	}
+	if baseURL == "" {
+		baseURL = llamaServerURL
+	}
+	return NewEmbeddingClient(baseURL)
+}
+
+// embeddingServerURL is the address of the server launched by
+// LaunchEmbeddingServer.
+func (a *App) embeddingServerURL() string {
+	port := a.config.EmbeddingServerPort
+	if port <= 0 {
+		port = defaultEmbeddingServerPort
+	}
+	return fmt.Sprintf("http://localhost:%d", port)
+}
+
+// LaunchEmbeddingServer launches a second llama-server that serves an
+// embedding model for document retrieval, so the chat model need not be
+// started with --embedding.
+func (a *App) LaunchEmbeddingServer(modelPath string, modelArgs string) (string, error) {
+	if running := a.embeddingServer(); running != nil && running.Process != nil {
+		a.logInfo("Terminating existing embedding server process...")
+		if err := running.Process.Kill(); err != nil {
+			a.logErrorf("Failed to terminate existing embedding server: %v", err)
+		}
+	}
+
+	port := a.config.EmbeddingServerPort
+	if port <= 0 {
+		port = defaultEmbeddingServerPort
+	}
+	args := []string{"-m", modelPath, "--embedding", "--pooling", "mean", "--port", strconv.Itoa(port)}
+	if modelArgs != "" {
+		args = append(args, strings.Fields(modelArgs)...)
+	}
+
+	cmd, err := a.startLlamaServer(args, "embedding-server.log")
+	if err != nil {
+		return "", fmt.Errorf("failed to start embedding server: %w", err)
+	}
+	a.serverMu.Lock()
+	a.embeddingCmd = cmd
+	a.serverMu.Unlock()
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			a.logErrorf("Embedding server exited with error: %v", err)
+		}
+		a.serverMu.Lock()
+		defer a.serverMu.Unlock()
+		if a.embeddingCmd == cmd {
+			a.embeddingCmd = nil
+		}
+	}()
+	return "Embedding server launched successfully!", nil
+}
+
+// embeddingServer returns the running embedding server, or nil.
+func (a *App) embeddingServer() *exec.Cmd {
+	a.serverMu.Lock()
+	defer a.serverMu.Unlock()
+	return a.embeddingCmd
+}
+
+// IsEmbeddingServerLoaded reports whether the server launched by
+// LaunchEmbeddingServer is running.
+func (a *App) IsEmbeddingServerLoaded() bool {
+	cmd := a.embeddingServer()
+	return cmd != nil && cmd.Process != nil
+}
+
+// ShutdownEmbeddingServer stops the server launched by LaunchEmbeddingServer.
+func (a *App) ShutdownEmbeddingServer() error {
+	cmd := a.embeddingServer()
+	if cmd == nil {
+		return nil
+	}
+	a.logInfo("Shutting down embedding server...")
+	if err := shutdownLLM(cmd); err != nil {
+		a.logErrorf("Failed to shut down embedding server: %v. Attempting to kill.", err)
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// ensureEmbeddingServer launches embedding_model if it is configured and not
+// running, and waits until it is ready. Without embedding_model, embeddings
+// come from embedding_server_url or the chat server as before.
+func (a *App) ensureEmbeddingServer() error {
+	a.launchMu.Lock()
+	defer a.launchMu.Unlock()
+
+	if a.config.EmbeddingServerURL != "" || a.config.EmbeddingModel == "" || a.IsEmbeddingServerLoaded() {
+		return nil
+	}
+	a.logInfof("Launching embedding server for %s", a.config.EmbeddingModel)
+	if _, err := a.LaunchEmbeddingServer(a.config.EmbeddingModel, a.config.EmbeddingModelArgs); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(llmStartTimeout)
+	for time.Now().Before(deadline) {
+		if status, err := serverHealth(a.embeddingServerURL()); err == nil && status == "ok" {
+			return nil
+		}
+		if !a.IsEmbeddingServerLoaded() {
+			return fmt.Errorf("embedding server exited while loading %s", filepath.Base(a.config.EmbeddingModel))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("embedding server did not load %s within %s", filepath.Base(a.config.EmbeddingModel), llmStartTimeout)
+}
@@ -0,0 +1,175 @@
+// Package events is the app's internal event bus. The core publishes what it
+// does (streamed replies, token statistics, artifacts, downloads, log
+// messages) and any number of subscribers listen: the bridge to the Wails
+// frontend, loggers, HTTP clients and tests. Nothing in the core needs a
+// window to run.
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Name identifies the kind of an event. The names are the event names the
+// frontend listens to.
+type Name string
+
+// Chat events.
+const (
+	ChatStream         Name = "chat-stream"      // Data: string chunk of a reply; nil ends the reply
+	ReasoningStream    Name = "reasoning-stream" // Data: string chunk of reasoning
+	SessionNameUpdated Name = "sessionNameUpdated"
+	OutputValidation   Name = "output-validation"
+	Citations          Name = "citations" // Data: the document chunks retrieved for a user message
+)
+
+// Token statistics.
+const (
+	TokenStats        Name = "token-stats"
+	SessionTokenTotal Name = "session-token-total"
+)
+
+// Artifact events.
+const (
+	ArtifactAdded   Name = "artifactAdded"   // Data: *artifacts.Artifact
+	ArtifactDeleted Name = "artifactDeleted" // Data: string artifact ID
+)
+
+// Document indexing events.
+const (
+	IndexProgress Name = "rag-index-progress" // Data: the file being indexed, with done and total counts
+	IndexComplete Name = "rag-index-complete" // Data: RAGSyncResult of a source
+)
+
+// Text to speech events.
+const (
+	SpeechAudio    Name = "tts-audio"    // Data: the audio of one sentence of a streamed reply
+	SpeechComplete Name = "tts-complete" // Data: session ID; the reply has been spoken
+)
+
+// llama.cpp download events.
+const (
+	DownloadProgress Name = "llama-cpp-download-progress"
+	DownloadComplete Name = "llama-cpp-download-complete"
+	DownloadError    Name = "llama-cpp-download-error"
+)
+
+// Log carries a LogEntry. Log events are not sent to the frontend as events.
+const Log Name = "log"
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarning:
+		return "WARNING"
+	default:
+		return "ERROR"
+	}
+}
+
+// LogEntry is the data of a Log event.
+type LogEntry struct {
+	Level   LogLevel `json:"level"`
+	Message string   `json:"message"`
+}
+
+// Event is something that happened in the app.
+type Event struct {
+	Name Name        `json:"name"`
+	Data interface{} `json:"data"`
+	Time time.Time   `json:"time"`
+}
+
+// Handler receives events. Handlers are called synchronously, in the order
+// events are published, so they must not block; a slow consumer should queue.
+type Handler func(Event)
+
+type subscription struct {
+	id      int
+	handler Handler
+	names   map[Name]bool // Empty means all events
+}
+
+// Bus delivers published events to its subscribers. A nil *Bus drops every
+// event, so code that runs without one needs no checks.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+	nextID        int
+}
+
+// NewBus creates an empty bus.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe calls handler for every event with one of the given names, or for
+// every event if no names are given. The returned function unsubscribes.
+// Subscribing to a nil *Bus does nothing.
+func (b *Bus) Subscribe(handler Handler, names ...Name) (unsubscribe func()) {
+	if b == nil {
+		return func() {}
+	}
+	sub := subscription{handler: handler, names: make(map[Name]bool)}
+	for _, name := range names {
+		sub.names[name] = true
+	}
+
+	b.mu.Lock()
+	sub.id = b.nextID
+	b.nextID++
+	b.subscriptions = append(b.subscriptions, sub)
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subscriptions {
+			if s.id == sub.id {
+				b.subscriptions = append(b.subscriptions[:i:i], b.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish sends an event to the subscribers that listen to its name, in the
+// order they subscribed.
+func (b *Bus) Publish(name Name, data interface{}) {
+	if b == nil {
+		return
+	}
+	event := Event{Name: name, Data: data, Time: time.Now()}
+
+	b.mu.RLock()
+	var handlers []Handler
+	for _, sub := range b.subscriptions {
+		if len(sub.names) == 0 || sub.names[name] {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Logf publishes a Log event.
+func (b *Bus) Logf(level LogLevel, format string, args ...interface{}) {
+	b.Publish(Log, LogEntry{Level: level, Message: fmt.Sprintf(format, args...)})
+}
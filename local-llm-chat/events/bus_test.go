@@ -0,0 +1,161 @@
+package events
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func eventNames(events []Event) []Name {
+	var names []Name
+	for _, event := range events {
+		names = append(names, event.Name)
+	}
+	return names
+}
+
+func TestBusSubscribeAndUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	var first, second Recorder
+	unsubscribeFirst := bus.Subscribe(first.Handle)
+	bus.Subscribe(second.Handle)
+
+	bus.Publish(ChatStream, "Hello")
+	unsubscribeFirst()
+	bus.Publish(ChatStream, nil)
+	unsubscribeFirst() // A second call is harmless.
+
+	if got := first.Events(); len(got) != 1 || got[0].Data != "Hello" {
+		t.Errorf("first subscriber got %+v, want only the event before unsubscribing", got)
+	}
+	if got := second.Events(); len(got) != 2 || got[1].Data != nil {
+		t.Errorf("second subscriber got %+v, want both events", got)
+	}
+	if got := second.Events(); got[0].Time.IsZero() {
+		t.Error("event has no time")
+	}
+}
+
+func TestBusDeliversInOrder(t *testing.T) {
+	bus := NewBus()
+	var order []string
+	bus.Subscribe(func(event Event) { order = append(order, "a:"+event.Data.(string)) })
+	bus.Subscribe(func(event Event) { order = append(order, "b:"+event.Data.(string)) })
+
+	bus.Publish(ChatStream, "1")
+	bus.Publish(ChatStream, "2")
+
+	want := []string{"a:1", "b:1", "a:2", "b:2"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("delivery order = %v, want %v", order, want)
+	}
+}
+
+func TestBusNameFilter(t *testing.T) {
+	bus := NewBus()
+	var chat, all Recorder
+	bus.Subscribe(chat.Handle, ChatStream, ReasoningStream)
+	bus.Subscribe(all.Handle)
+
+	bus.Publish(ChatStream, "answer")
+	bus.Publish(TokenStats, nil)
+	bus.Publish(ReasoningStream, "thought")
+	bus.Logf(LogInfo, "loaded %d messages", 3)
+
+	if got, want := eventNames(chat.Events()), []Name{ChatStream, ReasoningStream}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filtered subscriber got %v, want %v", got, want)
+	}
+	if got, want := eventNames(all.Events()), []Name{ChatStream, TokenStats, ReasoningStream, Log}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unfiltered subscriber got %v, want %v", got, want)
+	}
+	if got := eventNames(all.Events(Log, TokenStats)); !reflect.DeepEqual(got, []Name{TokenStats, Log}) {
+		t.Errorf("Recorder.Events(Log, TokenStats) = %v", got)
+	}
+	entry, ok := all.Events(Log)[0].Data.(LogEntry)
+	if !ok || entry.Level != LogInfo || entry.Message != "loaded 3 messages" {
+		t.Errorf("log event data = %+v", all.Events(Log)[0].Data)
+	}
+
+	all.Reset()
+	if got := all.Events(); len(got) != 0 {
+		t.Errorf("after Reset got %d events", len(got))
+	}
+}
+
+func TestBusUnsubscribeDuringPublish(t *testing.T) {
+	bus := NewBus()
+	var unsubscribe func()
+	calls := 0
+	unsubscribe = bus.Subscribe(func(Event) {
+		calls++
+		unsubscribe()
+	})
+
+	bus.Publish(ChatStream, "1")
+	bus.Publish(ChatStream, "2")
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(ChatStream, "dropped")
+	bus.Logf(LogError, "dropped too")
+	unsubscribe := bus.Subscribe(func(Event) { t.Error("handler of a nil bus was called") })
+	bus.Publish(ChatStream, "dropped")
+	unsubscribe()
+}
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	bus := NewBus()
+	bus.Subscribe(NewLogger(log.New(&out, "", 0), LogWarning), Log)
+
+	bus.Logf(LogInfo, "not shown")
+	bus.Logf(LogWarning, "disk %s", "full")
+	bus.Logf(LogError, "failed")
+	bus.Publish(ChatStream, "not a log entry")
+
+	want := "WARNING | disk full\nERROR | failed\n"
+	if out.String() != want {
+		t.Errorf("logger wrote %q, want %q", out.String(), want)
+	}
+	if strings.Contains(out.String(), "not shown") {
+		t.Error("logger wrote a message below its level")
+	}
+}
+
+func TestSSEClientDropsAndCounts(t *testing.T) {
+	client := &sseClient{queue: make(chan Event, 2), overflow: make(chan struct{})}
+	for i := 0; i < 4; i++ {
+		client.handle(Event{Name: TokenStats, Data: i})
+	}
+	<-client.queue
+	<-client.queue
+
+	client.handle(Event{Name: TokenStats, Data: 4})
+	marker := <-client.queue
+	if marker.Name != Dropped || marker.Data != (DroppedEvents{Count: 2}) {
+		t.Errorf("got %+v, want a Dropped event counting 2", marker)
+	}
+	if event := <-client.queue; event.Data != 4 {
+		t.Errorf("got %+v, want the event after the drop", event)
+	}
+
+	// A reply chunk is never dropped quietly: the stream ends instead.
+	client.handle(Event{Name: TokenStats, Data: 5})
+	client.handle(Event{Name: TokenStats, Data: 6})
+	client.handle(Event{Name: ChatStream, Data: "Hello"})
+	select {
+	case <-client.overflow:
+	default:
+		t.Fatal("a dropped reply chunk did not end the stream")
+	}
+	client.handle(Event{Name: TokenStats, Data: 7})
+	if len(client.queue) != 2 || client.dropped != 1 {
+		t.Errorf("after the end: %d queued, %d dropped; want 2 and 1", len(client.queue), client.dropped)
+	}
+}
@@ -0,0 +1,188 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewLogger returns a handler that writes Log events at or above minLevel to
+// logger. Subscribe it with the Log name.
+func NewLogger(logger *log.Logger, minLevel LogLevel) Handler {
+	return func(event Event) {
+		entry, ok := event.Data.(LogEntry)
+		if ok && entry.Level >= minLevel {
+			logger.Printf("%s | %s", entry.Level, entry.Message)
+		}
+	}
+}
+
+// Recorder keeps every event it receives, for tests and debugging.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Handle records an event. Subscribe it with bus.Subscribe(recorder.Handle).
+func (r *Recorder) Handle(event Event) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+// Events returns the recorded events with the given names, or all of them if
+// no names are given, in the order they were published.
+func (r *Recorder) Events(names ...Name) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []Event
+	for _, event := range r.events {
+		if len(names) == 0 || containsName(names, event.Name) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// Reset forgets the recorded events.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
+
+func containsName(names []Name, name Name) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sseQueueSize is how many events an SSE client may fall behind before
+// events are dropped for it.
+const sseQueueSize = 256
+
+// Dropped is sent to an SSE client in place of the events it fell too far
+// behind to receive. Its data is a DroppedEvents.
+const Dropped Name = "dropped"
+
+// DroppedEvents is the data of a Dropped event.
+type DroppedEvents struct {
+	Count int `json:"count"`
+}
+
+// sseClient queues the events of one SSE client.
+type sseClient struct {
+	queue    chan Event
+	overflow chan struct{} // Closed when a reply chunk did not fit
+
+	mu      sync.Mutex
+	dropped int
+	closed  bool
+}
+
+// handle queues an event without waiting. When the queue is full the event is
+// dropped and counted; the count is queued as a Dropped event as soon as
+// there is room again. A dropped reply chunk would leave a hole in the reply,
+// so it ends the stream instead.
+func (c *sseClient) handle(event Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if c.dropped > 0 {
+		select {
+		case c.queue <- Event{Name: Dropped, Data: DroppedEvents{Count: c.dropped}, Time: event.Time}:
+			c.dropped = 0
+		default:
+		}
+	}
+	if c.dropped == 0 {
+		select {
+		case c.queue <- event:
+			return
+		default:
+		}
+	}
+	c.dropped++
+	if event.Name == ChatStream || event.Name == ReasoningStream {
+		c.closed = true
+		close(c.overflow)
+	}
+}
+
+// NewSSEHandler serves the bus as a stream of server-sent events. Each event
+// is sent with its name as the SSE event type and its JSON encoding as data.
+// Clients can limit the stream with ?names=chat-stream,artifactAdded.
+//
+// A client that falls behind misses events and is told how many with a
+// Dropped event, so it can reload what it shows. Reply chunks are never
+// skipped: a client that cannot keep up with a reply gets the Dropped event
+// and is disconnected.
+func NewSSEHandler(bus *Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var names []Name
+		for _, name := range strings.Split(r.URL.Query().Get("names"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, Name(name))
+			}
+		}
+
+		// Publish must never wait for a slow client, so events are queued.
+		client := &sseClient{queue: make(chan Event, sseQueueSize), overflow: make(chan struct{})}
+		unsubscribe := bus.Subscribe(client.handle, names...)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		write := func(event Event) bool {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return true
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, data)
+			return err == nil
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-client.queue:
+				if !write(event) {
+					return
+				}
+				flusher.Flush()
+			case <-client.overflow:
+				// Send what was queued before the drop, then the count.
+				for len(client.queue) > 0 {
+					if !write(<-client.queue) {
+						return
+					}
+				}
+				client.mu.Lock()
+				dropped := client.dropped
+				client.mu.Unlock()
+				write(Event{Name: Dropped, Data: DroppedEvents{Count: dropped}, Time: time.Now()})
+				flusher.Flush()
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	"local-llm-chat/events"
+
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// subscribeWails forwards the bus to the Wails runtime: log events to its
+// logger and every other event to the frontend. ctx must be the context the
+// runtime passes to startup.
+func subscribeWails(bus *events.Bus, ctx context.Context) {
+	bus.Subscribe(func(event events.Event) {
+		if event.Name != events.Log {
+			wailsruntime.EventsEmit(ctx, string(event.Name), event.Data)
+			return
+		}
+		entry, _ := event.Data.(events.LogEntry)
+		switch entry.Level {
+		case events.LogDebug:
+			wailsruntime.LogDebug(ctx, entry.Message)
+		case events.LogInfo:
+			wailsruntime.LogInfo(ctx, entry.Message)
+		case events.LogWarning:
+			wailsruntime.LogWarning(ctx, entry.Message)
+		default:
+			wailsruntime.LogError(ctx, entry.Message)
+		}
+	})
+}
+
+func (a *App) emit(name events.Name, data interface{}) {
+	a.bus.Publish(name, data)
+}
+
+func (a *App) logDebugf(format string, args ...interface{}) {
+	a.bus.Logf(events.LogDebug, format, args...)
+}
+
+func (a *App) logInfo(message string) {
+	a.bus.Publish(events.Log, events.LogEntry{Level: events.LogInfo, Message: message})
+}
+
+func (a *App) logInfof(format string, args ...interface{}) {
+	a.bus.Logf(events.LogInfo, format, args...)
+}
+
+func (a *App) logWarningf(format string, args ...interface{}) {
+	a.bus.Logf(events.LogWarning, format, args...)
+}
+
+func (a *App) logErrorf(format string, args ...interface{}) {
+	a.bus.Logf(events.LogError, format, args...)
+}
@@ -36,8 +36,12 @@ import {
     // --- NEW: Artifacts Imports ---
     AddArtifact,
     ListArtifacts,
-    DeleteArtifact
+    DeleteArtifact,
     // --- END NEW Artifacts Imports ---
+    AttachImage,
+    TranscribeAudio,
+    FetchLlamaCppReleases,
+    DownloadLlamaCppAsset
 } from '../wailsjs/go/main/App';
 import {
     EventsOn
@@ -46,12 +50,15 @@ import * as runtime from '../wailsjs/runtime';
 import { getModelName } from './modules/path-utils.js';
 
 let currentSessionId = localStorage.getItem('currentSessionId') ? parseInt(localStorage.getItem('currentSessionId'), 10) : null; // Ensure this is accessible globally and parsed as int
+let pendingAttachmentIds = []; // Uploaded images and text files sent with the next message
 
 
 // --- NEW: Artifact Type Constants (Mirroring Go) ---
 export const ArtifactType = {
     IMAGE: "IMAGE",
     VIDEO: "VIDEO",
+    AUDIO: "AUDIO",
+    DOCUMENT: "DOCUMENT",
     TOOL_NOTIFICATION: "TOOL_NOTIFICATION",
     MCP_MANAGER: "MCP_MANAGER",
     LOG_VIEW: "LOG_VIEW",
@@ -124,6 +131,33 @@ function renderArtifacts() {
                     // You might add a placeholder or message for video errors too
                 };
                 artifactItem.appendChild(video);
+            } else if (artifact.type === ArtifactType.AUDIO) {
+                const audio = document.createElement('audio');
+                audio.src = artifact.url;
+                audio.controls = true;
+                audio.classList.add('artifact-thumbnail');
+                artifactItem.appendChild(audio);
+            } else if (artifact.type === ArtifactType.DOCUMENT) {
+                const link = document.createElement('a');
+                link.href = artifact.url;
+                link.target = '_blank';
+                link.textContent = 'Open document';
+                artifactItem.appendChild(link);
+            }
+            if (artifact.metadata && artifact.metadata.source_tool) {
+                const sourceElement = document.createElement('p');
+                sourceElement.innerHTML = `<strong>From tool:</strong> ${artifact.metadata.source_tool}`;
+                if (artifact.tool_call_id) {
+                    sourceElement.title = `Tool call ${artifact.tool_call_id}`;
+                }
+                sourceElement.style.fontSize = '0.8em';
+                artifactItem.appendChild(sourceElement);
+            }
+            if (artifact.message_id) {
+                const messageElement = document.createElement('p');
+                messageElement.innerHTML = `<strong>Message:</strong> #${artifact.message_id}`;
+                messageElement.style.fontSize = '0.8em';
+                artifactItem.appendChild(messageElement);
             }
         } else if (artifact.type === ArtifactType.TOOL_NOTIFICATION && artifact.metadata && artifact.metadata.message) {
             const messageElement = document.createElement('p');
@@ -156,7 +190,7 @@ function renderArtifacts() {
 
 // Function to handle artifact added event from Go
 function handleArtifactAdded(newArtifact) {
-    if (String(newArtifact.session_id) === String(currentSessionId)) {
+    if (newArtifact.session_id === currentSessionId) {
         if (!artifacts.some(a => a.id === newArtifact.id)) {
             artifacts.push(newArtifact);
             renderArtifacts();
@@ -181,7 +215,7 @@ function setupArtifactEventListeners() {
 async function loadArtifactsForCurrentSession() {
     if (currentSessionId) {
         try {
-            const fetchedArtifacts = await ListArtifacts(String(currentSessionId)); // Convert to string
+            const fetchedArtifacts = await ListArtifacts(currentSessionId, 0);
             artifacts = fetchedArtifacts || [];
             renderArtifacts();
         } catch (error) {
@@ -356,7 +390,10 @@ document.addEventListener('DOMContentLoaded', () => {
     function renderMessages() {
         chatWindow.innerHTML = '';
         messages.forEach(message => {
-            addMessageToChatWindow(message.role, message.content);
+            const messageElement = addMessageToChatWindow(message.role, message.content);
+            if (message.validationErrors && message.validationErrors.length > 0) {
+                addValidationNotice(messageElement, message.validationErrors);
+            }
         });
         if (typeof hljs !== 'undefined') {
             chatWindow.querySelectorAll('pre code').forEach((block) => {
@@ -404,6 +441,14 @@ document.addEventListener('DOMContentLoaded', () => {
         return messageElement;
     }
 
+    // addValidationNotice shows the schema violations found in a reply below it.
+    function addValidationNotice(messageElement, errors) {
+        const validationNotice = document.createElement('div');
+        validationNotice.classList.add('validation-errors');
+        validationNotice.textContent = `Schema validation failed: ${errors.join('; ')}`;
+        messageElement.appendChild(validationNotice);
+    }
+
     function loadSessions() {
         LoadChatSessions().then(sessions => {
             chatSessionList.innerHTML = '';
@@ -485,7 +530,10 @@ document.addEventListener('DOMContentLoaded', () => {
             if (history) {
                 messages = history.map(m => ({
                     role: m.role,
-                    content: m.content
+                    content: m.attachments && m.attachments.length > 0
+                        ? `${m.content}\n\n*Attached: ${m.attachments.map(a => a.name + (a.truncated ? ' (truncated)' : '')).join(', ')}*`
+                        : m.content,
+                    validationErrors: m.validation_errors || []
                 }));
                 console.log("DEBUG: Mapped messages:", messages);
             } else {
@@ -561,12 +609,18 @@ document.addEventListener('DOMContentLoaded', () => {
         messages.push({ role: 'assistant', content: '' });
         addMessageToChatWindow('assistant', ''); // Create the bubble upfront
 
-        sendMessage(currentSessionId, contentToSend).catch(error => {
+        // The tool mode applies to this message only.
+        const toolModeSelect = document.getElementById('toolModeSelect');
+        const toolMode = toolModeSelect.value;
+        toolModeSelect.value = 'auto';
+        const attachments = pendingAttachmentIds;
+        pendingAttachmentIds = [];
+        sendMessage(currentSessionId, contentToSend, { tool_mode: toolMode, attachments: attachments }).catch(error => {
             console.error("Error sending message:", error);
             messages.pop();
             messages.push({
                 role: 'error',
-                content: 'Failed to send message.'
+                content: `Failed to send message: ${error.message || error}`
             });
             renderMessages();
             isStreaming = false;
@@ -644,6 +698,19 @@ document.addEventListener('DOMContentLoaded', () => {
         }, DEBOUNCE_DELAY_MS);
     });
 
+    EventsOn("output-validation", (data) => {
+        if (data.valid) {
+            return;
+        }
+        const lastMessageBubble = document.querySelector('.message.assistant:last-child');
+        if (lastMessageBubble) {
+            addValidationNotice(lastMessageBubble, data.errors);
+        }
+        if (messages.length > 0) {
+            messages[messages.length - 1].validationErrors = data.errors;
+        }
+    });
+
     EventsOn("sessionNameUpdated", (data) => {
         const { sessionID, newName } = data;
         const sessionButton = document.querySelector(`#chatSessionList button[data-session-id='${sessionID}']`);
@@ -668,6 +735,33 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     });
 
+    // With tts_stream on, each sentence of a reply arrives as audio while the
+    // reply streams; play them one after another.
+    const speechQueue = [];
+    let speechPlaying = false;
+    function playNextSpeech() {
+        const next = speechQueue.shift();
+        if (!next) {
+            speechPlaying = false;
+            return;
+        }
+        speechPlaying = true;
+        const audio = new Audio(`data:${next.mime_type};base64,${next.audio}`);
+        audio.onended = playNextSpeech;
+        audio.onerror = playNextSpeech;
+        audio.play().catch(playNextSpeech);
+    }
+
+    EventsOn("tts-audio", (data) => {
+        if (data.session_id !== currentSessionId) {
+            return;
+        }
+        speechQueue.push(data);
+        if (!speechPlaying) {
+            playNextSpeech();
+        }
+    });
+
     function updateThinkingProcess(messageElement, thought, append = false) {
         let detailsElement = messageElement.querySelector('.thought-block');
 
@@ -825,6 +919,11 @@ document.addEventListener('DOMContentLoaded', () => {
     }
     // --- END NEW: Upload Artifact Button and File Input ---
 
+    const recordButton = document.getElementById('recordButton');
+    if (recordButton) {
+        recordButton.addEventListener('click', toggleRecording);
+    }
+
 
     if (settingsToggleButton && rightSidebar) {
         settingsToggleButton.addEventListener('click', () => {
@@ -844,7 +943,7 @@ document.addEventListener('DOMContentLoaded', () => {
     if (toggleLogViewButton) {
         toggleLogViewButton.addEventListener('click', () => {
             if (currentSessionId) {
-                AddArtifact(String(currentSessionId), ArtifactType.LOG_VIEW, "LLM Server Log", "");
+                AddArtifact(currentSessionId, ArtifactType.LOG_VIEW, "LLM Server Log", "");
             } else {
                 addMessageToChatWindow('system', 'Please select a session before enabling the log view.');
             }
@@ -952,12 +1051,24 @@ async function handleFileUpload(event) {
             artifactType = ArtifactType.IMAGE;
         } else if (file.type.startsWith('video/')) {
             artifactType = ArtifactType.VIDEO;
+        } else if (file.type.startsWith('audio/')) {
+            artifactType = ArtifactType.AUDIO;
+        } else {
+            artifactType = ArtifactType.DOCUMENT; // Text and code files are inlined into the next message
         }
-        // You can add more type checks here if needed
 
         if (currentSessionId !== null) { // Ensure a session is active
             try {
-                const artifact = await AddArtifact(String(currentSessionId), artifactType, file.name, base64Content);
+                if (artifactType === ArtifactType.IMAGE) {
+                    // Images are also sent to the model with the next message.
+                    const artifact = await AttachImage(currentSessionId, file.name, base64Content);
+                    pendingAttachmentIds.push(artifact.id);
+                } else if (artifactType === ArtifactType.DOCUMENT) {
+                    const artifact = await AddArtifact(currentSessionId, artifactType, file.name, base64Content);
+                    pendingAttachmentIds.push(artifact.id);
+                } else {
+                    await AddArtifact(currentSessionId, artifactType, file.name, base64Content);
+                }
                 // The 'artifactAdded' event from Go will trigger handleArtifactAdded()
             } catch (error) {
                 console.error("ERROR: Error uploading artifact via AddArtifact:", error);
@@ -977,6 +1088,58 @@ async function handleFileUpload(event) {
     reader.readAsDataURL(file); // Read the file as a data URL (base64)
 }
 
+let mediaRecorder = null;
+
+// toggleRecording records from the microphone until clicked again, then
+// transcribes the recording with whisper.cpp into the message input.
+async function toggleRecording() {
+    const recordButton = document.getElementById('recordButton');
+    if (mediaRecorder) {
+        mediaRecorder.stop();
+        return;
+    }
+    if (currentSessionId === null) {
+        addMessageToChatWindow('system', 'WARN: No current chat session. Please start a chat session before dictating.');
+        return;
+    }
+
+    let stream;
+    try {
+        stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+    } catch (error) {
+        addMessageToChatWindow('system', `ERROR: Cannot use the microphone: ${error.message || error}`);
+        return;
+    }
+    const chunks = [];
+    mediaRecorder = new MediaRecorder(stream);
+    mediaRecorder.ondataavailable = (e) => chunks.push(e.data);
+    mediaRecorder.onstop = () => {
+        stream.getTracks().forEach(track => track.stop());
+        mediaRecorder = null;
+        recordButton.textContent = '⏳';
+        recordButton.disabled = true;
+
+        const reader = new FileReader();
+        reader.onload = async (e) => {
+            const base64Content = e.target.result.split(',')[1];
+            try {
+                const text = await TranscribeAudio(currentSessionId, 'recording.webm', base64Content);
+                const messageInput = document.getElementById('messageInput');
+                messageInput.value = messageInput.value ? `${messageInput.value} ${text}` : text;
+                messageInput.focus();
+            } catch (error) {
+                addMessageToChatWindow('system', `ERROR: Transcription failed: ${error.message || error}`);
+            } finally {
+                recordButton.textContent = '🎤';
+                recordButton.disabled = false;
+            }
+        };
+        reader.readAsDataURL(new Blob(chunks, { type: 'audio/webm' }));
+    };
+    mediaRecorder.start();
+    recordButton.textContent = '⏹';
+}
+
 async function createMcpManagerArtifact() {
     if (currentSessionId === null) {
         addMessageToChatWindow('system', 'WARN: No current chat session. Please start a chat session before opening the MCP manager.');
@@ -991,7 +1154,7 @@ async function createMcpManagerArtifact() {
     }
 
     try {
-        await AddArtifact(String(currentSessionId), ArtifactType.MCP_MANAGER, "MCP Manager", "");
+        await AddArtifact(currentSessionId, ArtifactType.MCP_MANAGER, "MCP Manager", "");
     } catch (error) {
         console.error("ERROR: Error creating MCP manager artifact:", error);
         addMessageToChatWindow('system', `ERROR: Failed to create MCP manager artifact: ${error.message || error}`);
@@ -1011,7 +1174,7 @@ async function createLlamaUpdaterArtifact() {
     }
 
     try {
-        await AddArtifact(String(currentSessionId), ArtifactType.LLAMA_UPDATER, "Llama.cpp Updater", "");
+        await AddArtifact(currentSessionId, ArtifactType.LLAMA_UPDATER, "Llama.cpp Updater", "");
     } catch (error) {
         console.error("ERROR: Error creating Llama.cpp updater artifact:", error);
         addMessageToChatWindow('system', `ERROR: Failed to create Llama.cpp updater artifact: ${error.message || error}`);
@@ -1128,7 +1291,7 @@ async function handleFetchLlamaReleases() {
     contentDiv.innerHTML = '<p>Fetching releases...</p>';
 
     try {
-        const releases = await window.go.main.App.FetchLlamaCppReleases();
+        const releases = await FetchLlamaCppReleases();
         if (!releases || releases.length === 0) {
             contentDiv.innerHTML = '<p>No recent llama.cpp releases found.</p>';
             return;
@@ -1171,7 +1334,7 @@ function handleDownloadLlamaRelease(assetUrl, assetName, tagName, button) {
     `;
     button.parentElement.appendChild(progress);
 
-    window.go.main.App.DownloadLlamaCppAsset(assetUrl, assetName, tagName);
+    DownloadLlamaCppAsset(assetUrl, assetName, tagName);
 }
 
 function setupLlamaDownloadListeners() {
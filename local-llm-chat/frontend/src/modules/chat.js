@@ -1,7 +1,11 @@
 import {
-    HandleChat
+    HandleChat,
+    HandleChatWithOptions
 } from '../../wailsjs/go/main/App';
 
-export function sendMessage(sessionId, message) {
+export function sendMessage(sessionId, message, options) {
+    if (options) {
+        return HandleChatWithOptions(sessionId, message, options);
+    }
     return HandleChat(sessionId, message);
 }
@@ -3,16 +3,40 @@
 import {artifacts} from '../models';
 import {main} from '../models';
 
-export function AddArtifact(arg1:string,arg2:artifacts.ArtifactType,arg3:string,arg4:string):Promise<artifacts.Artifact>;
+export function AddArtifact(arg1:number,arg2:artifacts.ArtifactType,arg3:string,arg4:string):Promise<artifacts.Artifact>;
+
+export function AddToCollection(arg1:number,arg2:string):Promise<number>;
+
+export function AttachCollection(arg1:number,arg2:number):Promise<void>;
+
+export function AttachImage(arg1:number,arg2:string,arg3:string):Promise<artifacts.Artifact>;
+
+export function AttachToChat(arg1:number,arg2:string):Promise<number>;
 
 export function ConnectMcpClient(arg1:string,arg2:string,arg3:Array<string>):Promise<void>;
 
+export function CreateCollection(arg1:string):Promise<number>;
+
 export function DeleteArtifact(arg1:string):Promise<void>;
 
+export function DeleteChatMessage(arg1:number,arg2:number):Promise<void>;
+
 export function DeleteChatSession(arg1:number):Promise<void>;
 
+export function DeleteCollection(arg1:number):Promise<void>;
+
+export function DetachCollection(arg1:number,arg2:number):Promise<void>;
+
 export function DisconnectMcpClient(arg1:string):Promise<void>;
 
+export function DownloadLlamaCppAsset(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function FetchLlamaCppReleases():Promise<Array<main.GitHubRelease>>;
+
+export function GetAgentTraces(arg1:number):Promise<Array<main.AgentTrace>>;
+
+export function GetChatCollections(arg1:number):Promise<Array<main.RAGCollection>>;
+
 export function GetMcpServers():Promise<string>;
 
 export function GetModels():Promise<Array<string>>;
@@ -21,17 +45,35 @@ export function GetPrompt(arg1:string):Promise<string>;
 
 export function GetPrompts():Promise<Array<string>>;
 
+export function GetSessionOutputConstraint(arg1:number):Promise<string>;
+
+export function GetSessionSampling(arg1:number):Promise<string>;
+
+export function GetWhisperModels():Promise<Array<string>>;
+
 export function HandleChat(arg1:number,arg2:string):Promise<void>;
 
+export function HandleChatWithOptions(arg1:number,arg2:string,arg3:main.ChatOptions):Promise<void>;
+
 export function HealthCheck():Promise<string>;
 
+export function IsEmbeddingServerLoaded():Promise<boolean>;
+
 export function IsLLMLoaded():Promise<boolean>;
 
-export function LaunchLLM(arg1:string):Promise<string>;
+export function IsWhisperServerLoaded():Promise<boolean>;
+
+export function LaunchEmbeddingServer(arg1:string,arg2:string):Promise<string>;
+
+export function LaunchLLM(arg1:string,arg2:string):Promise<string>;
 
-export function ListArtifacts(arg1:string):Promise<Array<artifacts.Artifact>>;
+export function LaunchWhisperServer(arg1:string):Promise<string>;
 
-export function LoadChatHistory(arg1:number):Promise<Array<main.ChatMessage>>;
+export function ListArtifacts(arg1:number,arg2:number):Promise<Array<artifacts.Artifact>>;
+
+export function ListCollections():Promise<Array<main.RAGCollection>>;
+
+export function LoadChatHistory(arg1:number):Promise<Array<main.ChatHistoryMessage>>;
 
 export function LoadChatSessions():Promise<Array<main.ChatSession>>;
 
@@ -39,12 +81,30 @@ export function LoadSettings():Promise<string>;
 
 export function NewChat(arg1:string):Promise<number>;
 
+export function RemoveFromCollection(arg1:number,arg2:string):Promise<void>;
+
 export function SaveSettings(arg1:string):Promise<void>;
 
+export function SearchCollection(arg1:number,arg2:string,arg3:number):Promise<Array<main.Citation>>;
+
+export function ShutdownEmbeddingServer():Promise<void>;
+
 export function ShutdownLLM():Promise<void>;
 
+export function ShutdownWhisperServer():Promise<void>;
+
 export function SpawnMcpServer(arg1:string,arg2:string,arg3:Array<string>,arg4:Record<string, string>):Promise<string>;
 
+export function SpeakMessage(arg1:number,arg2:number):Promise<Array<artifacts.Artifact>>;
+
 export function StopStream(arg1:number):Promise<void>;
 
+export function SyncCollections():Promise<Array<main.RAGSyncResult>>;
+
+export function TranscribeAudio(arg1:number,arg2:string,arg3:string):Promise<string>;
+
 export function UpdateChatSystemPrompt(arg1:number,arg2:string):Promise<void>;
+
+export function UpdateSessionOutputConstraint(arg1:number,arg2:string):Promise<void>;
+
+export function UpdateSessionSampling(arg1:number,arg2:string):Promise<void>;
@@ -6,22 +6,70 @@ export function AddArtifact(arg1, arg2, arg3, arg4) {
   return window['go']['main']['App']['AddArtifact'](arg1, arg2, arg3, arg4);
 }
 
+export function AddToCollection(arg1, arg2) {
+  return window['go']['main']['App']['AddToCollection'](arg1, arg2);
+}
+
+export function AttachCollection(arg1, arg2) {
+  return window['go']['main']['App']['AttachCollection'](arg1, arg2);
+}
+
+export function AttachImage(arg1, arg2, arg3) {
+  return window['go']['main']['App']['AttachImage'](arg1, arg2, arg3);
+}
+
+export function AttachToChat(arg1, arg2) {
+  return window['go']['main']['App']['AttachToChat'](arg1, arg2);
+}
+
 export function ConnectMcpClient(arg1, arg2, arg3) {
   return window['go']['main']['App']['ConnectMcpClient'](arg1, arg2, arg3);
 }
 
+export function CreateCollection(arg1) {
+  return window['go']['main']['App']['CreateCollection'](arg1);
+}
+
 export function DeleteArtifact(arg1) {
   return window['go']['main']['App']['DeleteArtifact'](arg1);
 }
 
+export function DeleteChatMessage(arg1, arg2) {
+  return window['go']['main']['App']['DeleteChatMessage'](arg1, arg2);
+}
+
 export function DeleteChatSession(arg1) {
   return window['go']['main']['App']['DeleteChatSession'](arg1);
 }
 
+export function DeleteCollection(arg1) {
+  return window['go']['main']['App']['DeleteCollection'](arg1);
+}
+
+export function DetachCollection(arg1, arg2) {
+  return window['go']['main']['App']['DetachCollection'](arg1, arg2);
+}
+
 export function DisconnectMcpClient(arg1) {
   return window['go']['main']['App']['DisconnectMcpClient'](arg1);
 }
 
+export function DownloadLlamaCppAsset(arg1, arg2, arg3) {
+  return window['go']['main']['App']['DownloadLlamaCppAsset'](arg1, arg2, arg3);
+}
+
+export function FetchLlamaCppReleases() {
+  return window['go']['main']['App']['FetchLlamaCppReleases']();
+}
+
+export function GetAgentTraces(arg1) {
+  return window['go']['main']['App']['GetAgentTraces'](arg1);
+}
+
+export function GetChatCollections(arg1) {
+  return window['go']['main']['App']['GetChatCollections'](arg1);
+}
+
 export function GetMcpServers() {
   return window['go']['main']['App']['GetMcpServers']();
 }
@@ -38,24 +86,60 @@ export function GetPrompts() {
   return window['go']['main']['App']['GetPrompts']();
 }
 
+export function GetSessionOutputConstraint(arg1) {
+  return window['go']['main']['App']['GetSessionOutputConstraint'](arg1);
+}
+
+export function GetSessionSampling(arg1) {
+  return window['go']['main']['App']['GetSessionSampling'](arg1);
+}
+
+export function GetWhisperModels() {
+  return window['go']['main']['App']['GetWhisperModels']();
+}
+
 export function HandleChat(arg1, arg2) {
   return window['go']['main']['App']['HandleChat'](arg1, arg2);
 }
 
+export function HandleChatWithOptions(arg1, arg2, arg3) {
+  return window['go']['main']['App']['HandleChatWithOptions'](arg1, arg2, arg3);
+}
+
 export function HealthCheck() {
   return window['go']['main']['App']['HealthCheck']();
 }
 
+export function IsEmbeddingServerLoaded() {
+  return window['go']['main']['App']['IsEmbeddingServerLoaded']();
+}
+
 export function IsLLMLoaded() {
   return window['go']['main']['App']['IsLLMLoaded']();
 }
 
-export function LaunchLLM(arg1) {
-  return window['go']['main']['App']['LaunchLLM'](arg1);
+export function IsWhisperServerLoaded() {
+  return window['go']['main']['App']['IsWhisperServerLoaded']();
+}
+
+export function LaunchEmbeddingServer(arg1, arg2) {
+  return window['go']['main']['App']['LaunchEmbeddingServer'](arg1, arg2);
+}
+
+export function LaunchLLM(arg1, arg2) {
+  return window['go']['main']['App']['LaunchLLM'](arg1, arg2);
 }
 
-export function ListArtifacts(arg1) {
-  return window['go']['main']['App']['ListArtifacts'](arg1);
+export function LaunchWhisperServer(arg1) {
+  return window['go']['main']['App']['LaunchWhisperServer'](arg1);
+}
+
+export function ListArtifacts(arg1, arg2) {
+  return window['go']['main']['App']['ListArtifacts'](arg1, arg2);
+}
+
+export function ListCollections() {
+  return window['go']['main']['App']['ListCollections']();
 }
 
 export function LoadChatHistory(arg1) {
@@ -74,22 +158,58 @@ export function NewChat(arg1) {
   return window['go']['main']['App']['NewChat'](arg1);
 }
 
+export function RemoveFromCollection(arg1, arg2) {
+  return window['go']['main']['App']['RemoveFromCollection'](arg1, arg2);
+}
+
 export function SaveSettings(arg1) {
   return window['go']['main']['App']['SaveSettings'](arg1);
 }
 
+export function SearchCollection(arg1, arg2, arg3) {
+  return window['go']['main']['App']['SearchCollection'](arg1, arg2, arg3);
+}
+
+export function ShutdownEmbeddingServer() {
+  return window['go']['main']['App']['ShutdownEmbeddingServer']();
+}
+
 export function ShutdownLLM() {
   return window['go']['main']['App']['ShutdownLLM']();
 }
 
+export function ShutdownWhisperServer() {
+  return window['go']['main']['App']['ShutdownWhisperServer']();
+}
+
 export function SpawnMcpServer(arg1, arg2, arg3, arg4) {
   return window['go']['main']['App']['SpawnMcpServer'](arg1, arg2, arg3, arg4);
 }
 
+export function SpeakMessage(arg1, arg2) {
+  return window['go']['main']['App']['SpeakMessage'](arg1, arg2);
+}
+
 export function StopStream(arg1) {
   return window['go']['main']['App']['StopStream'](arg1);
 }
 
+export function SyncCollections() {
+  return window['go']['main']['App']['SyncCollections']();
+}
+
+export function TranscribeAudio(arg1, arg2, arg3) {
+  return window['go']['main']['App']['TranscribeAudio'](arg1, arg2, arg3);
+}
+
 export function UpdateChatSystemPrompt(arg1, arg2) {
   return window['go']['main']['App']['UpdateChatSystemPrompt'](arg1, arg2);
 }
+
+export function UpdateSessionOutputConstraint(arg1, arg2) {
+  return window['go']['main']['App']['UpdateSessionOutputConstraint'](arg1, arg2);
+}
+
+export function UpdateSessionSampling(arg1, arg2) {
+  return window['go']['main']['App']['UpdateSessionSampling'](arg1, arg2);
+}
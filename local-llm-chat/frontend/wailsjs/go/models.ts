@@ -2,13 +2,14 @@ export namespace artifacts {
 	
 	export class Artifact {
 	    id: string;
-	    session_id: string;
+	    session_id: number;
+	    message_id?: number;
+	    tool_call_id?: string;
 	    type: string;
 	    content_path: string;
 	    url: string;
 	    metadata: Record<string, any>;
-	    // Go type: time
-	    timestamp: any;
+	    timestamp: string;
 	    is_persistent: boolean;
 	
 	    static createFrom(source: any = {}) {
@@ -19,13 +20,74 @@ export namespace artifacts {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.id = source["id"];
 	        this.session_id = source["session_id"];
+	        this.message_id = source["message_id"];
+	        this.tool_call_id = source["tool_call_id"];
 	        this.type = source["type"];
 	        this.content_path = source["content_path"];
 	        this.url = source["url"];
 	        this.metadata = source["metadata"];
-	        this.timestamp = this.convertValues(source["timestamp"], null);
+	        this.timestamp = source["timestamp"];
 	        this.is_persistent = source["is_persistent"];
 	    }
+	}
+
+}
+
+export namespace main {
+	
+	export class TraceEvent {
+	    id: number;
+	    seq: number;
+	    kind: string;
+	    name: string;
+	    started_at: string;
+	    duration_ms: number;
+	    prompt_tokens?: number;
+	    completion_tokens?: number;
+	    details?: number[];
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new TraceEvent(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.seq = source["seq"];
+	        this.kind = source["kind"];
+	        this.name = source["name"];
+	        this.started_at = source["started_at"];
+	        this.duration_ms = source["duration_ms"];
+	        this.prompt_tokens = source["prompt_tokens"];
+	        this.completion_tokens = source["completion_tokens"];
+	        this.details = source["details"];
+	        this.error = source["error"];
+	    }
+	}
+	export class AgentTrace {
+	    id: number;
+	    session_id: number;
+	    message_id: number;
+	    started_at: string;
+	    finished_at?: string;
+	    outcome?: string;
+	    events: TraceEvent[];
+	
+	    static createFrom(source: any = {}) {
+	        return new AgentTrace(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.session_id = source["session_id"];
+	        this.message_id = source["message_id"];
+	        this.started_at = source["started_at"];
+	        this.finished_at = source["finished_at"];
+	        this.outcome = source["outcome"];
+	        this.events = this.convertValues(source["events"], TraceEvent);
+	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
 		    if (!a) {
@@ -45,29 +107,264 @@ export namespace artifacts {
 		    return a;
 		}
 	}
-
-}
-
-export namespace main {
+	export class Asset {
+	    name: string;
+	    browser_download_url: string;
+	    size: number;
+	    human_size: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Asset(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.browser_download_url = source["browser_download_url"];
+	        this.size = source["size"];
+	        this.human_size = source["human_size"];
+	    }
+	}
+	export class MessageAttachment {
+	    id: number;
+	    message_id: number;
+	    kind: string;
+	    artifact_id?: string;
+	    name: string;
+	    mime_type: string;
+	    path: string;
+	    source?: string;
+	    tokens?: number;
+	    truncated?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new MessageAttachment(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.message_id = source["message_id"];
+	        this.kind = source["kind"];
+	        this.artifact_id = source["artifact_id"];
+	        this.name = source["name"];
+	        this.mime_type = source["mime_type"];
+	        this.path = source["path"];
+	        this.source = source["source"];
+	        this.tokens = source["tokens"];
+	        this.truncated = source["truncated"];
+	    }
+	}
+	export class RetrievalScores {
+	    vector: number;
+	    vector_rank: number;
+	    bm25: number;
+	    bm25_rank: number;
+	    bm25_source: string;
+	    fused: number;
+	    rerank?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new RetrievalScores(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.vector = source["vector"];
+	        this.vector_rank = source["vector_rank"];
+	        this.bm25 = source["bm25"];
+	        this.bm25_rank = source["bm25_rank"];
+	        this.bm25_source = source["bm25_source"];
+	        this.fused = source["fused"];
+	        this.rerank = source["rerank"];
+	    }
+	}
+	export class Citation {
+	    index: number;
+	    chunk_id: number;
+	    collection: string;
+	    path: string;
+	    seq: number;
+	    score: number;
+	    text: string;
+	    scores?: RetrievalScores;
+	
+	    static createFrom(source: any = {}) {
+	        return new Citation(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.index = source["index"];
+	        this.chunk_id = source["chunk_id"];
+	        this.collection = source["collection"];
+	        this.path = source["path"];
+	        this.seq = source["seq"];
+	        this.score = source["score"];
+	        this.text = source["text"];
+	        this.scores = this.convertValues(source["scores"], RetrievalScores);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class RouterDecision {
+	    needs_tools: boolean;
+	    tools?: string[];
+	    reason?: string;
+	    mode: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RouterDecision(source);
+	    }
 	
-	export class ChatMessage {
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.needs_tools = source["needs_tools"];
+	        this.tools = source["tools"];
+	        this.reason = source["reason"];
+	        this.mode = source["mode"];
+	    }
+	}
+	export class SamplingSettings {
+	    temperature?: number;
+	    top_p?: number;
+	    top_k?: number;
+	    min_p?: number;
+	    repeat_penalty?: number;
+	    seed?: number;
+	    stop?: string[];
+	    max_tokens?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SamplingSettings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.temperature = source["temperature"];
+	        this.top_p = source["top_p"];
+	        this.top_k = source["top_k"];
+	        this.min_p = source["min_p"];
+	        this.repeat_penalty = source["repeat_penalty"];
+	        this.seed = source["seed"];
+	        this.stop = source["stop"];
+	        this.max_tokens = source["max_tokens"];
+	    }
+	}
+	export class ChatHistoryMessage {
 	    role: string;
 	    content: string;
+	    id: number;
+	    sampling?: SamplingSettings;
+	    validation_errors?: string[];
+	    router_decision?: RouterDecision;
+	    citations?: Citation[];
+	    attachments?: MessageAttachment[];
 	
 	    static createFrom(source: any = {}) {
-	        return new ChatMessage(source);
+	        return new ChatHistoryMessage(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.role = source["role"];
 	        this.content = source["content"];
+	        this.id = source["id"];
+	        this.sampling = this.convertValues(source["sampling"], SamplingSettings);
+	        this.validation_errors = source["validation_errors"];
+	        this.router_decision = this.convertValues(source["router_decision"], RouterDecision);
+	        this.citations = this.convertValues(source["citations"], Citation);
+	        this.attachments = this.convertValues(source["attachments"], MessageAttachment);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class OutputConstraint {
+	    json_schema?: number[];
+	    grammar?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new OutputConstraint(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.json_schema = source["json_schema"];
+	        this.grammar = source["grammar"];
 	    }
 	}
+	export class ChatOptions {
+	    output_constraint?: OutputConstraint;
+	    tool_mode?: string;
+	    attachments?: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ChatOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.output_constraint = this.convertValues(source["output_constraint"], OutputConstraint);
+	        this.tool_mode = source["tool_mode"];
+	        this.attachments = source["attachments"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class ChatSession {
 	    id: number;
 	    name: string;
 	    system_prompt: string;
+	    sampling?: SamplingSettings;
+	    output_constraint?: OutputConstraint;
 	    created_at: string;
 	
 	    static createFrom(source: any = {}) {
@@ -79,9 +376,115 @@ export namespace main {
 	        this.id = source["id"];
 	        this.name = source["name"];
 	        this.system_prompt = source["system_prompt"];
+	        this.sampling = this.convertValues(source["sampling"], SamplingSettings);
+	        this.output_constraint = this.convertValues(source["output_constraint"], OutputConstraint);
+	        this.created_at = source["created_at"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	export class GitHubRelease {
+	    tag_name: string;
+	    name: string;
+	    assets: Asset[];
+	
+	    static createFrom(source: any = {}) {
+	        return new GitHubRelease(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.tag_name = source["tag_name"];
+	        this.name = source["name"];
+	        this.assets = this.convertValues(source["assets"], Asset);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	
+	export class RAGCollection {
+	    id: number;
+	    name: string;
+	    session_id?: number;
+	    documents: number;
+	    chunks: number;
+	    created_at: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RAGCollection(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.session_id = source["session_id"];
+	        this.documents = source["documents"];
+	        this.chunks = source["chunks"];
 	        this.created_at = source["created_at"];
 	    }
 	}
+	export class RAGSyncResult {
+	    collection_id: number;
+	    source: string;
+	    indexed: number;
+	    unchanged: number;
+	    removed: number;
+	    failed: number;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RAGSyncResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.collection_id = source["collection_id"];
+	        this.source = source["source"];
+	        this.indexed = source["indexed"];
+	        this.unchanged = source["unchanged"];
+	        this.removed = source["removed"];
+	        this.failed = source["failed"];
+	        this.error = source["error"];
+	    }
+	}
+	
+	
+	
 
 }
 
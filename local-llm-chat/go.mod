@@ -6,11 +6,13 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/mark3labs/mcp-go v0.34.0
 	github.com/mattn/go-sqlite3 v1.14.28
+	github.com/pkoukk/tiktoken-go v0.1.8
 	github.com/wailsapp/wails/v2 v2.10.2
 )
 
 require (
 	github.com/bep/debounce v1.2.1 // indirect
+	github.com/dlclark/regexp2 v1.11.0 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
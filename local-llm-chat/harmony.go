@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Special tokens of the Harmony response format used by gpt-oss models.
+const (
+	harmonyStart     = "<|start|>"
+	harmonyEnd       = "<|end|>"
+	harmonyMessage   = "<|message|>"
+	harmonyChannel   = "<|channel|>"
+	harmonyConstrain = "<|constrain|>"
+	harmonyCall      = "<|call|>"
+	harmonyReturn    = "<|return|>"
+)
+
+// Harmony channels.
+const (
+	HarmonyChannelAnalysis   = "analysis"
+	HarmonyChannelCommentary = "commentary"
+	HarmonyChannelFinal      = "final"
+)
+
+// harmonyFunctionsPrefix is the recipient namespace for function tool calls.
+const harmonyFunctionsPrefix = "functions."
+
+// HarmonyMessage is a single message of a Harmony transcript.
+type HarmonyMessage struct {
+	Role        string `json:"role"`
+	Channel     string `json:"channel,omitempty"`
+	Recipient   string `json:"recipient,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Content     string `json:"content"`
+	// Terminator is the token that ended the message (<|end|>, <|call|> or
+	// <|return|>). It is empty if the text ended mid-message.
+	Terminator string `json:"terminator,omitempty"`
+}
+
+// IsToolCall reports whether the message is addressed to a function tool.
+func (m HarmonyMessage) IsToolCall() bool {
+	return strings.HasPrefix(m.Recipient, harmonyFunctionsPrefix)
+}
+
+// IsReasoning reports whether the message belongs to the analysis channel.
+func (m HarmonyMessage) IsReasoning() bool {
+	return m.Channel == HarmonyChannelAnalysis
+}
+
+// IsAnswer reports whether the message is meant for the user: the final
+// channel, a commentary preamble that is not a tool call, or text without any
+// channel at all.
+func (m HarmonyMessage) IsAnswer() bool {
+	if m.IsToolCall() || m.IsReasoning() {
+		return false
+	}
+	return m.Channel == "" || m.Channel == HarmonyChannelFinal || m.Channel == HarmonyChannelCommentary
+}
+
+// ToolCall converts a commentary message addressed to functions.x into a tool call.
+func (m HarmonyMessage) ToolCall() (ToolCall, error) {
+	if !m.IsToolCall() {
+		return ToolCall{}, fmt.Errorf("harmony message is not a tool call (recipient %q)", m.Recipient)
+	}
+	toolCall := ToolCall{ToolName: strings.TrimPrefix(m.Recipient, harmonyFunctionsPrefix)}
+	args := strings.TrimSpace(m.Content)
+	if args == "" {
+		toolCall.Arguments = map[string]interface{}{}
+		return toolCall, nil
+	}
+	if err := json.Unmarshal([]byte(args), &toolCall.Arguments); err != nil {
+		return ToolCall{}, fmt.Errorf("invalid arguments for %s: %w", toolCall.ToolName, err)
+	}
+	return toolCall, nil
+}
+
+// containsHarmonyTokens reports whether text uses the Harmony format.
+func containsHarmonyTokens(text string) bool {
+	return strings.Contains(text, harmonyMessage) || strings.Contains(text, harmonyChannel) || strings.Contains(text, harmonyStart)
+}
+
+// ParseHarmony splits a Harmony-formatted completion into messages. Completions
+// usually omit the leading <|start|>assistant because it is part of the prompt,
+// so a missing role defaults to "assistant". Text outside of any header is
+// returned as a message without a channel.
+func ParseHarmony(text string) []HarmonyMessage {
+	var messages []HarmonyMessage
+	rest := text
+
+	for rest != "" {
+		// Skip an optional <|start|> token; whatever follows up to <|message|> is the header.
+		hasStart := strings.HasPrefix(rest, harmonyStart)
+		rest = strings.TrimPrefix(rest, harmonyStart)
+
+		headerEnd := strings.Index(rest, harmonyMessage)
+		nextStart := strings.Index(rest, harmonyStart)
+		if headerEnd < 0 || (nextStart >= 0 && nextStart < headerEnd) {
+			// No complete header here. After <|start|> or <|channel|> this is a
+			// header still being generated; otherwise it is plain content.
+			plain := rest
+			if nextStart >= 0 {
+				plain, rest = rest[:nextStart], rest[nextStart:]
+			} else {
+				rest = ""
+			}
+			if !hasStart && !strings.Contains(plain, harmonyChannel) && strings.TrimSpace(plain) != "" {
+				messages = append(messages, HarmonyMessage{Role: "assistant", Content: plain})
+			}
+			continue
+		}
+
+		msg := parseHarmonyHeader(rest[:headerEnd])
+		rest = rest[headerEnd+len(harmonyMessage):]
+
+		contentEnd, terminator := findHarmonyTerminator(rest)
+		if contentEnd < 0 {
+			msg.Content = rest
+			rest = ""
+		} else {
+			msg.Content = rest[:contentEnd]
+			msg.Terminator = terminator
+			rest = rest[contentEnd+len(terminator):]
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// parseHarmonyHeader parses the text between <|start|> and <|message|>, e.g.
+// "assistant<|channel|>commentary to=functions.get_weather <|constrain|>json".
+func parseHarmonyHeader(header string) HarmonyMessage {
+	msg := HarmonyMessage{}
+
+	if i := strings.Index(header, harmonyConstrain); i >= 0 {
+		msg.ContentType = strings.TrimSpace(header[i+len(harmonyConstrain):])
+		header = header[:i]
+	}
+
+	rolePart, channelPart := header, ""
+	if i := strings.Index(header, harmonyChannel); i >= 0 {
+		rolePart, channelPart = header[:i], header[i+len(harmonyChannel):]
+	}
+
+	for i, field := range strings.Fields(rolePart) {
+		if strings.HasPrefix(field, "to=") {
+			msg.Recipient = strings.TrimPrefix(field, "to=")
+		} else if i == 0 {
+			msg.Role = field
+		}
+	}
+	for i, field := range strings.Fields(channelPart) {
+		switch {
+		case strings.HasPrefix(field, "to="):
+			msg.Recipient = strings.TrimPrefix(field, "to=")
+		case i == 0:
+			msg.Channel = field
+		case msg.ContentType == "":
+			msg.ContentType = field
+		}
+	}
+
+	if msg.Role == "" {
+		msg.Role = "assistant"
+	}
+	return msg
+}
+
+// findHarmonyTerminator returns the position of the token that ends the current
+// message. A new <|start|> also ends it, but is not consumed.
+func findHarmonyTerminator(text string) (int, string) {
+	best, bestToken := -1, ""
+	for _, token := range []string{harmonyEnd, harmonyCall, harmonyReturn, harmonyStart} {
+		if i := strings.Index(text, token); i >= 0 && (best < 0 || i < best) {
+			best, bestToken = i, token
+		}
+	}
+	if bestToken == harmonyStart {
+		return best, ""
+	}
+	return best, bestToken
+}
+
+// RenderHarmony renders messages back into the Harmony format.
+func RenderHarmony(messages []HarmonyMessage) string {
+	var b strings.Builder
+	for _, msg := range messages {
+		role := msg.Role
+		if role == "" {
+			role = "assistant"
+		}
+		b.WriteString(harmonyStart)
+		b.WriteString(role)
+		if msg.Channel != "" {
+			b.WriteString(harmonyChannel)
+			b.WriteString(msg.Channel)
+		}
+		if msg.Recipient != "" {
+			b.WriteString(" to=")
+			b.WriteString(msg.Recipient)
+		}
+		if msg.ContentType != "" {
+			b.WriteString(" ")
+			b.WriteString(harmonyConstrain)
+			b.WriteString(msg.ContentType)
+		}
+		b.WriteString(harmonyMessage)
+		b.WriteString(msg.Content)
+		terminator := msg.Terminator
+		if terminator == "" {
+			terminator = harmonyEnd
+			if msg.IsToolCall() {
+				terminator = harmonyCall
+			}
+		}
+		b.WriteString(terminator)
+	}
+	return b.String()
+}
+
+// splitHarmony separates the reasoning and the user-facing answer of a parsed
+// transcript and collects any tool calls.
+func splitHarmony(messages []HarmonyMessage) (answer, reasoning string, toolCalls []HarmonyMessage) {
+	var answerParts, reasoningParts []string
+	for _, msg := range messages {
+		switch {
+		case msg.IsToolCall():
+			toolCalls = append(toolCalls, msg)
+		case msg.IsReasoning():
+			reasoningParts = append(reasoningParts, msg.Content)
+		case msg.IsAnswer():
+			answerParts = append(answerParts, msg.Content)
+		}
+	}
+	return strings.Join(answerParts, "\n"), strings.Join(reasoningParts, "\n"), toolCalls
+}
+
+// harmonyVisibleText returns the user-facing text of a completion. Text that
+// does not use the Harmony format is returned unchanged.
+func harmonyVisibleText(text string) string {
+	if !containsHarmonyTokens(text) {
+		return text
+	}
+	answer, _, _ := splitHarmony(ParseHarmony(text))
+	return strings.TrimSpace(answer)
+}
+
+// HarmonyStreamParser incrementally routes a streamed Harmony completion: the
+// analysis channel becomes reasoning, final and commentary preambles become
+// answer text, and tool calls are withheld. It splits the text the same way
+// ParseHarmony and splitHarmony do, but keeps its place between chunks, so
+// every byte is scanned about once however long the reply gets.
+type HarmonyStreamParser struct {
+	raw strings.Builder
+
+	pos        int            // Start of the message or plain text being parsed
+	scan       int            // Where the search for the next token resumes
+	inContent  bool           // The header of msg has been read; pos is the start of its content
+	msg        HarmonyMessage // Header of the message being read
+	emitted    int            // Bytes of the current content already returned
+	counted    bool           // The current content has been counted as an answer or reasoning part
+	sawChannel bool           // Plain text contains <|channel|>, so it is a header still being generated
+	nonBlank   bool           // Plain text contains more than white space
+
+	answers    int // Answer parts seen, joined by newlines
+	reasonings int // Reasoning parts seen, joined by newlines
+}
+
+// NewHarmonyStreamParser creates a parser for a streamed Harmony completion.
+func NewHarmonyStreamParser() *HarmonyStreamParser {
+	return &HarmonyStreamParser{}
+}
+
+// Feed consumes the next chunk and returns the newly available answer and reasoning text.
+func (p *HarmonyStreamParser) Feed(chunk string) (content, reasoning string) {
+	p.raw.WriteString(chunk)
+	return p.emit(trimPartialHarmonyToken(p.raw.String()))
+}
+
+// Flush returns the text still held back once the stream has ended.
+func (p *HarmonyStreamParser) Flush() (content, reasoning string) {
+	return p.emit(p.raw.String())
+}
+
+// harmonyTokenOverlap is how far a search backs up into text that was already
+// scanned, so that a token cut by the end of the previous text is found.
+const harmonyTokenOverlap = len(harmonyConstrain) - 1
+
+// emit parses the text from where the previous call stopped and returns the
+// answer and reasoning text that has not been returned yet. text must extend
+// the text of the previous call.
+func (p *HarmonyStreamParser) emit(text string) (content, reasoning string) {
+	var contentBuilder, reasoningBuilder strings.Builder
+	for p.pos < len(text) {
+		from := p.scan - harmonyTokenOverlap
+		if from < p.pos {
+			from = p.pos
+		}
+
+		if p.inContent {
+			end, terminator := findHarmonyTerminator(text[from:])
+			if end < 0 {
+				p.writeContent(&contentBuilder, &reasoningBuilder, text[p.pos:])
+				p.scan = len(text)
+				break
+			}
+			end += from
+			p.writeContent(&contentBuilder, &reasoningBuilder, text[p.pos:end])
+			p.nextMessage(end + len(terminator))
+			continue
+		}
+
+		// Between messages: an optional <|start|>, then a header up to
+		// <|message|>, or plain text.
+		hasStart := strings.HasPrefix(text[p.pos:], harmonyStart)
+		headerStart := p.pos
+		if hasStart {
+			headerStart += len(harmonyStart)
+		}
+		if from < headerStart {
+			from = headerStart
+		}
+		headerEnd := strings.Index(text[from:], harmonyMessage)
+		nextStart := strings.Index(text[from:], harmonyStart)
+		if strings.Contains(text[from:], harmonyChannel) {
+			p.sawChannel = true
+		}
+
+		if headerEnd >= 0 && (nextStart < 0 || nextStart > headerEnd) {
+			headerEnd += from
+			p.msg = parseHarmonyHeader(text[headerStart:headerEnd])
+			p.inContent = true
+			p.pos = headerEnd + len(harmonyMessage)
+			p.scan = p.pos
+			p.emitted, p.counted = 0, false
+			p.countPart(&contentBuilder, &reasoningBuilder)
+			continue
+		}
+
+		plainEnd := len(text)
+		if nextStart >= 0 {
+			plainEnd = nextStart + from
+		}
+		if !hasStart && !p.sawChannel {
+			if !p.nonBlank && strings.TrimSpace(text[from:plainEnd]) != "" {
+				p.nonBlank = true
+			}
+			if p.nonBlank {
+				p.msg = HarmonyMessage{Role: "assistant"}
+				p.countPart(&contentBuilder, &reasoningBuilder)
+				p.writeContent(&contentBuilder, &reasoningBuilder, text[p.pos:plainEnd])
+			}
+		}
+		if nextStart < 0 {
+			p.scan = len(text)
+			break
+		}
+		p.nextMessage(plainEnd)
+	}
+	return contentBuilder.String(), reasoningBuilder.String()
+}
+
+// countPart starts the current message's part of the answer or the reasoning,
+// separated from the previous part by a newline as in splitHarmony.
+func (p *HarmonyStreamParser) countPart(content, reasoning *strings.Builder) {
+	if p.counted {
+		return
+	}
+	switch {
+	case p.msg.IsToolCall():
+		return
+	case p.msg.IsReasoning():
+		if p.reasonings > 0 {
+			reasoning.WriteString("\n")
+		}
+		p.reasonings++
+	case p.msg.IsAnswer():
+		if p.answers > 0 {
+			content.WriteString("\n")
+		}
+		p.answers++
+	default:
+		return
+	}
+	p.counted = true
+}
+
+// writeContent returns the part of the current message's content that has not
+// been returned yet, if the message is part of the answer or the reasoning.
+func (p *HarmonyStreamParser) writeContent(content, reasoning *strings.Builder, text string) {
+	if p.counted && len(text) > p.emitted {
+		if p.msg.IsReasoning() {
+			reasoning.WriteString(text[p.emitted:])
+		} else {
+			content.WriteString(text[p.emitted:])
+		}
+	}
+	p.emitted = len(text)
+}
+
+// nextMessage moves on to the message or plain text starting at pos.
+func (p *HarmonyStreamParser) nextMessage(pos int) {
+	p.pos, p.scan = pos, pos
+	p.inContent = false
+	p.msg = HarmonyMessage{}
+	p.emitted, p.counted = 0, false
+	p.sawChannel, p.nonBlank = false, false
+}
+
+// trimPartialHarmonyToken cuts a special token that has only partly arrived,
+// so that "<|en" is not emitted as text before "d|>" completes it. Only the
+// end of the text, where such a token can be, is looked at.
+func trimPartialHarmonyToken(text string) string {
+	if strings.HasSuffix(text, "<") {
+		return text[:len(text)-1]
+	}
+	tail := len(text) - harmonyTokenOverlap
+	if tail < 0 {
+		tail = 0
+	}
+	if i := strings.LastIndex(text[tail:], "<|"); i >= 0 && !strings.Contains(text[tail+i:], "|>") {
+		return text[:tail+i]
+	}
+	return text
+}
+
+// streamContentParser splits streamed content into answer and reasoning text.
+type streamContentParser interface {
+	Feed(chunk string) (content, reasoning string)
+	Flush() (content, reasoning string)
+}
+
+// newStreamContentParser picks the stream parser for a model: the Harmony
+// parser for gpt-oss models, otherwise the inline reasoning tag parser.
+func (a *App) newStreamContentParser(modelPath string) streamContentParser {
+	settings, ok := a.config.ModelSettings[modelPath]
+	if ok && settings.UseHarmonyTools && len(settings.ReasoningTags) == 0 {
+		return NewHarmonyStreamParser()
+	}
+	return NewThinkTagParser(a.reasoningTagsForModel(modelPath))
+}
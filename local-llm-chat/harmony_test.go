@@ -0,0 +1,235 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Completions as gpt-oss models return them from llama-server: the leading
+// <|start|>assistant belongs to the prompt and is missing.
+const (
+	harmonyFinalCompletion = "<|channel|>analysis<|message|>The user asks for the capital of France. Simple.<|end|>" +
+		"<|start|>assistant<|channel|>final<|message|>The capital of France is Paris.<|return|>"
+
+	harmonyToolCallCompletion = "<|channel|>analysis<|message|>Need to use function get_weather.<|end|>" +
+		"<|start|>assistant<|channel|>commentary<|message|>Let me check the weather.<|end|>" +
+		"<|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{\"location\":\"San Francisco\"}<|call|>"
+)
+
+func TestParseHarmonyChannels(t *testing.T) {
+	got := ParseHarmony(harmonyFinalCompletion)
+	want := []HarmonyMessage{
+		{Role: "assistant", Channel: "analysis", Content: "The user asks for the capital of France. Simple.", Terminator: harmonyEnd},
+		{Role: "assistant", Channel: "final", Content: "The capital of France is Paris.", Terminator: harmonyReturn},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseHarmony =\n%+v\nwant\n%+v", got, want)
+	}
+	if !got[0].IsReasoning() || got[0].IsAnswer() {
+		t.Errorf("analysis message: IsReasoning %v, IsAnswer %v", got[0].IsReasoning(), got[0].IsAnswer())
+	}
+	if !got[1].IsAnswer() || got[1].IsToolCall() {
+		t.Errorf("final message: IsAnswer %v, IsToolCall %v", got[1].IsAnswer(), got[1].IsToolCall())
+	}
+}
+
+func TestParseHarmonyToolCall(t *testing.T) {
+	messages := ParseHarmony(harmonyToolCallCompletion)
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3: %+v", len(messages), messages)
+	}
+	preamble, call := messages[1], messages[2]
+	if preamble.Channel != HarmonyChannelCommentary || !preamble.IsAnswer() {
+		t.Errorf("preamble = %+v, want a commentary answer", preamble)
+	}
+	want := HarmonyMessage{
+		Role:        "assistant",
+		Channel:     HarmonyChannelCommentary,
+		Recipient:   "functions.get_weather",
+		ContentType: "json",
+		Content:     `{"location":"San Francisco"}`,
+		Terminator:  harmonyCall,
+	}
+	if call != want {
+		t.Fatalf("tool call message = %+v, want %+v", call, want)
+	}
+	toolCall, err := call.ToolCall()
+	if err != nil {
+		t.Fatalf("ToolCall: %v", err)
+	}
+	if toolCall.ToolName != "get_weather" || toolCall.Arguments["location"] != "San Francisco" {
+		t.Errorf("ToolCall = %+v", toolCall)
+	}
+}
+
+func TestParseHarmonyRecipientInRole(t *testing.T) {
+	// gpt-oss also puts the recipient before the channel, with the content
+	// type as a plain word.
+	messages := ParseHarmony("<|start|>assistant to=functions.lookup<|channel|>commentary json<|message|>{}<|call|>")
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.Recipient != "functions.lookup" || msg.Channel != HarmonyChannelCommentary || msg.ContentType != "json" {
+		t.Errorf("message = %+v", msg)
+	}
+	toolCall, err := msg.ToolCall()
+	if err != nil || toolCall.ToolName != "lookup" || len(toolCall.Arguments) != 0 {
+		t.Errorf("ToolCall = %+v, %v", toolCall, err)
+	}
+}
+
+func TestParseHarmonyInvalidToolArguments(t *testing.T) {
+	messages := ParseHarmony("<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{\"location\":<|call|>")
+	if _, err := messages[0].ToolCall(); err == nil {
+		t.Error("ToolCall with truncated arguments: got no error")
+	}
+}
+
+func TestParseHarmonyPlainText(t *testing.T) {
+	messages := ParseHarmony("Just an answer.")
+	want := []HarmonyMessage{{Role: "assistant", Content: "Just an answer."}}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("ParseHarmony = %+v, want %+v", messages, want)
+	}
+	if got := harmonyVisibleText("Just an answer."); got != "Just an answer." {
+		t.Errorf("harmonyVisibleText = %q", got)
+	}
+}
+
+func TestSplitHarmony(t *testing.T) {
+	answer, reasoning, toolCalls := splitHarmony(ParseHarmony(harmonyToolCallCompletion))
+	if answer != "Let me check the weather." {
+		t.Errorf("answer = %q", answer)
+	}
+	if reasoning != "Need to use function get_weather." {
+		t.Errorf("reasoning = %q", reasoning)
+	}
+	if len(toolCalls) != 1 || toolCalls[0].Recipient != "functions.get_weather" {
+		t.Errorf("toolCalls = %+v", toolCalls)
+	}
+
+	answer, reasoning, toolCalls = splitHarmony(ParseHarmony(harmonyFinalCompletion))
+	if answer != "The capital of France is Paris." || reasoning != "The user asks for the capital of France. Simple." || len(toolCalls) != 0 {
+		t.Errorf("splitHarmony = %q, %q, %+v", answer, reasoning, toolCalls)
+	}
+	if got := harmonyVisibleText(harmonyFinalCompletion); got != "The capital of France is Paris." {
+		t.Errorf("harmonyVisibleText = %q", got)
+	}
+}
+
+func TestRenderHarmonyRoundTrip(t *testing.T) {
+	transcript := harmonyToolCallCompletion +
+		"<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{\"sunny\":true,\"temperature\":20}<|end|>" +
+		"<|start|>assistant<|channel|>final<|message|>It is sunny and 20°C in San Francisco.<|return|>"
+	messages := ParseHarmony(transcript)
+	if len(messages) != 5 {
+		t.Fatalf("got %d messages, want 5: %+v", len(messages), messages)
+	}
+	if messages[3].Role != "functions.get_weather" || messages[3].Recipient != "assistant" {
+		t.Errorf("tool result message = %+v", messages[3])
+	}
+	if got := ParseHarmony(RenderHarmony(messages)); !reflect.DeepEqual(got, messages) {
+		t.Errorf("round trip =\n%+v\nwant\n%+v", got, messages)
+	}
+}
+
+func TestRenderHarmonyTerminators(t *testing.T) {
+	rendered := RenderHarmony([]HarmonyMessage{
+		{Channel: HarmonyChannelCommentary, Recipient: "functions.get_weather", ContentType: "json", Content: `{}`},
+		{Channel: HarmonyChannelFinal, Content: "Done."},
+	})
+	want := "<|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{}<|call|>" +
+		"<|start|>assistant<|channel|>final<|message|>Done.<|end|>"
+	if rendered != want {
+		t.Errorf("RenderHarmony =\n%s\nwant\n%s", rendered, want)
+	}
+}
+
+func TestHarmonyStreamParser(t *testing.T) {
+	chunkings := map[string][]string{
+		"whole": {harmonyToolCallCompletion},
+		// Cut inside <|channel|>, <|message|>, <|end|> and <|start|>.
+		"mid-token": {"<|chan", "nel|>analysis<|mess", "age|>Need to use ", "function get_weather.<|e", "nd|><", "|start|>assistant<|channel|>commentary<|message|>Let me check", " the weather.<|end|><|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{\"location\":", "\"San Francisco\"}<|ca", "ll|>"},
+		"bytes":     strings.Split(harmonyToolCallCompletion, ""),
+	}
+	for name, chunks := range chunkings {
+		t.Run(name, func(t *testing.T) {
+			parser := NewHarmonyStreamParser()
+			var content, reasoning strings.Builder
+			for _, chunk := range chunks {
+				c, r := parser.Feed(chunk)
+				if strings.Contains(c, "<|") || strings.Contains(r, "<|") {
+					t.Fatalf("after %q: leaked token in %q / %q", chunk, c, r)
+				}
+				content.WriteString(c)
+				reasoning.WriteString(r)
+			}
+			c, r := parser.Flush()
+			content.WriteString(c)
+			reasoning.WriteString(r)
+
+			if content.String() != "Let me check the weather." {
+				t.Errorf("content = %q", content.String())
+			}
+			if reasoning.String() != "Need to use function get_weather." {
+				t.Errorf("reasoning = %q", reasoning.String())
+			}
+		})
+	}
+}
+
+// TestHarmonyStreamParserMatchesParseHarmony feeds transcripts in chunks of
+// every size up to 16 bytes and checks that the streamed text adds up to what
+// splitHarmony makes of the whole transcript.
+func TestHarmonyStreamParserMatchesParseHarmony(t *testing.T) {
+	transcripts := []string{
+		harmonyToolCallCompletion,
+		"<|channel|>analysis<|message|>First.<|end|><|start|>assistant<|channel|>analysis<|message|>Second.<|end|>" +
+			"<|start|>assistant<|channel|>final<|message|>Done.<|return|>",
+		"<|channel|>final<|message|><|end|><|start|>assistant<|channel|>final<|message|>After an empty one.",
+		"Plain text without any tokens.",
+		"  <|start|>assistant<|channel|>final<|message|>Reply cut off at a new<|start|>assistant<|channel|>final<|message|>message",
+		"<|channel|>analysis<|message|>Unterminated reasoning",
+		"<|start|>assistant<|channel|>analysis",
+	}
+	for _, transcript := range transcripts {
+		wantContent, wantReasoning, _ := splitHarmony(ParseHarmony(transcript))
+		for size := 1; size <= 16; size++ {
+			parser := NewHarmonyStreamParser()
+			var content, reasoning strings.Builder
+			for start := 0; start < len(transcript); start += size {
+				end := start + size
+				if end > len(transcript) {
+					end = len(transcript)
+				}
+				c, r := parser.Feed(transcript[start:end])
+				content.WriteString(c)
+				reasoning.WriteString(r)
+			}
+			c, r := parser.Flush()
+			content.WriteString(c)
+			reasoning.WriteString(r)
+			if content.String() != wantContent || reasoning.String() != wantReasoning {
+				t.Errorf("%q in chunks of %d: got %q / %q, want %q / %q", transcript, size, content.String(), reasoning.String(), wantContent, wantReasoning)
+			}
+		}
+	}
+}
+
+func TestHarmonyStreamParserLongReasoningIsLinear(t *testing.T) {
+	// A long analysis message streamed in small chunks used to be re-parsed on
+	// every chunk; this would not finish in time if it still were.
+	parser := NewHarmonyStreamParser()
+	parser.Feed("<|channel|>analysis<|message|>")
+	total := 0
+	for i := 0; i < 200_000; i++ {
+		_, r := parser.Feed("thinking ")
+		total += len(r)
+	}
+	c, r := parser.Feed("<|end|><|start|>assistant<|channel|>final<|message|>Answer.")
+	if total+len(r) != 200_000*len("thinking ") || c != "Answer." {
+		t.Errorf("got %d reasoning bytes and content %q", total+len(r), c)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// validateJSONSchema checks a decoded JSON value against a JSON Schema and returns
+// a list of human-readable violations. It covers the subset of the spec that
+// llama.cpp can enforce while sampling (types, properties, required, enums,
+// bounds, patterns and combinators); $ref and format are not checked.
+func validateJSONSchema(schema map[string]interface{}, value interface{}) []string {
+	var errs []string
+	validateSchemaNode(schema, value, "$", &errs)
+	return errs
+}
+
+// validateJSONText parses text as JSON and validates it against a raw schema.
+func validateJSONText(rawSchema json.RawMessage, text string) []string {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(rawSchema, &schema); err != nil {
+		return []string{fmt.Sprintf("schema is not a valid JSON object: %v", err)}
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &value); err != nil {
+		return []string{fmt.Sprintf("response is not valid JSON: %v", err)}
+	}
+	return validateJSONSchema(schema, value)
+}
+
+func validateSchemaNode(schema map[string]interface{}, value interface{}, path string, errs *[]string) {
+	addErr := func(format string, args ...interface{}) {
+		*errs = append(*errs, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if types, ok := schemaTypes(schema["type"]); ok {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			addErr("expected type %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			addErr("value %s is not one of the allowed values", compactJSON(value))
+		}
+	}
+	if constValue, ok := schema["const"]; ok && !jsonEqual(constValue, value) {
+		addErr("value must be %s", compactJSON(constValue))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		validateObject(schema, v, path, errs)
+	case []interface{}:
+		if minItems, ok := schemaNumber(schema["minItems"]); ok && float64(len(v)) < minItems {
+			addErr("expected at least %v items, got %d", minItems, len(v))
+		}
+		if maxItems, ok := schemaNumber(schema["maxItems"]); ok && float64(len(v)) > maxItems {
+			addErr("expected at most %v items, got %d", maxItems, len(v))
+		}
+		if itemSchema, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateSchemaNode(itemSchema, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if minLength, ok := schemaNumber(schema["minLength"]); ok && length < minLength {
+			addErr("string shorter than %v characters", minLength)
+		}
+		if maxLength, ok := schemaNumber(schema["maxLength"]); ok && length > maxLength {
+			addErr("string longer than %v characters", maxLength)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				addErr("invalid pattern %q in schema: %v", pattern, err)
+			} else if !re.MatchString(v) {
+				addErr("string does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if minimum, ok := schemaNumber(schema["minimum"]); ok && v < minimum {
+			addErr("%v is less than the minimum %v", v, minimum)
+		}
+		if maximum, ok := schemaNumber(schema["maximum"]); ok && v > maximum {
+			addErr("%v is greater than the maximum %v", v, maximum)
+		}
+		if exclusiveMinimum, ok := schemaNumber(schema["exclusiveMinimum"]); ok && v <= exclusiveMinimum {
+			addErr("%v must be greater than %v", v, exclusiveMinimum)
+		}
+		if exclusiveMaximum, ok := schemaNumber(schema["exclusiveMaximum"]); ok && v >= exclusiveMaximum {
+			addErr("%v must be less than %v", v, exclusiveMaximum)
+		}
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				validateSchemaNode(subSchema, value, path, errs)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		if countMatchingSchemas(anyOf, value, path) == 0 {
+			addErr("value does not match any of the allowed schemas")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if matches := countMatchingSchemas(oneOf, value, path); matches != 1 {
+			addErr("value must match exactly one schema, matched %d", matches)
+		}
+	}
+}
+
+func validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, errs *[]string) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					*errs = append(*errs, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "." + key
+		if propSchema, ok := properties[key].(map[string]interface{}); ok {
+			validateSchemaNode(propSchema, obj[key], childPath, errs)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*errs = append(*errs, fmt.Sprintf("%s: unexpected property %q", path, key))
+			}
+		case map[string]interface{}:
+			validateSchemaNode(additional, obj[key], childPath, errs)
+		}
+	}
+}
+
+func countMatchingSchemas(schemas []interface{}, value interface{}, path string) int {
+	matches := 0
+	for _, sub := range schemas {
+		subSchema, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var subErrs []string
+		validateSchemaNode(subSchema, value, path, &subErrs)
+		if len(subErrs) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+func schemaTypes(raw interface{}) ([]string, bool) {
+	switch t := raw.(type) {
+	case string:
+		return []string{t}, true
+	case []interface{}:
+		var types []string
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types, len(types) > 0
+	}
+	return nil, false
+}
+
+func schemaNumber(raw interface{}) (float64, bool) {
+	n, ok := raw.(float64)
+	return n, ok
+}
+
+func jsonTypeMatches(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		errors []string // Substrings expected in the violations, in order; none means valid
+	}{
+		{
+			name:   "type union accepts either type",
+			schema: `{"type": ["string", "null"]}`,
+			value:  `null`,
+		},
+		{
+			name:   "type union rejects other types",
+			schema: `{"type": ["string", "null"]}`,
+			value:  `3`,
+			errors: []string{"$: expected type string or null, got number"},
+		},
+		{
+			name:   "integer accepts whole numbers",
+			schema: `{"type": "integer"}`,
+			value:  `4.0`,
+		},
+		{
+			name:   "integer rejects fractions",
+			schema: `{"type": "integer"}`,
+			value:  `4.5`,
+			errors: []string{"expected type integer, got number"},
+		},
+		{
+			name:   "number accepts fractions",
+			schema: `{"type": "number", "minimum": 1}`,
+			value:  `1.5`,
+		},
+		{
+			name:   "required and additionalProperties",
+			schema: `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a", "b"], "additionalProperties": false}`,
+			value:  `{"a": "x", "c": 1}`,
+			errors: []string{`$: missing required property "b"`, `$: unexpected property "c"`},
+		},
+		{
+			name:   "additionalProperties schema",
+			schema: `{"type": "object", "additionalProperties": {"type": "integer"}}`,
+			value:  `{"x": 1, "y": "two"}`,
+			errors: []string{"$.y: expected type integer, got string"},
+		},
+		{
+			name:   "nested paths",
+			schema: `{"type": "object", "properties": {"items": {"type": "array", "items": {"type": "string"}}}}`,
+			value:  `{"items": ["a", 2]}`,
+			errors: []string{"$.items[1]: expected type string, got number"},
+		},
+		{
+			name:   "anyOf matching one schema",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`,
+			value:  `7`,
+		},
+		{
+			name:   "anyOf matching none",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`,
+			value:  `true`,
+			errors: []string{"does not match any of the allowed schemas"},
+		},
+		{
+			name:   "oneOf matching exactly one",
+			schema: `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`,
+			value:  `"x"`,
+		},
+		{
+			name:   "oneOf matching two",
+			schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`,
+			value:  `3`,
+			errors: []string{"must match exactly one schema, matched 2"},
+		},
+		{
+			name:   "oneOf matching none",
+			schema: `{"oneOf": [{"type": "string"}, {"type": "boolean"}]}`,
+			value:  `3`,
+			errors: []string{"must match exactly one schema, matched 0"},
+		},
+		{
+			name:   "pattern match",
+			schema: `{"type": "string", "pattern": "^[a-z]+$"}`,
+			value:  `"abc"`,
+		},
+		{
+			name:   "pattern mismatch",
+			schema: `{"type": "string", "pattern": "^[a-z]+$"}`,
+			value:  `"ABC"`,
+			errors: []string{`string does not match pattern "^[a-z]+$"`},
+		},
+		{
+			name:   "invalid pattern",
+			schema: `{"type": "string", "pattern": "("}`,
+			value:  `"abc"`,
+			errors: []string{`invalid pattern "(" in schema`},
+		},
+		{
+			name:   "enum",
+			schema: `{"enum": ["red", "green"]}`,
+			value:  `"blue"`,
+			errors: []string{`value "blue" is not one of the allowed values`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatalf("bad schema: %v", err)
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("bad value: %v", err)
+			}
+			errs := validateJSONSchema(schema, value)
+			if len(errs) != len(tt.errors) {
+				t.Fatalf("got %d violations %q, want %d", len(errs), errs, len(tt.errors))
+			}
+			for i, want := range tt.errors {
+				if !strings.Contains(errs[i], want) {
+					t.Errorf("violation %d = %q, want it to contain %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateJSONText(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "required": ["answer"]}`)
+	if errs := validateJSONText(schema, "  {\"answer\": 42}\n"); len(errs) != 0 {
+		t.Errorf("valid response: got %q", errs)
+	}
+	if errs := validateJSONText(schema, "not json"); len(errs) != 1 || !strings.Contains(errs[0], "response is not valid JSON") {
+		t.Errorf("invalid JSON: got %q", errs)
+	}
+	if errs := validateJSONText(json.RawMessage(`[1]`), "{}"); len(errs) != 1 || !strings.Contains(errs[0], "schema is not a valid JSON object") {
+		t.Errorf("invalid schema: got %q", errs)
+	}
+}
+
+func TestParseOutputConstraint(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantNil bool
+		wantErr bool
+	}{
+		{raw: "", wantNil: true},
+		{raw: `{}`, wantNil: true},
+		{raw: `{"grammar": "  "}`, wantNil: true},
+		{raw: `{"json_schema": {"type": "object"}}`},
+		{raw: `{"grammar": "root ::= \"yes\" | \"no\""}`},
+		{raw: `{"json_schema": {"type": "object"}, "grammar": "root ::= \"yes\""}`, wantErr: true},
+		{raw: `{"json_schema": [1, 2]}`, wantErr: true},
+		{raw: `not json`, wantErr: true},
+	}
+	for _, test := range tests {
+		constraint, err := parseOutputConstraint(test.raw)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseOutputConstraint(%q) error = %v, want error %v", test.raw, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && (constraint == nil) != test.wantNil {
+			t.Errorf("parseOutputConstraint(%q) = %+v, want nil %v", test.raw, constraint, test.wantNil)
+		}
+	}
+}
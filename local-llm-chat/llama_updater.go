@@ -10,7 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 
-	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+	"local-llm-chat/events"
 )
 
 // GitHubRelease represents a single release from the GitHub API.
@@ -77,14 +77,14 @@ func (a *App) DownloadLlamaCppAsset(assetURL, assetName, tagName string) {
 	go func() {
 		downloadPath := filepath.Join(os.TempDir(), assetName)
 		if err := a.downloadFileWithProgress(assetURL, downloadPath); err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Download failed: %v", err)
+			a.logErrorf("Download failed: %v", err)
 			a.emitDownloadError(err.Error())
 			return
 		}
 
 		exePath, err := os.Executable()
 		if err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Could not get executable path: %v", err)
+			a.logErrorf("Could not get executable path: %v", err)
 			a.emitDownloadError(err.Error())
 			return
 		}
@@ -92,7 +92,7 @@ func (a *App) DownloadLlamaCppAsset(assetURL, assetName, tagName string) {
 		destDir := filepath.Join(appDir, fmt.Sprintf("llama.cpp_%s", tagName))
 
 		if err := unzipFile(downloadPath, destDir); err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Extraction failed: %v", err)
+			a.logErrorf("Extraction failed: %v", err)
 			a.emitDownloadError(err.Error())
 			return
 		}
@@ -101,14 +101,14 @@ func (a *App) DownloadLlamaCppAsset(assetURL, assetName, tagName string) {
 
 		currentSettings, err := a.LoadSettings()
 		if err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Failed to load settings before update: %v", err)
+			a.logErrorf("Failed to load settings before update: %v", err)
 			a.emitDownloadError("Failed to load settings before update.")
 			return
 		}
 
 		var config Config
 		if err := json.Unmarshal([]byte(currentSettings), &config); err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Failed to unmarshal settings for update: %v", err)
+			a.logErrorf("Failed to unmarshal settings for update: %v", err)
 			a.emitDownloadError("Failed to parse settings for update.")
 			return
 		}
@@ -117,18 +117,18 @@ func (a *App) DownloadLlamaCppAsset(assetURL, assetName, tagName string) {
 
 		updatedSettings, err := json.Marshal(config)
 		if err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Failed to marshal settings for saving: %v", err)
+			a.logErrorf("Failed to marshal settings for saving: %v", err)
 			a.emitDownloadError("Failed to prepare settings for saving.")
 			return
 		}
 
 		if err := a.SaveSettings(string(updatedSettings)); err != nil {
-			wailsruntime.LogErrorf(a.ctx, "Failed to save updated settings: %v", err)
+			a.logErrorf("Failed to save updated settings: %v", err)
 			a.emitDownloadError("Failed to save updated settings.")
 			return
 		}
 
-		wailsruntime.EventsEmit(a.ctx, "llama-cpp-download-complete", destDir)
+		a.emit(events.DownloadComplete, destDir)
 	}()
 }
 
@@ -227,11 +227,11 @@ func (a *App) emitDownloadProgress(downloaded, total int64) {
 		"human_downloaded": humanizeSize(downloaded),
 		"human_total":      humanizeSize(total),
 	}
-	wailsruntime.EventsEmit(a.ctx, "llama-cpp-download-progress", progress)
+	a.emit(events.DownloadProgress, progress)
 }
 
 func (a *App) emitDownloadError(errorMessage string) {
-	wailsruntime.EventsEmit(a.ctx, "llama-cpp-download-error", errorMessage)
+	a.emit(events.DownloadError, errorMessage)
 }
 
 func humanizeSize(s int64) string {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Loop strategies select what the Tool-Using Agent does when it repeats itself.
+const (
+	LoopStrategyHint        = "hint"         // Run the call, but tell the model it is repeating itself (default)
+	LoopStrategyBlock       = "block"        // Do not run the call; tell the model why
+	LoopStrategyFinalAnswer = "final_answer" // Stop calling tools and answer with what is known
+)
+
+// maxLoopDetections is how many loops a run may hit before a final answer is
+// forced, whatever the configured strategy.
+const maxLoopDetections = 3
+
+// Kinds of loops the detector reports.
+const (
+	LoopRepeat      = "repeat"      // The same call twice in a row
+	LoopOscillation = "oscillation" // A call seen earlier in the run, e.g. A, B, A
+)
+
+// toolCallSignature identifies a call by tool name and arguments. Map keys are
+// marshalled in sorted order, so equal arguments give equal signatures; missing
+// and empty arguments are the same.
+func toolCallSignature(call ToolCall) string {
+	if len(call.Arguments) == 0 {
+		return call.ToolName + " {}"
+	}
+	argsBytes, err := json.Marshal(call.Arguments)
+	if err != nil {
+		return call.ToolName
+	}
+	return call.ToolName + " " + string(argsBytes)
+}
+
+// LoopDetector watches the tool calls of one agent run for repeats.
+type LoopDetector struct {
+	history    []string
+	Detections int
+}
+
+// NewLoopDetector creates a detector for a new agent run.
+func NewLoopDetector() *LoopDetector {
+	return &LoopDetector{}
+}
+
+// Check reports whether a call would repeat an earlier call of this run, and
+// how. It returns "" if the call is new. Check does not record the call.
+func (d *LoopDetector) Check(call ToolCall) string {
+	signature := toolCallSignature(call)
+	for i := len(d.history) - 1; i >= 0; i-- {
+		if d.history[i] != signature {
+			continue
+		}
+		d.Detections++
+		if i == len(d.history)-1 {
+			return LoopRepeat
+		}
+		return LoopOscillation
+	}
+	return ""
+}
+
+// Record adds a call to the run's history.
+func (d *LoopDetector) Record(call ToolCall) {
+	d.history = append(d.history, toolCallSignature(call))
+}
+
+// loopStrategy returns the configured strategy, defaulting to a hint. After
+// maxLoopDetections loops in one run the final answer is forced.
+func (a *App) loopStrategy(detector *LoopDetector) string {
+	if detector.Detections >= maxLoopDetections {
+		return LoopStrategyFinalAnswer
+	}
+	switch a.config.ToolLoopStrategy {
+	case LoopStrategyBlock, LoopStrategyFinalAnswer:
+		return a.config.ToolLoopStrategy
+	default:
+		return LoopStrategyHint
+	}
+}
+
+// loopMessage explains a detected loop to the model.
+func loopMessage(kind string, call ToolCall, blocked bool) string {
+	var what string
+	if kind == LoopRepeat {
+		what = fmt.Sprintf("You just called %s with the same arguments.", call.ToolName)
+	} else {
+		what = fmt.Sprintf("You already called %s with these arguments earlier and are going in circles.", call.ToolName)
+	}
+	if blocked {
+		return what + " The call was not run again. Use the results you already have, try different arguments or a different tool, or give your final answer."
+	}
+	return what + " The result will not change. Use the results you already have or give your final answer."
+}
+
+// loopFinalAnswerPrompt is added to the final request when a loop ends the run.
+const loopFinalAnswerPrompt = "You are repeating the same tool calls. Do not call any more tools. Answer the user's request now using the tool results above, and say so if they are not enough."
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func call(name string, args map[string]interface{}) ToolCall {
+	return ToolCall{ToolName: name, Arguments: args}
+}
+
+func TestLoopDetector(t *testing.T) {
+	weatherParis := call("get_weather", map[string]interface{}{"city": "Paris", "units": "c"})
+	// The same arguments in another order, from another call ID.
+	weatherParisAgain := ToolCall{ID: "other", ToolName: "get_weather", Arguments: map[string]interface{}{"units": "c", "city": "Paris"}}
+	weatherRome := call("get_weather", map[string]interface{}{"city": "Rome", "units": "c"})
+	search := call("search", map[string]interface{}{"query": "Paris"})
+
+	tests := []struct {
+		name  string
+		calls []ToolCall
+		want  []string // Check's result for each call
+	}{
+		{"distinct calls", []ToolCall{weatherParis, weatherRome, search}, []string{"", "", ""}},
+		{"same call twice", []ToolCall{weatherParis, weatherParisAgain}, []string{"", LoopRepeat}},
+		{"same call three times", []ToolCall{search, search, search}, []string{"", LoopRepeat, LoopRepeat}},
+		{"other arguments", []ToolCall{weatherParis, weatherRome}, []string{"", ""}},
+		{"A B A", []ToolCall{weatherParis, search, weatherParis}, []string{"", "", LoopOscillation}},
+		{"A B A B", []ToolCall{weatherParis, search, weatherParis, search}, []string{"", "", LoopOscillation, LoopOscillation}},
+		{"A B C A", []ToolCall{weatherParis, search, weatherRome, weatherParis}, []string{"", "", "", LoopOscillation}},
+		{"no arguments", []ToolCall{call("list", nil), call("list", map[string]interface{}{})}, []string{"", LoopRepeat}},
+	}
+	for _, test := range tests {
+		detector := NewLoopDetector()
+		detections := 0
+		for i, c := range test.calls {
+			got := detector.Check(c)
+			detector.Record(c)
+			if got != test.want[i] {
+				t.Errorf("%s: call %d: Check = %q, want %q", test.name, i, got, test.want[i])
+			}
+			if got != "" {
+				detections++
+			}
+		}
+		if detector.Detections != detections {
+			t.Errorf("%s: Detections = %d, want %d", test.name, detector.Detections, detections)
+		}
+	}
+}
+
+func TestLoopDetectorCheckDoesNotRecord(t *testing.T) {
+	detector := NewLoopDetector()
+	search := call("search", map[string]interface{}{"query": "x"})
+	if detector.Check(search) != "" || detector.Check(search) != "" {
+		t.Error("a call that was only checked counts as a repeat")
+	}
+}
+
+func TestLoopStrategy(t *testing.T) {
+	tests := []struct {
+		configured string
+		detections int
+		want       string
+	}{
+		{"", 1, LoopStrategyHint},
+		{"unknown", 1, LoopStrategyHint},
+		{LoopStrategyHint, 1, LoopStrategyHint},
+		{LoopStrategyBlock, 1, LoopStrategyBlock},
+		{LoopStrategyFinalAnswer, 1, LoopStrategyFinalAnswer},
+		// Up to the threshold the configured strategy applies; from then on
+		// the final answer is forced.
+		{LoopStrategyHint, maxLoopDetections - 1, LoopStrategyHint},
+		{LoopStrategyBlock, maxLoopDetections - 1, LoopStrategyBlock},
+		{LoopStrategyHint, maxLoopDetections, LoopStrategyFinalAnswer},
+		{LoopStrategyBlock, maxLoopDetections, LoopStrategyFinalAnswer},
+		{LoopStrategyBlock, maxLoopDetections + 1, LoopStrategyFinalAnswer},
+	}
+	for _, test := range tests {
+		app := NewApp()
+		app.config.ToolLoopStrategy = test.configured
+		detector := &LoopDetector{Detections: test.detections}
+		if got := app.loopStrategy(detector); got != test.want {
+			t.Errorf("strategy %q after %d loops = %q, want %q", test.configured, test.detections, got, test.want)
+		}
+	}
+}
+
+func TestLoopThresholdReachedByRepeats(t *testing.T) {
+	app := NewApp()
+	app.config.ToolLoopStrategy = LoopStrategyHint
+	detector := NewLoopDetector()
+	search := call("search", map[string]interface{}{"query": "x"})
+	var strategies []string
+	for i := 0; i <= maxLoopDetections; i++ {
+		if kind := detector.Check(search); kind != "" {
+			strategies = append(strategies, app.loopStrategy(detector))
+		}
+		detector.Record(search)
+	}
+	want := []string{LoopStrategyHint, LoopStrategyHint, LoopStrategyFinalAnswer}
+	if strings.Join(strategies, ",") != strings.Join(want, ",") {
+		t.Errorf("strategies = %v, want %v", strategies, want)
+	}
+}
+
+func TestLoopMessage(t *testing.T) {
+	search := call("search", nil)
+	if msg := loopMessage(LoopRepeat, search, false); !strings.Contains(msg, "just called search") || strings.Contains(msg, "not run") {
+		t.Errorf("hint for a repeat = %q", msg)
+	}
+	if msg := loopMessage(LoopOscillation, search, true); !strings.Contains(msg, "going in circles") || !strings.Contains(msg, "not run again") {
+		t.Errorf("block for an oscillation = %q", msg)
+	}
+}
+
+// TestToolAgentLoopStrategies runs the same tool call until the run ends and
+// checks what each strategy does with the repeats.
+func TestToolAgentLoopStrategies(t *testing.T) {
+	const toolCall = `{"tool_name": "get_weather", "arguments": {"city": "Paris"}}`
+	tests := []struct {
+		strategy   string
+		wantRuns   int32  // Times the tool actually ran over the calls
+		wantResult string // Start of the result of the second call
+		finalAt    int    // Call that ends the run
+	}{
+		{LoopStrategyHint, maxLoopDetections, "ok\n\nYou just called get_weather", maxLoopDetections + 1},
+		{LoopStrategyBlock, 1, "You just called get_weather with the same arguments. The call was not run again.", maxLoopDetections + 1},
+		{LoopStrategyFinalAnswer, 1, "You just called get_weather with the same arguments. The call was not run again.", 2},
+	}
+	for _, test := range tests {
+		app := NewApp()
+		app.router = NewRouter(app)
+		app.tokenCounter = NewTokenCounter(nil)
+		app.config.ToolLoopStrategy = test.strategy
+		runs := connectTestTools(t, app)
+		run := &toolAgentRun{app: app, sessionID: 1, selection: &ToolSelection{}, loopDetector: NewLoopDetector()}
+
+		for i := 1; ; i++ {
+			step := run.executeToolCall(toolCall, 0)
+			if i == 2 && !strings.HasPrefix(step.Result, test.wantResult) {
+				t.Errorf("%s: second result = %q, want it to start with %q", test.strategy, step.Result, test.wantResult)
+			}
+			if step.FinalAnswer {
+				if i != test.finalAt {
+					t.Errorf("%s: run ended at call %d, want %d", test.strategy, i, test.finalAt)
+				}
+				break
+			}
+			if i > maxLoopDetections+1 {
+				t.Fatalf("%s: run never ended", test.strategy)
+			}
+		}
+		if got := runs.Load(); got != test.wantRuns {
+			t.Errorf("%s: tool ran %d times, want %d", test.strategy, got, test.wantRuns)
+		}
+	}
+}
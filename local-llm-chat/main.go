@@ -17,6 +17,17 @@ import (
 var assets embed.FS
 
 func main() {
+	// Subcommands run without a window; see cli.go.
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				println("Error:", err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	userConfigDir, err := os.UserConfigDir()
 	if err != nil {
 		println("Error getting user config directory:", err.Error())
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"local-llm-chat/artifacts"
+	"local-llm-chat/events"
+	"local-llm-chat/nativetools"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// appMCPServerName is the name the app announces to MCP clients.
+const appMCPServerName = "local-llm-chat"
+
+// sessionsResourceURI lists the chat sessions; a single session is read from
+// sessionsResourceURI + "/{id}".
+const sessionsResourceURI = "chat://sessions"
+
+// newAppMCPServer creates the MCP server through which other programs reach
+// the local model, the chat history and the artifacts. Its handlers never call
+// the Wails runtime, so the server also runs without a window.
+func (a *App) newAppMCPServer() *server.MCPServer {
+	s := server.NewMCPServer(appMCPServerName, "1.0.0",
+		server.WithToolCapabilities(false),
+		server.WithResourceCapabilities(false, false),
+	)
+
+	s.AddTool(mcp.NewTool("chat",
+		mcp.WithDescription("Send a message to the local model and get its reply. The exchange is saved in the chat session."),
+		mcp.WithString("message", mcp.Required(), mcp.Description("The user message")),
+		mcp.WithNumber("session_id", mcp.Description("Chat session to continue (default: start a new session)")),
+		mcp.WithString("system_prompt", mcp.Description("System prompt of a new session")),
+		mcp.WithString("model", mcp.Description("Model file to answer with (default: the running or selected model); it is started if needed")),
+	), a.mcpChat)
+
+	s.AddTool(mcp.NewTool("list_sessions",
+		mcp.WithDescription("List the chat sessions, newest first."),
+	), a.mcpListSessions)
+
+	s.AddTool(mcp.NewTool("search_messages",
+		mcp.WithDescription("Search the messages of all chat sessions for a word or phrase."),
+		mcp.WithString("query", mcp.Required(), mcp.Description("Text to search for")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of messages to return (default 10)")),
+	), a.mcpSearchMessages)
+
+	s.AddTool(mcp.NewTool("get_artifact",
+		mcp.WithDescription("Get a saved artifact by ID. Documents are returned as text, images and audio as media."),
+		mcp.WithString("id", mcp.Required(), mcp.Description("Artifact ID")),
+	), a.mcpGetArtifact)
+
+	s.AddResource(mcp.NewResource(sessionsResourceURI, "Chat sessions",
+		mcp.WithResourceDescription("All chat sessions, newest first"),
+		mcp.WithMIMEType("application/json"),
+	), a.readSessionsResource)
+
+	s.AddResourceTemplate(mcp.NewResourceTemplate(sessionsResourceURI+"/{id}", "Chat session",
+		mcp.WithTemplateDescription("A chat session with its messages"),
+		mcp.WithTemplateMIMEType("application/json"),
+	), a.readSessionResource)
+
+	return s
+}
+
+func (a *App) mcpChat(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	message, err := request.RequireString("message")
+	if err != nil || strings.TrimSpace(message) == "" {
+		return mcp.NewToolResultError("message is required"), nil
+	}
+	if err := a.ensureModel(request.GetString("model", "")); err != nil {
+		return mcp.NewToolResultErrorFromErr("starting the model failed", err), nil
+	}
+
+	sessionID := int64(request.GetInt("session_id", 0))
+	if sessionID == 0 {
+		sessionID, err = a.db.NewChatSession(request.GetString("system_prompt", ""))
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("creating chat session failed", err), nil
+		}
+	}
+
+	reply, err := a.chatOnce(sessionID, message)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("chat failed", err), nil
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("%s\n\n[session %d]", reply, sessionID)), nil
+}
+
+func (a *App) mcpListSessions(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	sessions, err := a.db.GetChatSessions()
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("listing sessions failed", err), nil
+	}
+	if len(sessions) == 0 {
+		return mcp.NewToolResultText("There are no chat sessions."), nil
+	}
+
+	var b strings.Builder
+	for _, session := range sessions {
+		b.WriteString(fmt.Sprintf("- id=%d name=%q created=%s\n", session.ID, session.Name, session.CreatedAt))
+	}
+	return mcp.NewToolResultText(b.String()), nil
+}
+
+func (a *App) mcpSearchMessages(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	query, err := request.RequireString("query")
+	if err != nil || strings.TrimSpace(query) == "" {
+		return mcp.NewToolResultError("query is required"), nil
+	}
+	limit := request.GetInt("limit", 10)
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	matches, err := a.db.SearchChatMessages(query, limit)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("search failed", err), nil
+	}
+	if len(matches) == 0 {
+		return mcp.NewToolResultText(fmt.Sprintf("No messages contain %q.", query)), nil
+	}
+	return mcp.NewToolResultText(nativetools.FormatMessageMatches(matches, query)), nil
+}
+
+func (a *App) mcpGetArtifact(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, err := request.RequireString("id")
+	if err != nil {
+		return mcp.NewToolResultError("id is required"), nil
+	}
+	if a.ArtifactService == nil {
+		return mcp.NewToolResultError("artifact service not initialized"), nil
+	}
+	artifact, content, err := nativeToolsBackend{app: a}.ReadArtifact(id)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("reading artifact failed", err), nil
+	}
+	return nativetools.ArtifactResult(artifact, content), nil
+}
+
+func (a *App) readSessionsResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	sessions, err := a.db.GetChatSessions()
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []ChatSession{}
+	}
+	return jsonResource(request.Params.URI, sessions)
+}
+
+func (a *App) readSessionResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	idText := strings.TrimPrefix(request.Params.URI, sessionsResourceURI+"/")
+	sessionID, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session ID %q", idText)
+	}
+	export, err := a.exportSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return jsonResource(request.Params.URI, export)
+}
+
+// jsonResource returns value as the JSON contents of a resource.
+func jsonResource(uri string, value interface{}) ([]mcp.ResourceContents, error) {
+	valueBytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []mcp.ResourceContents{mcp.TextResourceContents{URI: uri, MIMEType: "application/json", Text: string(valueBytes)}}, nil
+}
+
+// chatOnce answers a message in a session with a single, non-streaming
+// completion and saves both messages to the session's history. It is how
+// other programs chat with the model; tools are not used.
+func (a *App) chatOnce(sessionID int64, message string) (string, error) {
+	session, err := a.db.GetChatSession(sessionID)
+	if err != nil {
+		return "", err
+	}
+	history, err := a.db.GetChatMessages(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	var messages []ChatMessage
+	if session.SystemPrompt != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: session.SystemPrompt})
+	}
+	for _, msg := range history {
+		if msg.Role == "assistant" {
+			msg.Content = stripThinkTags(msg.Content)
+		}
+		messages = append(messages, msg.ChatMessage)
+	}
+	userMessage := ChatMessage{Role: "user", Content: message}
+	messages = append(messages, userMessage)
+
+	if _, err := a.db.SaveChatMessage(sessionID, "user", message); err != nil {
+		return "", fmt.Errorf("saving user message: %w", err)
+	}
+	if len(history) == 0 {
+		if name, err := a.generateSessionName(message); err == nil && name != "" {
+			a.db.UpdateChatSessionName(sessionID, name)
+		}
+	}
+
+	sampling := a.modelSampling().Merge(session.Sampling)
+	response, err := a.makeLLMRequest(messages, false, nil, sampling)
+	if err != nil {
+		return "", err
+	}
+
+	// Store the reasoning with <think> tags, as streamHandler does.
+	reply := response.Content
+	if response.ReasoningContent != "" {
+		reply = fmt.Sprintf("<think>%s</think>\n%s", response.ReasoningContent, response.Content)
+	}
+	messageID, err := a.db.SaveChatMessage(sessionID, "assistant", reply)
+	if err != nil {
+		return "", fmt.Errorf("saving assistant message: %w", err)
+	}
+	if err := a.db.SetChatMessageSampling(messageID, &sampling); err != nil {
+		return "", fmt.Errorf("saving sampling params: %w", err)
+	}
+
+	// Keep a session that is open in the window in step with the database.
+	cleanedReply := stripThinkTags(harmonyVisibleText(reply))
+	a.appendToOpenConversation(sessionID, userMessage, ChatMessage{Role: "assistant", Content: cleanedReply})
+	return cleanedReply, nil
+}
+
+// startMCPHTTPServer serves the app's MCP server on localhost when
+// mcp_server_port is configured.
+func (a *App) startMCPHTTPServer() {
+	if a.config.MCPServerPort <= 0 {
+		return
+	}
+	httpServer := server.NewStreamableHTTPServer(a.newAppMCPServer())
+	a.mcpHTTPServer = httpServer
+	addr := fmt.Sprintf("127.0.0.1:%d", a.config.MCPServerPort)
+	go func() {
+		if err := httpServer.Start(addr); err != nil && err != http.ErrServerClosed {
+			a.logErrorf("MCP server on %s stopped: %v", addr, err)
+		}
+	}()
+	a.logInfof("MCP server listening on http://%s/mcp", addr)
+}
+
+// shutdownMCPHTTPServer stops the HTTP MCP server, if it is running.
+func (a *App) shutdownMCPHTTPServer() {
+	if a.mcpHTTPServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	a.mcpHTTPServer.Shutdown(ctx)
+}
+
+// initHeadless prepares the app for use without a window: it opens chat.db,
+// reads config.json and creates the artifact service, like startup does, but
+// without calling the Wails runtime.
+func (a *App) initHeadless() error {
+	db, err := NewDatabase("chat.db")
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	if err := db.Initialize(); err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+	a.db = db
+
+	configBytes, err := os.ReadFile("config.json")
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading config.json: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(configBytes, &a.config); err != nil {
+			return fmt.Errorf("decoding config.json: %w", err)
+		}
+	}
+	if a.config.ModelSettings == nil {
+		a.config.ModelSettings = make(map[string]ModelSettings)
+	}
+
+	artifactDataDir := ""
+	if userConfigDir, err := os.UserConfigDir(); err == nil {
+		artifactDataDir = filepath.Join(userConfigDir, "local-llm-chat", "artifacts")
+	}
+	a.ArtifactService = artifacts.NewArtifactService(a.bus, artifactDataDir)
+	if err := a.ArtifactService.Restore(a.db); err != nil {
+		return fmt.Errorf("restoring artifacts: %w", err)
+	}
+	return nil
+}
+
+// runMCPServer serves the app's MCP server without a window: over stdin and
+// stdout, or over HTTP on localhost if port is set.
+func runMCPServer(port int) error {
+	app := NewApp()
+	app.bus.Subscribe(events.NewLogger(log.Default(), events.LogInfo), events.Log)
+	if err := app.initHeadless(); err != nil {
+		return err
+	}
+	mcpServer := app.newAppMCPServer()
+
+	if port > 0 {
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+		log.Printf("MCP server listening on http://%s/mcp", addr)
+		return server.NewStreamableHTTPServer(mcpServer).Start(addr)
+	}
+	return server.ServeStdio(mcpServer, server.WithErrorLogger(log.New(os.Stderr, "mcp-server: ", log.LstdFlags)))
+}
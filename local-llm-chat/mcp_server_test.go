@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func chatToolRequest(arguments map[string]interface{}) mcp.CallToolRequest {
+	var request mcp.CallToolRequest
+	request.Params.Name = "chat"
+	request.Params.Arguments = arguments
+	return request
+}
+
+func callResultText(result *mcp.CallToolResult) string {
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func TestMcpChatWithoutModel(t *testing.T) {
+	// Nothing listens here, and no model is selected to launch.
+	app := newTestApp(t, "http://127.0.0.1:1")
+
+	result, err := app.mcpChat(context.Background(), chatToolRequest(map[string]interface{}{"message": "Hello"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsError || !strings.Contains(callResultText(result), "starting the model failed: no model selected") {
+		t.Errorf("result = %+v, want the model error", result)
+	}
+	if sessions, _ := app.db.GetChatSessions(); len(sessions) != 0 {
+		t.Errorf("a failed chat left %d sessions behind", len(sessions))
+	}
+}
+
+func TestMcpChatUsesRunningServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			fmt.Fprint(w, `{"status": "ok"}`)
+		case "/v1/chat/completions":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"message": map[string]string{"content": "Hi there."}}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	app := newTestApp(t, server.URL)
+
+	result, err := app.mcpChat(context.Background(), chatToolRequest(map[string]interface{}{"message": "Hello"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := callResultText(result); result.IsError || !strings.HasPrefix(text, "Hi there.") {
+		t.Errorf("result = %q, want the reply", text)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 type McpClient struct {
@@ -43,7 +44,44 @@ func (m *McpClient) Connect(command string, args []string) error {
 		return fmt.Errorf("failed to start client: %v", err)
 	}
 
-	// Initialize the client with the server
+	if err := initialize(c); err != nil {
+		return err
+	}
+
+	m.client = c
+	m.conn = stdioTransport
+
+	return nil
+}
+
+// ConnectInProcess connects to an MCP server running in this process. Calls
+// go through the same JSON-RPC handling as external servers, without a pipe.
+func (m *McpClient) ConnectInProcess(mcpServer *server.MCPServer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.client != nil {
+		return fmt.Errorf("client is already connected")
+	}
+
+	c, err := client.NewInProcessClient(mcpServer)
+	if err != nil {
+		return fmt.Errorf("failed to create in-process client: %v", err)
+	}
+	if err := c.Start(context.Background()); err != nil {
+		return fmt.Errorf("failed to start client: %v", err)
+	}
+
+	if err := initialize(c); err != nil {
+		return err
+	}
+
+	m.client = c
+	return nil
+}
+
+// initialize performs the MCP handshake. The client is closed if it fails.
+func initialize(c *client.Client) error {
 	initializeRequest := mcp.InitializeRequest{
 		Request: mcp.Request{
 			Method: "initialize",
@@ -69,10 +107,6 @@ func (m *McpClient) Connect(command string, args []string) error {
 		c.Close()
 		return fmt.Errorf("failed to initialize client: %v", err)
 	}
-
-	m.client = c
-	m.conn = stdioTransport
-
 	return nil
 }
 
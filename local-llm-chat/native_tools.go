@@ -0,0 +1,55 @@
+package main
+
+import (
+	"local-llm-chat/artifacts"
+	"local-llm-chat/mcpclient"
+	"local-llm-chat/nativetools"
+)
+
+// nativeToolsBackend gives the built-in tools access to the app's data.
+type nativeToolsBackend struct {
+	app *App
+}
+
+func (b nativeToolsBackend) SearchMessages(query string, limit int) ([]nativetools.MessageMatch, error) {
+	return b.app.db.SearchChatMessages(query, limit)
+}
+
+func (b nativeToolsBackend) ListArtifacts(sessionID, messageID int64) ([]*artifacts.Artifact, error) {
+	if sessionID == 0 {
+		return b.app.ArtifactService.AllArtifacts(), nil
+	}
+	return b.app.ArtifactService.ListArtifacts(sessionID, messageID)
+}
+
+func (b nativeToolsBackend) ReadArtifact(id string) (*artifacts.Artifact, []byte, error) {
+	artifact, err := b.app.ArtifactService.GetArtifact(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if artifact.ContentPath == "" {
+		return artifact, nil, nil
+	}
+	content, err := b.app.ArtifactService.ReadArtifactContent(id)
+	return artifact, content, err
+}
+
+func (b nativeToolsBackend) AllowedDirs() []string {
+	return b.app.config.NativeToolsAllowedDirs
+}
+
+// connectNativeTools registers the built-in tools as an in-process MCP server,
+// so the router lists and calls them like tools of any other server.
+func (a *App) connectNativeTools() {
+	if a.config.DisableNativeTools {
+		return
+	}
+	client := mcpclient.NewMcpClient()
+	if err := client.ConnectInProcess(nativetools.NewServer(nativeToolsBackend{app: a})); err != nil {
+		a.logErrorf("Error starting built-in tools: %v", err)
+		return
+	}
+	a.mu.Lock()
+	a.mcpClients[nativetools.ServerName] = client
+	a.mu.Unlock()
+}
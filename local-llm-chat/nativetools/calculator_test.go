@@ -0,0 +1,94 @@
+package nativetools
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 / 4", 2.5},
+		{"10 % 4", 2},
+		{"2^3^2", 512},
+		{"-2^2", -4},
+		{"--3", 3},
+		{"+-+3", -3},
+		{"2^-1", 0.5},
+		{"1.5e3 + .5", 1500.5},
+		{"sqrt(16) + abs(-2)", 6},
+		{"max(2, min(5, 3))", 3},
+		{"pow(2, 10)", 1024},
+		{"round(pi * 100) / 100", 3.14},
+		{"ln(e)", 1},
+	}
+	for _, tt := range tests {
+		got, err := Evaluate(tt.expression)
+		if err != nil {
+			t.Errorf("Evaluate(%q): %v", tt.expression, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Evaluate(%q) = %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", "missing closing parenthesis"},
+		{"1 / 0", "division by zero"},
+		{"5 % 0", "modulo by zero"},
+		{"foo(1)", `unknown name "foo"`},
+		{"sqrt 4", "must be followed by '('"},
+		{"min(1)", "min"},
+		{"1 2", "unexpected"},
+		{"sqrt(-1)", "not a finite number"},
+		{"os.exit(1)", ""},
+	}
+	for _, tt := range tests {
+		_, err := Evaluate(tt.expression)
+		if err == nil {
+			t.Errorf("Evaluate(%q): got no error", tt.expression)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Evaluate(%q) error = %q, want it to contain %q", tt.expression, err, tt.err)
+		}
+	}
+}
+
+func TestEvaluateDeepNesting(t *testing.T) {
+	tests := map[string]string{
+		"parentheses": strings.Repeat("(", 100_000) + "1" + strings.Repeat(")", 100_000),
+		"unary":       strings.Repeat("-", 30_000_000) + "1",
+		"power":       strings.Repeat("2^", 1_000_000) + "1",
+		"arguments":   strings.Repeat("abs(", 100_000) + "1" + strings.Repeat(")", 100_000),
+	}
+	for name, expression := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Evaluate(expression)
+			if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+				t.Errorf("got error %v, want nesting error", err)
+			}
+		})
+	}
+
+	// Reasonable nesting still works.
+	if got, err := Evaluate(strings.Repeat("(", 50) + "1" + strings.Repeat(")", 50)); err != nil || got != 1 {
+		t.Errorf("50 parentheses: got %v, %v", got, err)
+	}
+	if got, err := Evaluate(strings.Repeat("-", 100) + "1"); err != nil || got != 1 {
+		t.Errorf("100 minus signs: got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,117 @@
+package nativetools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxFileBytes caps how much of a file read_local_file returns. Larger results
+// are paged by the agent anyway, so this only guards against huge files.
+const maxFileBytes = 1 << 20
+
+// resolveAllowedPath returns the absolute, symlink-free form of path if it lies
+// inside one of the allowed directories. Relative paths are resolved against
+// the first allowed directory.
+func resolveAllowedPath(path string, allowedDirs []string) (string, error) {
+	if len(allowedDirs) == 0 {
+		return "", fmt.Errorf("no directories are allowed; add some to native_tools_allowed_dirs in config.json")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(allowedDirs[0], path)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", err
+	}
+
+	for _, dir := range allowedDirs {
+		allowed, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		allowed, err = filepath.Abs(allowed)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(allowed, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%s is outside the allowed directories", path)
+}
+
+// readAllowedFile reads a text file from an allowed directory.
+func readAllowedFile(path string, allowedDirs []string) (string, error) {
+	resolved, err := resolveAllowedPath(path, allowedDirs)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxFileBytes))
+	if err != nil {
+		return "", err
+	}
+	content := string(data)
+	if info.Size() > maxFileBytes {
+		content += fmt.Sprintf("\n[File truncated: read %d of %d bytes]", len(data), info.Size())
+	}
+	return content, nil
+}
+
+// listAllowedDir lists a directory inside an allowed directory, marking
+// subdirectories with a trailing slash.
+func listAllowedDir(path string, allowedDirs []string) (string, error) {
+	if path == "" {
+		if len(allowedDirs) == 0 {
+			return "", fmt.Errorf("no directories are allowed; add some to native_tools_allowed_dirs in config.json")
+		}
+		var b strings.Builder
+		b.WriteString("Allowed directories:\n")
+		for _, dir := range allowedDirs {
+			b.WriteString(dir + "\n")
+		}
+		return b.String(), nil
+	}
+
+	resolved, err := resolveAllowedPath(path, allowedDirs)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(resolved)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		b.WriteString(name + "\n")
+	}
+	if b.Len() == 0 {
+		return "(empty directory)", nil
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,104 @@
+package nativetools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveAllowedPath(t *testing.T) {
+	root := t.TempDir()
+	// EvalSymlinks, because the temporary directory may itself be a link
+	// (e.g. /tmp on macOS).
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filepath.Join(root, "allowed")
+	sibling := filepath.Join(root, "allowed2")
+	for _, dir := range []string{filepath.Join(allowed, "sub"), sibling} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path string) string {
+		if err := os.WriteFile(path, []byte("text"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	inside := write(filepath.Join(allowed, "sub", "notes.txt"))
+	dotted := write(filepath.Join(allowed, "..notes.txt"))
+	secret := write(filepath.Join(sibling, "secret.txt"))
+	outside := write(filepath.Join(root, "outside.txt"))
+	link := filepath.Join(allowed, "link.txt")
+	linkDir := filepath.Join(allowed, "linkdir")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(sibling, linkDir); err != nil {
+		t.Fatal(err)
+	}
+	innerLink := filepath.Join(allowed, "inner.txt")
+	if err := os.Symlink(inside, innerLink); err != nil {
+		t.Fatal(err)
+	}
+
+	dirs := []string{allowed}
+	tests := []struct {
+		name string
+		path string
+		want string // "" if the path must be refused
+	}{
+		{"absolute inside", inside, inside},
+		{"the directory itself", allowed, allowed},
+		{"relative", filepath.Join("sub", "notes.txt"), inside},
+		{"relative with dot", filepath.FromSlash("./sub/../sub/notes.txt"), inside},
+		{"name starting with dots", "..notes.txt", dotted},
+		{"link to a file inside", innerLink, inside},
+		{"dot-dot escape", filepath.Join(allowed, "..", "outside.txt"), ""},
+		{"relative dot-dot escape", filepath.Join("..", "outside.txt"), ""},
+		{"deep relative escape", filepath.Join("sub", "..", "..", "allowed2", "secret.txt"), ""},
+		{"sibling with the same prefix", secret, ""},
+		{"link to a file outside", link, ""},
+		{"through a link to a directory outside", filepath.Join(linkDir, "secret.txt"), ""},
+		{"missing file", filepath.Join(allowed, "missing.txt"), ""},
+	}
+	for _, test := range tests {
+		got, err := resolveAllowedPath(test.path, dirs)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: %s resolved to %s, want it refused", test.name, test.path, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: resolveAllowedPath(%s) = %q, %v; want %q", test.name, test.path, got, err, test.want)
+		}
+	}
+
+	if _, err := resolveAllowedPath(inside, nil); err == nil || !strings.Contains(err.Error(), "native_tools_allowed_dirs") {
+		t.Errorf("without allowed directories: error = %v", err)
+	}
+	// Any of several directories will do; relative paths use the first.
+	if got, err := resolveAllowedPath(secret, []string{allowed, sibling}); err != nil || got != secret {
+		t.Errorf("second allowed directory: got %q, %v", got, err)
+	}
+	if _, err := resolveAllowedPath("secret.txt", []string{allowed, sibling}); err == nil {
+		t.Error("a relative path was resolved against the second allowed directory")
+	}
+}
+
+func TestReadAllowedFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := readAllowedFile("a.txt", []string{dir}); err != nil || content != "hello" {
+		t.Errorf("readAllowedFile = %q, %v", content, err)
+	}
+	if _, err := readAllowedFile(".", []string{dir}); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("reading a directory: error = %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Package nativetools provides tools that run inside the application. They
+// are served by an mcp-go server over an in-process transport, so the agent
+// calls them exactly like tools of an external MCP server.
+package nativetools
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"local-llm-chat/artifacts"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ServerName is the name the built-in tools are registered under.
+const ServerName = "builtin"
+
+// MessageMatch is a chat message found by a conversation search.
+type MessageMatch struct {
+	SessionID   int64  `json:"session_id"`
+	SessionName string `json:"session_name"`
+	MessageID   int64  `json:"message_id"`
+	Sender      string `json:"sender"`
+	Content     string `json:"content"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Backend gives the tools access to the application's data.
+type Backend interface {
+	// SearchMessages finds messages containing query, newest first.
+	SearchMessages(query string, limit int) ([]MessageMatch, error)
+	// ListArtifacts lists the artifacts of a session, or of all sessions if
+	// sessionID is 0. If messageID is not 0, only that message's are listed.
+	ListArtifacts(sessionID, messageID int64) ([]*artifacts.Artifact, error)
+	// ReadArtifact returns an artifact and its stored content.
+	ReadArtifact(id string) (*artifacts.Artifact, []byte, error)
+	// AllowedDirs returns the directories the file tools may read from.
+	AllowedDirs() []string
+}
+
+// Defaults for the search tool.
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	snippetChars       = 300
+)
+
+// NewServer creates the MCP server that hosts the built-in tools.
+func NewServer(backend Backend) *server.MCPServer {
+	s := server.NewMCPServer(ServerName, "1.0.0", server.WithToolCapabilities(false))
+	t := &tools{backend: backend}
+
+	s.AddTool(mcp.NewTool("search_conversations",
+		mcp.WithDescription("Search earlier chat messages in all conversations for a word or phrase."),
+		mcp.WithString("query", mcp.Required(), mcp.Description("Text to search for")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of messages to return (default 10)")),
+	), t.searchConversations)
+
+	s.AddTool(mcp.NewTool("list_artifacts",
+		mcp.WithDescription("List saved artifacts (images, audio, documents, tool results) with their IDs."),
+		mcp.WithNumber("session_id", mcp.Description("Only list artifacts of this chat session")),
+		mcp.WithNumber("message_id", mcp.Description("Only list artifacts of this message of the session")),
+	), t.listArtifacts)
+
+	s.AddTool(mcp.NewTool("read_artifact",
+		mcp.WithDescription("Read a saved artifact by ID. Documents are returned as text, images and audio as media."),
+		mcp.WithString("id", mcp.Required(), mcp.Description("Artifact ID from list_artifacts")),
+	), t.readArtifact)
+
+	s.AddTool(mcp.NewTool("current_datetime",
+		mcp.WithDescription("Get the current date, time and weekday."),
+		mcp.WithString("timezone", mcp.Description("IANA time zone such as Europe/Berlin (default: local time)")),
+	), t.currentDatetime)
+
+	s.AddTool(mcp.NewTool("calculate",
+		mcp.WithDescription("Evaluate an arithmetic expression with + - * / % ^, parentheses, pi, e and sqrt, abs, round, floor, ceil, ln, log10, sin, cos, tan, min, max, pow."),
+		mcp.WithString("expression", mcp.Required(), mcp.Description("Expression such as (3 + 4) * 2^3")),
+	), t.calculate)
+
+	s.AddTool(mcp.NewTool("read_local_file",
+		mcp.WithDescription("Read a text file from a directory the user has allowed."),
+		mcp.WithString("path", mcp.Required(), mcp.Description("Absolute path, or a path relative to the first allowed directory")),
+	), t.readLocalFile)
+
+	s.AddTool(mcp.NewTool("list_local_files",
+		mcp.WithDescription("List a directory the user has allowed. Without a path, lists the allowed directories."),
+		mcp.WithString("path", mcp.Description("Directory to list")),
+	), t.listLocalFiles)
+
+	return s
+}
+
+// tools implements the tool handlers.
+type tools struct {
+	backend Backend
+}
+
+func (t *tools) searchConversations(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	query, err := request.RequireString("query")
+	if err != nil || strings.TrimSpace(query) == "" {
+		return mcp.NewToolResultError("query is required"), nil
+	}
+	limit := request.GetInt("limit", defaultSearchLimit)
+	if limit <= 0 || limit > maxSearchLimit {
+		limit = defaultSearchLimit
+	}
+
+	matches, err := t.backend.SearchMessages(query, limit)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("search failed", err), nil
+	}
+	if len(matches) == 0 {
+		return mcp.NewToolResultText(fmt.Sprintf("No messages contain %q.", query)), nil
+	}
+
+	return mcp.NewToolResultText(FormatMessageMatches(matches, query)), nil
+}
+
+// FormatMessageMatches lists search matches one per line, each with a snippet
+// of the message around query.
+func FormatMessageMatches(matches []MessageMatch, query string) string {
+	var b strings.Builder
+	for _, match := range matches {
+		b.WriteString(fmt.Sprintf("- [session %d \"%s\", message %d, %s, %s] %s\n",
+			match.SessionID, match.SessionName, match.MessageID, match.Sender, match.CreatedAt, snippet(match.Content, query)))
+	}
+	return b.String()
+}
+
+// snippet returns up to snippetChars characters of content around the first
+// occurrence of query.
+func snippet(content, query string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if len(content) <= snippetChars {
+		return content
+	}
+	start := strings.Index(strings.ToLower(content), strings.ToLower(query)) - snippetChars/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetChars
+	if end > len(content) {
+		end, start = len(content), len(content)-snippetChars
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+	result := content[start:end]
+	if start > 0 {
+		result = "..." + result
+	}
+	if end < len(content) {
+		result += "..."
+	}
+	return result
+}
+
+func (t *tools) listArtifacts(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	list, err := t.backend.ListArtifacts(int64(request.GetInt("session_id", 0)), int64(request.GetInt("message_id", 0)))
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("listing artifacts failed", err), nil
+	}
+	if len(list) == 0 {
+		return mcp.NewToolResultText("There are no artifacts."), nil
+	}
+
+	var b strings.Builder
+	for _, artifact := range list {
+		name, _ := artifact.Metadata["file_name"].(string)
+		b.WriteString(fmt.Sprintf("- id=%s type=%s name=%q session=%d", artifact.ID, artifact.Type, name, artifact.SessionID))
+		if artifact.MessageID != 0 {
+			b.WriteString(fmt.Sprintf(" message=%d", artifact.MessageID))
+		}
+		if artifact.ToolCallID != "" {
+			b.WriteString(fmt.Sprintf(" tool_call=%s", artifact.ToolCallID))
+		}
+		b.WriteString(fmt.Sprintf(" created=%s\n", artifact.Timestamp))
+	}
+	return mcp.NewToolResultText(b.String()), nil
+}
+
+func (t *tools) readArtifact(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, err := request.RequireString("id")
+	if err != nil {
+		return mcp.NewToolResultError("id is required"), nil
+	}
+	artifact, content, err := t.backend.ReadArtifact(id)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("reading artifact failed", err), nil
+	}
+	return ArtifactResult(artifact, content), nil
+}
+
+// ArtifactResult turns an artifact into a tool result: documents as text,
+// images and audio as media.
+func ArtifactResult(artifact *artifacts.Artifact, content []byte) *mcp.CallToolResult {
+	id := artifact.ID
+	mimeType, _ := artifact.Metadata["mime_type"].(string)
+	switch artifact.Type {
+	case artifacts.TypeImage:
+		if mimeType == "" {
+			mimeType = "image/png"
+		}
+		return mcp.NewToolResultImage(fmt.Sprintf("Image artifact %s", id), base64.StdEncoding.EncodeToString(content), mimeType)
+	case artifacts.TypeAudio:
+		if mimeType == "" {
+			mimeType = "audio/wav"
+		}
+		return mcp.NewToolResultAudio(fmt.Sprintf("Audio artifact %s", id), base64.StdEncoding.EncodeToString(content), mimeType)
+	case artifacts.TypeDocument:
+		if !utf8.Valid(content) {
+			return mcp.NewToolResultErrorf("artifact %s is a binary document (%d bytes) and cannot be shown as text", id, len(content))
+		}
+		return mcp.NewToolResultText(string(content))
+	case artifacts.TypeToolNotification:
+		message, _ := artifact.Metadata["message"].(string)
+		return mcp.NewToolResultText(message)
+	default:
+		return mcp.NewToolResultErrorf("artifacts of type %s cannot be read", artifact.Type)
+	}
+}
+
+func (t *tools) currentDatetime(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	now := time.Now()
+	if zone := request.GetString("timezone", ""); zone != "" {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return mcp.NewToolResultErrorf("unknown time zone %q", zone), nil
+		}
+		now = now.In(location)
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("%s (%s, %s)", now.Format(time.RFC3339), now.Weekday(), now.Location())), nil
+}
+
+func (t *tools) calculate(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	expression, err := request.RequireString("expression")
+	if err != nil {
+		return mcp.NewToolResultError("expression is required"), nil
+	}
+	value, err := Evaluate(expression)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("could not evaluate expression", err), nil
+	}
+	return mcp.NewToolResultText(strconv.FormatFloat(value, 'g', -1, 64)), nil
+}
+
+func (t *tools) readLocalFile(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	path, err := request.RequireString("path")
+	if err != nil {
+		return mcp.NewToolResultError("path is required"), nil
+	}
+	content, err := readAllowedFile(path, t.backend.AllowedDirs())
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("could not read file", err), nil
+	}
+	return mcp.NewToolResultText(content), nil
+}
+
+func (t *tools) listLocalFiles(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	listing, err := listAllowedDir(request.GetString("path", ""), t.backend.AllowedDirs())
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("could not list directory", err), nil
+	}
+	return mcp.NewToolResultText(listing), nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"local-llm-chat/events"
+)
+
+// Document retrieval (RAG): files and folders are added to collections, split
+// into chunks and embedded. Before a user message is answered, the chunks of
+// the chat's collections that are most similar to it are given to the model,
+// which cites them by number.
+
+const (
+	defaultRAGTopK         = 4
+	defaultRAGChunkSize    = 1200
+	defaultRAGChunkOverlap = 200
+	maxRAGDocumentBytes    = 10 << 20 // Larger files are skipped
+	ragEmbeddingBatchSize  = 16
+)
+
+// errUnindexable marks files that are never indexed, such as binary files.
+// They are remembered so the watcher does not read them again until they
+// change.
+var errUnindexable = errors.New("file cannot be indexed")
+
+// RAGCollection is a named set of indexed documents.
+type RAGCollection struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	SessionID int64  `json:"session_id,omitempty"` // The chat that owns the collection, if any
+	Documents int    `json:"documents"`
+	Chunks    int    `json:"chunks"`
+	CreatedAt string `json:"created_at"`
+}
+
+// RAGSource is a file or folder added to a collection. The watcher keeps its
+// documents in sync with the disk.
+type RAGSource struct {
+	ID           int64  `json:"id"`
+	CollectionID int64  `json:"collection_id"`
+	Path         string `json:"path"`
+	ScannedAt    string `json:"scanned_at,omitempty"` // When the source was last synced completely
+}
+
+// RAGFileStat is the size and modification time a document was indexed at.
+// Files whose stat is unchanged are not read again.
+type RAGFileStat struct {
+	Size    int64
+	ModTime int64 // Unix nanoseconds
+}
+
+// RAGChunk is a piece of a document with its embedding.
+type RAGChunk struct {
+	ID         int64
+	Seq        int // Position of the chunk in its document
+	Content    string
+	Embedding  []float64
+	Path       string
+	Collection string
+}
+
+// Citation is a chunk retrieved for a user message. Index is the number the
+// model cites it by.
+type Citation struct {
+	Index      int              `json:"index"`
+	ChunkID    int64            `json:"chunk_id"`
+	Collection string           `json:"collection"`
+	Path       string           `json:"path"`
+	Seq        int              `json:"seq"`
+	Score      float64          `json:"score"` // The reranker's score, or else the fused score
+	Text       string           `json:"text"`
+	Scores     *RetrievalScores `json:"scores,omitempty"`
+}
+
+// encodeVector stores an embedding as little-endian float32 values.
+func encodeVector(vector []float64) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(v)))
+	}
+	return buf
+}
+
+// decodeVector reverses encodeVector.
+func decodeVector(buf []byte) []float64 {
+	vector := make([]float64, len(buf)/4)
+	for i := range vector {
+		vector[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:])))
+	}
+	return vector
+}
+
+// readDocumentText returns the text of a file. PDFs are converted with
+// pdftotext (from poppler), which must be on the PATH.
+func readDocumentText(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxRAGDocumentBytes {
+		return "", fmt.Errorf("%w: larger than %d MB", errUnindexable, maxRAGDocumentBytes>>20)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".pdf") {
+		pdftotext, err := exec.LookPath("pdftotext")
+		if err != nil {
+			return "", fmt.Errorf("reading PDFs needs pdftotext on the PATH: %w", err)
+		}
+		cmd := exec.Command(pdftotext, "-enc", "UTF-8", path, "-")
+		setHideWindow(cmd)
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("pdftotext failed: %w", err)
+		}
+		return string(out), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sample := data
+	if len(sample) > 8192 {
+		sample = sample[:8192]
+	}
+	if bytes.IndexByte(sample, 0) != -1 || !utf8.Valid(data) {
+		return "", fmt.Errorf("%w: not a text file", errUnindexable)
+	}
+	return string(data), nil
+}
+
+// chunkText splits text into chunks of at most size characters, preferring
+// paragraph and line breaks. Each chunk starts with the last overlap
+// characters of the previous one, so a passage cut in two stays findable.
+func chunkText(text string, size, overlap int) []string {
+	if overlap >= size/2 {
+		overlap = size / 4
+	}
+	runes := []rune(strings.ReplaceAll(text, "\r\n", "\n"))
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := start + size
+		if end >= len(runes) {
+			end = len(runes)
+		} else {
+			// Break at the last paragraph break, line break or space in the
+			// second half of the window.
+			window := string(runes[start+size/2 : end])
+			for _, sep := range []string{"\n\n", "\n", " "} {
+				if i := strings.LastIndex(window, sep); i != -1 {
+					end = start + size/2 + utf8.RuneCountInString(window[:i]) + utf8.RuneCountInString(sep)
+					break
+				}
+			}
+		}
+		if chunk := strings.TrimSpace(string(runes[start:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(runes) {
+			break
+		}
+		start = end - overlap
+		if start < 0 {
+			start = 0
+		}
+		// Start the overlap at a word boundary.
+		for i := start; i < end; i++ {
+			if unicode.IsSpace(runes[i]) {
+				start = i + 1
+				break
+			}
+		}
+	}
+	return chunks
+}
+
+// ragChunkSettings returns the configured chunk size and overlap. A negative
+// overlap turns it off.
+func (a *App) ragChunkSettings() (size, overlap int) {
+	size, overlap = a.config.RAGChunkSize, a.config.RAGChunkOverlap
+	if size <= 0 {
+		size = defaultRAGChunkSize
+	}
+	if overlap == 0 {
+		overlap = defaultRAGChunkOverlap
+	} else if overlap < 0 {
+		overlap = 0
+	}
+	return size, overlap
+}
+
+// skipRAGDir reports whether a folder is left out when indexing, such as
+// hidden folders and installed dependencies.
+func skipRAGDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor" || name == "__pycache__"
+}
+
+// indexPath adds a file or folder to a collection and indexes it. It returns
+// how many files were indexed; files that are unchanged or hold no text are
+// skipped. The path stays registered, so the watcher keeps it up to date.
+func (a *App) indexPath(collectionID int64, path string) (int, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	sourceID, err := a.db.AddRAGSource(collectionID, path)
+	if err != nil {
+		return 0, err
+	}
+	result, err := a.syncSource(context.Background(), RAGSource{ID: sourceID, CollectionID: collectionID, Path: path})
+	return result.Indexed, err
+}
+
+// indexFile chunks and embeds a file unless it is indexed with the same
+// content already. It reports whether the file was (re)indexed.
+func (a *App) indexFile(collectionID, sourceID int64, path string, info os.FileInfo) (bool, error) {
+	stat := RAGFileStat{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	text, err := readDocumentText(path)
+	if errors.Is(err, errUnindexable) {
+		// Stored without a hash or chunks, so it is skipped until it changes.
+		if errDb := a.db.ReplaceRAGDocument(collectionID, sourceID, path, "", stat, nil); errDb != nil {
+			return false, errDb
+		}
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256([]byte(text))
+	hash := hex.EncodeToString(sum[:])
+	if stored, err := a.db.GetRAGDocumentHash(collectionID, path); err != nil {
+		return false, err
+	} else if stored == hash {
+		// Touched but unchanged: remember the new time so it is not read again.
+		return false, a.db.UpdateRAGDocumentStat(collectionID, path, stat)
+	}
+
+	size, overlap := a.ragChunkSettings()
+	texts := chunkText(text, size, overlap)
+	chunks := make([]RAGChunk, len(texts))
+	client := a.embeddingClient()
+	for start := 0; start < len(texts); start += ragEmbeddingBatchSize {
+		end := start + ragEmbeddingBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		vectors, err := client.Embed(texts[start:end])
+		if err != nil {
+			return false, err
+		}
+		for i, vector := range vectors {
+			chunks[start+i] = RAGChunk{Seq: start + i, Content: texts[start+i], Embedding: vector}
+		}
+	}
+
+	if err := a.db.ReplaceRAGDocument(collectionID, sourceID, path, hash, stat, chunks); err != nil {
+		return false, err
+	}
+	a.logInfof("RAG: Indexed %s (%d chunks)", path, len(chunks))
+	return true, nil
+}
+
+// retrieve returns the chunks of a chat's collections that best match query,
+// best first. A chat without collections retrieves nothing.
+func (a *App) retrieve(sessionID int64, query string) ([]Citation, error) {
+	collections, err := a.db.GetSessionRAGCollections(sessionID)
+	if err != nil || len(collections) == 0 {
+		return nil, err
+	}
+	collectionIDs := make([]int64, len(collections))
+	for i, c := range collections {
+		collectionIDs[i] = c.ID
+	}
+	return a.searchCollections(collectionIDs, query, a.ragTopK())
+}
+
+// ragTopK returns how many chunks are retrieved per message.
+func (a *App) ragTopK() int {
+	if a.config.RAGTopK <= 0 {
+		return defaultRAGTopK
+	}
+	return a.config.RAGTopK
+}
+
+// retrieveForMessage retrieves the chunks for a user message, records them on
+// the message and announces them to the frontend. Failures are logged; the
+// message is then answered without documents.
+func (a *App) retrieveForMessage(sessionID, messageID int64, message string) []Citation {
+	citations, err := a.retrieve(sessionID, message)
+	if err != nil {
+		a.logErrorf("RAG: Error retrieving documents for session %d: %v", sessionID, err)
+		return nil
+	}
+	if len(citations) == 0 {
+		return nil
+	}
+	if err := a.db.SetChatMessageCitations(messageID, citations); err != nil {
+		a.logErrorf("RAG: Error saving citations: %v", err)
+	}
+	a.emit(events.Citations, map[string]interface{}{"sessionID": sessionID, "messageID": messageID, "citations": citations})
+	return citations
+}
+
+// retrievalMessage is the system message that gives the model the chunks
+// retrieved for the message it answers.
+func retrievalMessage(citations []Citation) (ChatMessage, bool) {
+	if len(citations) == 0 {
+		return ChatMessage{}, false
+	}
+	var b strings.Builder
+	b.WriteString("The following excerpts from the user's documents may help to answer. Use them where they are relevant and cite them by number in square brackets, e.g. [1]. Do not cite excerpts you did not use.\n")
+	for _, c := range citations {
+		fmt.Fprintf(&b, "\n[%d] %s (part %d)\n%s\n", c.Index, c.Path, c.Seq+1, c.Text)
+	}
+	return ChatMessage{Role: "system", Content: b.String()}, true
+}
+
+// CreateCollection creates a named document collection.
+func (a *App) CreateCollection(name string) (int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, fmt.Errorf("collection name is empty")
+	}
+	return a.db.CreateRAGCollection(name, 0)
+}
+
+// ListCollections lists all document collections.
+func (a *App) ListCollections() ([]RAGCollection, error) {
+	collections, err := a.db.GetRAGCollections()
+	if collections == nil && err == nil {
+		collections = []RAGCollection{}
+	}
+	return collections, err
+}
+
+// DeleteCollection deletes a collection and its index.
+func (a *App) DeleteCollection(collectionID int64) error {
+	return a.db.DeleteRAGCollection(collectionID)
+}
+
+// AddToCollection indexes a file or folder into a collection and returns how
+// many files were indexed.
+func (a *App) AddToCollection(collectionID int64, path string) (int, error) {
+	return a.indexPath(collectionID, path)
+}
+
+// RemoveFromCollection removes a file or folder from a collection, with the
+// documents indexed from it.
+func (a *App) RemoveFromCollection(collectionID int64, path string) error {
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+	return a.db.DeleteRAGSource(collectionID, path)
+}
+
+// AttachToChat indexes a file or folder into the chat's own collection, which
+// is created on first use, and returns how many files were indexed.
+func (a *App) AttachToChat(sessionID int64, path string) (int, error) {
+	collectionID, err := a.db.GetSessionOwnedRAGCollectionID(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	if collectionID == 0 {
+		collectionID, err = a.db.CreateRAGCollection(fmt.Sprintf("Chat %d", sessionID), sessionID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return a.indexPath(collectionID, path)
+}
+
+// AttachCollection makes a chat retrieve from a collection.
+func (a *App) AttachCollection(sessionID, collectionID int64) error {
+	return a.db.AttachRAGCollection(collectionID, sessionID)
+}
+
+// DetachCollection stops a chat from retrieving from a collection.
+func (a *App) DetachCollection(sessionID, collectionID int64) error {
+	return a.db.DetachRAGCollection(collectionID, sessionID)
+}
+
+// GetChatCollections lists the collections a chat retrieves from.
+func (a *App) GetChatCollections(sessionID int64) ([]RAGCollection, error) {
+	collections, err := a.db.GetSessionRAGCollections(sessionID)
+	if collections == nil && err == nil {
+		collections = []RAGCollection{}
+	}
+	return collections, err
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Hybrid retrieval: chunks are ranked twice, by embedding similarity and by
+// BM25 keyword score, and the two rankings are merged by reciprocal rank
+// fusion (RRF). Keywords find exact identifiers, such as ticket numbers and
+// function names, that embeddings blur. If a reranker is configured, the
+// best fused candidates are reordered by it.
+
+const (
+	rrfK             = 60 // Dampens the weight of the top ranks in RRF
+	ragCandidates    = 50 // Chunks taken from each ranking into the fusion
+	bm25K1           = 1.2
+	bm25B            = 0.75
+	minRerankChoices = 10
+)
+
+// chunkScore is the score of a chunk in one ranking.
+type chunkScore struct {
+	ChunkID int64
+	Score   float64
+}
+
+// RetrievalScores is how a chunk was ranked, for debugging retrieval. Ranks
+// start at 1; 0 means the chunk was not in that ranking's candidates.
+type RetrievalScores struct {
+	Vector     float64  `json:"vector"` // Cosine similarity to the query
+	VectorRank int      `json:"vector_rank"`
+	BM25       float64  `json:"bm25"` // Keyword score; higher is better
+	BM25Rank   int      `json:"bm25_rank"`
+	BM25Source string   `json:"bm25_source"`      // "fts5" or "go"
+	Fused      float64  `json:"fused"`            // RRF score
+	Rerank     *float64 `json:"rerank,omitempty"` // Relevance score of the reranker
+}
+
+// searchCollections returns the topK chunks of the given collections that
+// best match query, best first, with their scores. When embeddings are not
+// available, chunks are ranked by keywords alone.
+func (a *App) searchCollections(collectionIDs []int64, query string, topK int) ([]Citation, error) {
+	chunks, err := a.db.GetRAGChunks(collectionIDs)
+	if err != nil || len(chunks) == 0 {
+		return nil, err
+	}
+	byID := make(map[int64]RAGChunk, len(chunks))
+	for _, chunk := range chunks {
+		byID[chunk.ID] = chunk
+	}
+
+	vectorRanking, err := a.vectorRanking(chunks, query)
+	if err != nil {
+		a.logWarningf("RAG: Ranking by keywords only, embeddings failed: %v", err)
+	}
+
+	bm25Source := "go"
+	var bm25Ranking []chunkScore
+	if a.db.HasFullTextSearch() {
+		bm25Source = "fts5"
+		bm25Ranking, err = a.db.SearchRAGChunks(collectionIDs, query, ragCandidates)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		bm25Ranking = bm25Rank(chunks, query)
+	}
+
+	// Fuse the candidates of both rankings.
+	scores := make(map[int64]*RetrievalScores)
+	scoresFor := func(id int64) *RetrievalScores {
+		if scores[id] == nil {
+			scores[id] = &RetrievalScores{BM25Source: bm25Source}
+		}
+		return scores[id]
+	}
+	for i, s := range truncateRanking(vectorRanking, ragCandidates) {
+		entry := scoresFor(s.ChunkID)
+		entry.Vector, entry.VectorRank = s.Score, i+1
+		entry.Fused += 1.0 / float64(rrfK+i+1)
+	}
+	for i, s := range truncateRanking(bm25Ranking, ragCandidates) {
+		if _, ok := byID[s.ChunkID]; !ok {
+			continue
+		}
+		entry := scoresFor(s.ChunkID)
+		entry.BM25, entry.BM25Rank = s.Score, i+1
+		entry.Fused += 1.0 / float64(rrfK+i+1)
+	}
+
+	citations := make([]Citation, 0, len(scores))
+	for id, s := range scores {
+		chunk := byID[id]
+		citations = append(citations, Citation{
+			ChunkID:    id,
+			Collection: chunk.Collection,
+			Path:       chunk.Path,
+			Seq:        chunk.Seq,
+			Score:      s.Fused,
+			Text:       chunk.Content,
+			Scores:     s,
+		})
+	}
+	sortCitations(citations)
+
+	if a.config.RerankServerURL != "" {
+		choices := topK * 3
+		if choices < minRerankChoices {
+			choices = minRerankChoices
+		}
+		if len(citations) > choices {
+			citations = citations[:choices]
+		}
+		if err := a.rerank(query, citations); err != nil {
+			a.logWarningf("RAG: Keeping the fused order, reranking failed: %v", err)
+		} else {
+			sortCitations(citations)
+		}
+	}
+
+	if len(citations) > topK {
+		citations = citations[:topK]
+	}
+	for i := range citations {
+		citations[i].Index = i + 1
+	}
+	return citations, nil
+}
+
+// sortCitations orders citations by score, best first, and by chunk for
+// equal scores so results are stable.
+func sortCitations(citations []Citation) {
+	sort.Slice(citations, func(i, j int) bool {
+		if citations[i].Score != citations[j].Score {
+			return citations[i].Score > citations[j].Score
+		}
+		return citations[i].ChunkID < citations[j].ChunkID
+	})
+}
+
+func truncateRanking(ranking []chunkScore, n int) []chunkScore {
+	if len(ranking) > n {
+		return ranking[:n]
+	}
+	return ranking
+}
+
+// vectorRanking ranks all chunks by the cosine similarity of their embedding
+// to the query's.
+func (a *App) vectorRanking(chunks []RAGChunk, query string) ([]chunkScore, error) {
+	if err := a.ensureEmbeddingServer(); err != nil {
+		return nil, err
+	}
+	queryVectors, err := a.embeddingClient().Embed([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	ranking := make([]chunkScore, len(chunks))
+	for i, chunk := range chunks {
+		ranking[i] = chunkScore{ChunkID: chunk.ID, Score: cosineSimilarity(queryVectors[0], chunk.Embedding)}
+	}
+	sortRanking(ranking)
+	return ranking, nil
+}
+
+func sortRanking(ranking []chunkScore) {
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].Score != ranking[j].Score {
+			return ranking[i].Score > ranking[j].Score
+		}
+		return ranking[i].ChunkID < ranking[j].ChunkID
+	})
+}
+
+// bm25Tokens splits text into lowercase words for keyword scoring, like
+// FTS5's default tokenizer: letters and digits form words, all else separates.
+func bm25Tokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// bm25Rank scores chunks against query with Okapi BM25, for SQLite builds
+// without FTS5. Chunks without any query word are left out.
+func bm25Rank(chunks []RAGChunk, query string) []chunkScore {
+	terms := make(map[string]bool)
+	for _, term := range bm25Tokens(query) {
+		terms[term] = true
+	}
+	if len(terms) == 0 || len(chunks) == 0 {
+		return nil
+	}
+
+	termCounts := make([]map[string]int, len(chunks))
+	lengths := make([]int, len(chunks))
+	documentFrequency := make(map[string]int)
+	totalLength := 0
+	for i, chunk := range chunks {
+		tokens := bm25Tokens(chunk.Content)
+		lengths[i] = len(tokens)
+		totalLength += len(tokens)
+		termCounts[i] = make(map[string]int)
+		for _, token := range tokens {
+			if terms[token] {
+				termCounts[i][token]++
+			}
+		}
+		for term := range termCounts[i] {
+			documentFrequency[term]++
+		}
+	}
+	averageLength := float64(totalLength) / float64(len(chunks))
+	if averageLength == 0 {
+		return nil
+	}
+
+	n := float64(len(chunks))
+	var ranking []chunkScore
+	for i, counts := range termCounts {
+		if len(counts) == 0 {
+			continue
+		}
+		score := 0.0
+		for term, count := range counts {
+			df := float64(documentFrequency[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			tf := float64(count)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(lengths[i])/averageLength))
+		}
+		ranking = append(ranking, chunkScore{ChunkID: chunks[i].ID, Score: score})
+	}
+	sortRanking(ranking)
+	return ranking
+}
+
+// rerank scores the citations with the reranker at rerank_server_url, a
+// llama-server started with a reranking model and --reranking, and sets their
+// Score to its relevance score.
+func (a *App) rerank(query string, citations []Citation) error {
+	if len(citations) == 0 {
+		return nil
+	}
+	documents := make([]string, len(citations))
+	for i, c := range citations {
+		documents[i] = c.Text
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{"query": query, "documents": documents, "top_n": len(documents)})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Post(strings.TrimRight(a.config.RerankServerURL, "/")+"/rerank", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("error making rerank request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading rerank response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rerank request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("error unmarshalling rerank response: %w", err)
+	}
+	if len(result.Results) != len(citations) {
+		return fmt.Errorf("expected %d rerank scores, got %d", len(citations), len(result.Results))
+	}
+	for _, r := range result.Results {
+		if r.Index < 0 || r.Index >= len(citations) {
+			return fmt.Errorf("rerank index %d out of range", r.Index)
+		}
+	}
+	for _, r := range result.Results {
+		score := r.RelevanceScore
+		citations[r.Index].Score = score
+		citations[r.Index].Scores.Rerank = &score
+	}
+	return nil
+}
+
+// SearchCollection runs retrieval on one collection as it would run for a
+// chat message and returns the chunks with their score breakdown, to see why
+// a chunk is or is not found.
+func (a *App) SearchCollection(collectionID int64, query string, topK int) ([]Citation, error) {
+	if topK <= 0 {
+		topK = a.ragTopK()
+	}
+	citations, err := a.searchCollections([]int64{collectionID}, query, topK)
+	if citations == nil && err == nil {
+		citations = []Citation{}
+	}
+	return citations, err
+}
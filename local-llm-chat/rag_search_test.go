@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestBM25Rank(t *testing.T) {
+	chunks := []RAGChunk{
+		{ID: 1, Content: "The zebra grazes among other animals on the plain."},
+		{ID: 2, Content: "Nothing to see here."},
+		{ID: 3, Content: "Zebra, zebra!"},
+		{ID: 4, Content: "A zebra crossing."},
+	}
+	var ids []int64
+	for _, s := range bm25Rank(chunks, "ZEBRA crossing") {
+		ids = append(ids, s.ChunkID)
+	}
+	if want := []int64{4, 3, 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ranking = %v, want %v", ids, want)
+	}
+	if ranking := bm25Rank(chunks, "?!"); ranking != nil {
+		t.Errorf("query without words ranked %v", ranking)
+	}
+}
+
+// newSearchApp indexes 60 chunks whose embeddings are ever further from the
+// query's, so chunk i is ranked i+1 by vector similarity. Chunks 1 and 59
+// hold the keyword. It returns the number of documents sent to the reranker
+// with each request.
+func newSearchApp(t *testing.T) (*App, int64, *[]int) {
+	t.Helper()
+	var rerankRequests []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embedding":
+			json.NewEncoder(w).Encode([]interface{}{map[string]interface{}{"index": 0, "embedding": []float64{1, 0}}})
+		case "/rerank":
+			var request struct {
+				Documents []string `json:"documents"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			rerankRequests = append(rerankRequests, len(request.Documents))
+			// The reranker likes the fused order backwards.
+			var results []interface{}
+			for i := range request.Documents {
+				results = append(results, map[string]interface{}{"index": i, "relevance_score": float64(i)})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	app := newTestApp(t, server.URL)
+	app.config.EmbeddingServerURL = server.URL
+	app.db.fts5 = false // Rank keywords with bm25Rank
+
+	collectionID, err := app.db.CreateRAGCollection("animals", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceID, err := app.db.AddRAGSource(collectionID, "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []RAGChunk
+	for i := 0; i < 60; i++ {
+		content := fmt.Sprintf("Filler text number %d about the plain.", i)
+		switch i {
+		case 1:
+			content = "A zebra among the filler words of the plain."
+		case 59:
+			content = "Zebra zebra."
+		}
+		chunks = append(chunks, RAGChunk{Seq: i, Content: content, Embedding: []float64{1, float64(i)}})
+	}
+	if err := app.db.ReplaceRAGDocument(collectionID, sourceID, "/docs/animals.md", "hash", RAGFileStat{}, chunks); err != nil {
+		t.Fatal(err)
+	}
+	return app, collectionID, &rerankRequests
+}
+
+func citationSeqs(citations []Citation) []int {
+	var seqs []int
+	for _, c := range citations {
+		seqs = append(seqs, c.Seq)
+	}
+	return seqs
+}
+
+func TestSearchCollectionsFusion(t *testing.T) {
+	app, collectionID, _ := newSearchApp(t)
+
+	citations, err := app.searchCollections([]int64{collectionID}, "zebra", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first ragCandidates of the vector ranking count; chunk 59
+	// gets in by its keywords alone.
+	if len(citations) != ragCandidates+1 {
+		t.Errorf("got %d citations, want %d", len(citations), ragCandidates+1)
+	}
+	// Chunk 1 is in both rankings. Chunks 0 and 59 each lead one of them and
+	// tie, so the lower chunk ID comes first.
+	if got, want := citationSeqs(citations[:5]), []int{1, 0, 59, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fused order = %v, want %v", got, want)
+	}
+	first := citations[0]
+	if first.Index != 1 || first.Scores.VectorRank != 2 || first.Scores.BM25Rank != 2 || first.Scores.BM25Source != "go" {
+		t.Errorf("scores of the first citation = %+v", first.Scores)
+	}
+	if want := 2.0 / (rrfK + 2); first.Score != want {
+		t.Errorf("fused score = %v, want %v", first.Score, want)
+	}
+	if keyword := citations[2].Scores; keyword.VectorRank != 0 || keyword.BM25Rank != 1 {
+		t.Errorf("scores of the keyword-only citation = %+v", keyword)
+	}
+	for _, c := range citations {
+		if c.Seq >= ragCandidates && c.Seq != 59 {
+			t.Errorf("chunk %d is past the vector candidates but was returned", c.Seq)
+		}
+	}
+
+	citations, err = app.searchCollections([]int64{collectionID}, "zebra", 3)
+	if err != nil || len(citations) != 3 || citations[2].Index != 3 {
+		t.Errorf("topK 3: got %d citations, %v", len(citations), err)
+	}
+}
+
+func TestSearchCollectionsRerank(t *testing.T) {
+	app, collectionID, rerankRequests := newSearchApp(t)
+	app.config.RerankServerURL = app.config.EmbeddingServerURL
+
+	citations, err := app.searchCollections([]int64{collectionID}, "zebra", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reranker sees the best 3×topK, at least minRerankChoices, fused
+	// candidates: 1, 0, 59, 2, ..., 10. It reverses them.
+	if !reflect.DeepEqual(*rerankRequests, []int{12}) {
+		t.Errorf("reranker got %v documents, want [12]", *rerankRequests)
+	}
+	if got, want := citationSeqs(citations), []int{10, 9, 8, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reranked order = %v, want %v", got, want)
+	}
+	if rerank := citations[0].Scores.Rerank; rerank == nil || *rerank != 11 || citations[0].Score != 11 {
+		t.Errorf("first citation scores = %+v", citations[0].Scores)
+	}
+
+	*rerankRequests = nil
+	if _, err := app.searchCollections([]int64{collectionID}, "zebra", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*rerankRequests, []int{minRerankChoices}) {
+		t.Errorf("reranker got %v documents for topK 1, want [%d]", *rerankRequests, minRerankChoices)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		size, overlap int
+		want          []string
+	}{
+		{"short", "One chunk.", 100, 20, []string{"One chunk."}},
+		{"paragraphs", "First part.\n\nSecond part.", 20, 0, []string{"First part.", "Second part."}},
+		// Without a separator the text is cut mid-word, by characters.
+		{"no separator", strings.Repeat("あ", 25), 10, 0, []string{strings.Repeat("あ", 10), strings.Repeat("あ", 10), strings.Repeat("あ", 5)}},
+		{"no separator overlap", "abcdefghijklmnopqrstuvwxy", 10, 2, []string{"abcdefghij", "ijklmnopqr", "qrstuvwxy"}},
+		// An overlap of half the size or more would hardly move on; a
+		// quarter of the size is used instead.
+		{"overlap clamped", "abcdefghijklmnopqrst", 8, 100, []string{"abcdefgh", "ghijklmn", "mnopqrst"}},
+		{"overlap at half", "abcdefghijklmnopqrst", 8, 4, []string{"abcdefgh", "ghijklmn", "mnopqrst"}},
+		{"empty", " \n\n ", 10, 2, nil},
+	}
+	for _, test := range tests {
+		if got := chunkText(test.text, test.size, test.overlap); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChunkTextMultibyte(t *testing.T) {
+	text := strings.Repeat("Größere Übel für Öl. 日本語のテキスト。", 30)
+	for _, overlap := range []int{0, 5} {
+		chunks := chunkText(text, 40, overlap)
+		if len(chunks) < 2 {
+			t.Fatalf("overlap %d: got %d chunks, want several", overlap, len(chunks))
+		}
+		for _, chunk := range chunks {
+			if !utf8.ValidString(chunk) {
+				t.Fatalf("overlap %d: chunk %q is not valid UTF-8", overlap, chunk)
+			}
+			if n := utf8.RuneCountInString(chunk); n > 40 {
+				t.Errorf("overlap %d: chunk of %d characters: %q", overlap, n, chunk)
+			}
+		}
+		if overlap == 0 && strings.Join(strings.Fields(strings.Join(chunks, " ")), " ") != strings.Join(strings.Fields(text), " ") {
+			t.Errorf("chunks without overlap do not add up to the text:\n%q", chunks)
+		}
+		for i := 1; overlap > 0 && i < len(chunks); i++ {
+			if first := strings.Fields(chunks[i])[0]; !strings.Contains(chunks[i-1], first) {
+				t.Errorf("chunk %q does not start with the end of %q", chunks[i], chunks[i-1])
+			}
+		}
+	}
+}
+
+func TestRAGChunkSettings(t *testing.T) {
+	app := NewApp()
+	if size, overlap := app.ragChunkSettings(); size != defaultRAGChunkSize || overlap != defaultRAGChunkOverlap {
+		t.Errorf("unset: got %d, %d; want the defaults", size, overlap)
+	}
+	app.config.RAGChunkSize = 500
+	app.config.RAGChunkOverlap = -1
+	if size, overlap := app.ragChunkSettings(); size != 500 || overlap != 0 {
+		t.Errorf("negative overlap: got %d, %d; want 500, 0", size, overlap)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"local-llm-chat/events"
+)
+
+// defaultRAGWatchInterval is how often the watcher looks for changed files
+// when rag_watch_interval is not set.
+const defaultRAGWatchInterval = 60 * time.Second
+
+// RAGSyncResult sums up a sync of a source with the disk.
+type RAGSyncResult struct {
+	CollectionID int64  `json:"collection_id"`
+	Source       string `json:"source"`
+	Indexed      int    `json:"indexed"`   // Files embedded because they are new or changed
+	Unchanged    int    `json:"unchanged"` // Files skipped because their size and time are unchanged
+	Removed      int    `json:"removed"`   // Documents dropped because their file is gone
+	Failed       int    `json:"failed"`    // Files that could not be indexed this time
+	Error        string `json:"error,omitempty"`
+}
+
+// ragWatcher periodically syncs all registered sources, so documents that are
+// added, changed or deleted on disk are reflected in their collections.
+type ragWatcher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ragFile is a file found in a source.
+type ragFile struct {
+	path string
+	info os.FileInfo
+}
+
+// listSourceFiles returns the files of a source: the file itself, or the
+// files in a folder and its subfolders. Files and folders that could not be
+// read are returned as unreadable; their documents must be left alone.
+func listSourceFiles(root string) (files []ragFile, unreadable []string, err error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return []ragFile{{path: root, info: info}}, nil, nil
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			unreadable = append(unreadable, path)
+			return nil
+		}
+		if entry.IsDir() {
+			if path != root && skipRAGDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			unreadable = append(unreadable, path)
+			return nil
+		}
+		files = append(files, ragFile{path: path, info: info})
+		return nil
+	})
+	return files, unreadable, err
+}
+
+// withinAny reports whether path is one of roots or inside one of them.
+func withinAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncSource brings the documents of a source in line with the disk. Only
+// files whose size or modification time differ from the stored ones are read;
+// of those, only files whose content changed are embedded again. Documents of
+// deleted files are removed. Progress is published as it goes. Cancelling ctx
+// stops the sync between files; what was indexed so far is kept.
+func (a *App) syncSource(ctx context.Context, source RAGSource) (RAGSyncResult, error) {
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+
+	result := RAGSyncResult{CollectionID: source.CollectionID, Source: source.Path}
+	err := a.syncSourceLocked(ctx, source, &result)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	a.emit(events.IndexComplete, result)
+	return result, err
+}
+
+func (a *App) syncSourceLocked(ctx context.Context, source RAGSource, result *RAGSyncResult) error {
+	known, err := a.db.GetRAGSourceDocuments(source.ID)
+	if err != nil {
+		return err
+	}
+	files, unreadable, err := listSourceFiles(source.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// A missing folder, e.g. on an unplugged drive, keeps its documents.
+		// A file source that is gone was deleted.
+		if _, isFile := known[source.Path]; !isFile {
+			return err
+		}
+		files, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var changed []ragFile
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[file.path] = true
+		stat, ok := known[file.path]
+		if ok && stat.Size == file.info.Size() && stat.ModTime == file.info.ModTime().UnixNano() {
+			result.Unchanged++
+			continue
+		}
+		changed = append(changed, file)
+	}
+
+	if len(changed) > 0 {
+		if err := a.ensureEmbeddingServer(); err != nil {
+			return err
+		}
+	}
+	for i, file := range changed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a.emit(events.IndexProgress, map[string]interface{}{
+			"collection_id": source.CollectionID,
+			"source":        source.Path,
+			"path":          file.path,
+			"done":          i,
+			"total":         len(changed),
+		})
+		indexed, err := a.indexFile(source.CollectionID, source.ID, file.path, file.info)
+		switch {
+		case errors.Is(err, errUnindexable):
+			result.Unchanged++
+		case err != nil:
+			a.logWarningf("RAG: Could not index %s: %v", file.path, err)
+			result.Failed++
+		case indexed:
+			result.Indexed++
+		default:
+			result.Unchanged++
+		}
+	}
+
+	for path := range known {
+		if present[path] || withinAny(path, unreadable) {
+			continue
+		}
+		if err := a.db.DeleteRAGDocument(source.CollectionID, path); err != nil {
+			return err
+		}
+		a.logInfof("RAG: Removed %s", path)
+		result.Removed++
+	}
+
+	if result.Failed == 0 {
+		return a.db.SetRAGSourceScanned(source.ID)
+	}
+	return nil
+}
+
+// SyncCollections syncs every registered source with the disk now, instead of
+// waiting for the watcher.
+func (a *App) SyncCollections() ([]RAGSyncResult, error) {
+	return a.syncAllSources(context.Background())
+}
+
+func (a *App) syncAllSources(ctx context.Context) ([]RAGSyncResult, error) {
+	sources, err := a.db.GetRAGSources()
+	if err != nil {
+		return nil, err
+	}
+	results := []RAGSyncResult{}
+	for _, source := range sources {
+		result, err := a.syncSource(ctx, source)
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		if err != nil {
+			a.logWarningf("RAG: Could not sync %s: %v", source.Path, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// ragWatchInterval returns the configured watch interval; 0 means the
+// watcher is off.
+func (a *App) ragWatchInterval() time.Duration {
+	switch {
+	case a.config.RAGWatchInterval < 0:
+		return 0
+	case a.config.RAGWatchInterval == 0:
+		return defaultRAGWatchInterval
+	}
+	return time.Duration(a.config.RAGWatchInterval) * time.Second
+}
+
+// startRAGWatcher syncs all sources once, to pick up what changed while the
+// app was closed, and then every rag_watch_interval.
+func (a *App) startRAGWatcher() {
+	interval := a.ragWatchInterval()
+	if interval == 0 || a.ragWatcher != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := &ragWatcher{cancel: cancel, done: make(chan struct{})}
+	a.ragWatcher = watcher
+
+	go func() {
+		defer close(watcher.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := a.syncAllSources(ctx); err != nil && ctx.Err() == nil {
+				a.logErrorf("RAG: Error syncing collections: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopRAGWatcher stops the watcher and waits for a running sync to stop.
+func (a *App) stopRAGWatcher() {
+	if a.ragWatcher == nil {
+		return
+	}
+	a.ragWatcher.cancel()
+	<-a.ragWatcher.done
+	a.ragWatcher = nil
+}
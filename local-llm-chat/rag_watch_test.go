@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestListSourceFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.md", "docs/b.txt", "node_modules/c.js"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("text"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, unreadable, err := listSourceFiles(root)
+	if err != nil {
+		t.Fatalf("listSourceFiles: %v", err)
+	}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.path)
+	}
+	sort.Strings(paths)
+	want := []string{filepath.Join(root, "a.md"), filepath.Join(root, "docs", "b.txt")}
+	if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("files = %v, want %v", paths, want)
+	}
+	if len(unreadable) != 0 {
+		t.Errorf("unreadable = %v", unreadable)
+	}
+
+	single := filepath.Join(root, "a.md")
+	files, _, err = listSourceFiles(single)
+	if err != nil || len(files) != 1 || files[0].path != single {
+		t.Errorf("listSourceFiles(file) = %+v, %v", files, err)
+	}
+	if _, _, err := listSourceFiles(filepath.Join(root, "gone.md")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("listSourceFiles(missing) error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestWithinAny(t *testing.T) {
+	roots := []string{filepath.Join("data", "private")}
+	tests := map[string]bool{
+		filepath.Join("data", "private"):              true,
+		filepath.Join("data", "private", "notes.md"):  true,
+		filepath.Join("data", "private", "a", "b.md"): true,
+		filepath.Join("data", "privateer.md"):         false,
+		filepath.Join("data", "public.md"):            false,
+	}
+	for path, want := range tests {
+		if got := withinAny(path, roots); got != want {
+			t.Errorf("withinAny(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
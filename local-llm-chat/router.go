@@ -5,19 +5,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"local-llm-chat/nativetools"
+
 	"github.com/mark3labs/mcp-go/mcp"
-	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Router modes select how the Router Agent decides whether tools are needed.
+const (
+	RouterModeLLM       = "llm"       // Ask the LLM for a yes/no answer
+	RouterModeEmbedding = "embedding" // Compare query and tool embeddings
+)
+
+// Defaults for the embedding router.
+const (
+	defaultRouterSimilarityThreshold = 0.55
+	defaultRouterTopK                = 3
 )
 
 // Router handles the decision making for routing queries to tools or directly to LLM
 type Router struct {
 	app              *App
-	lastToolCallTime map[string]time.Time
+	lastToolCallTime map[string]time.Time // Keyed by tool name and arguments
 	mu               sync.Mutex
+	embeddingCache   map[string][]float64 // Tool description and example embeddings, keyed by server URL and text
+	cacheMu          sync.Mutex
 }
 
 // NewRouter creates a new router instance
@@ -25,56 +41,321 @@ func NewRouter(app *App) *Router {
 	return &Router{
 		app:              app,
 		lastToolCallTime: make(map[string]time.Time),
+		embeddingCache:   make(map[string][]float64),
 	}
 }
 
-// NeedsTools is the "Router Agent". It asks the LLM if the user's query
-// requires tool usage.
-func (r *Router) NeedsTools(userQuery string) (bool, error) {
-	wailsruntime.LogInfof(r.app.ctx, "Router Agent: Checking if query needs tools: \"%s\"", userQuery)
+// RouterDecision is the outcome of routing a user query.
+type RouterDecision struct {
+	NeedsTools bool     `json:"needs_tools"`
+	Tools      []string `json:"tools,omitempty"` // Tools the agent may use; empty means all tools
+	Reason     string   `json:"reason,omitempty"`
+	Mode       string   `json:"mode"`
+}
+
+// Router modes recorded when the router did not decide itself.
+const (
+	RouterModeUser = "user" // The user overrode the router for a message
+	RouterModeNone = "none" // No MCP server was connected, so no router was asked
+)
 
-	// If no clients are connected, no tools are available.
-	if len(r.app.mcpClients) == 0 {
-		wailsruntime.LogInfo(r.app.ctx, "Router Agent: No MCP clients connected. Skipping tool check.")
-		return false, nil
+// hasMcpServers reports whether an MCP server other than the built-in tools is
+// connected. The built-in tools alone do not justify routing every message
+// through the LLM; they are still offered when the user forces tools.
+func (r *Router) hasMcpServers() bool {
+	r.app.mu.Lock()
+	defer r.app.mu.Unlock()
+	for name := range r.app.mcpClients {
+		if name != nativetools.ServerName {
+			return true
+		}
 	}
+	return false
+}
 
-	// Construct the prompt for the Router Agent
-	prompt := fmt.Sprintf("You are a dispatcher. Your only job is to decide if a user's request needs access to external tools to be answered. Respond with only 'yes' or 'no'. User Request: \"%s\"", userQuery)
+// Route decides whether a query needs tools using the configured router mode.
+// history holds the conversation before the query. If the embedding router
+// fails (e.g. the server was not started with --embedding), it falls back to
+// asking the LLM.
+func (r *Router) Route(userQuery string, history []ChatMessage) (RouterDecision, error) {
+	if !r.hasMcpServers() {
+		r.app.logInfo("Router Agent: No MCP clients connected. Skipping tool check.")
+		return RouterDecision{Mode: RouterModeNone, Reason: "no MCP clients connected"}, nil
+	}
 
-	// Create a minimal message list for this check
-	messages := []ChatMessage{
-		{Role: "user", Content: prompt},
+	if r.app.config.RouterMode == RouterModeEmbedding {
+		decision, err := r.routeByEmbedding(userQuery)
+		if err == nil {
+			return decision, nil
+		}
+		r.app.logErrorf("Router Agent: Embedding routing failed, falling back to LLM: %v", err)
+	}
+
+	return r.NeedsTools(userQuery, history)
+}
+
+// routeByEmbedding compares the query against cached embeddings of each tool's
+// description and example utterances, and selects the top-k tools whose best
+// similarity reaches the configured threshold.
+func (r *Router) routeByEmbedding(userQuery string) (RouterDecision, error) {
+	tools := r.availableTools()
+	if len(tools) == 0 {
+		return RouterDecision{Mode: RouterModeEmbedding, Reason: "no tools available"}, nil
+	}
+
+	toolTexts := make(map[string][]string, len(tools))
+	var texts []string
+	for _, tool := range tools {
+		descriptionText := tool.Name
+		if tool.Description != "" {
+			descriptionText += ": " + tool.Description
+		}
+		toolTexts[tool.Name] = append([]string{descriptionText}, r.app.config.RouterExamples[tool.Name]...)
+		texts = append(texts, toolTexts[tool.Name]...)
 	}
 
-	// Make a non-streaming call to the LLM
-	responseContent, err := r.app.makeLLMRequest(messages, false, nil)
+	vectors, err := r.embedCached(texts)
+	if err != nil {
+		return RouterDecision{}, err
+	}
+	queryVectors, err := r.app.embeddingClient().Embed([]string{userQuery})
 	if err != nil {
-		wailsruntime.LogErrorf(r.app.ctx, "Router Agent: Error making LLM request: %v", err)
-		return false, err
+		return RouterDecision{}, err
 	}
+	queryVector := queryVectors[0]
 
-	// Check the response
-	decision := strings.TrimSpace(strings.ToLower(responseContent.Content))
-	wailsruntime.LogInfof(r.app.ctx, "Router Agent: Decision received: \"%s\"", decision)
-	return decision == "yes", nil
+	type toolScore struct {
+		name  string
+		score float64
+	}
+	var scores []toolScore
+	for name, textsForTool := range toolTexts {
+		best := -1.0
+		for _, text := range textsForTool {
+			if similarity := cosineSimilarity(queryVector, vectors[text]); similarity > best {
+				best = similarity
+			}
+		}
+		scores = append(scores, toolScore{name: name, score: best})
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].score > scores[j].score })
+
+	threshold := r.app.config.RouterSimilarityThreshold
+	if threshold <= 0 {
+		threshold = defaultRouterSimilarityThreshold
+	}
+	topK := r.app.config.RouterTopK
+	if topK <= 0 {
+		topK = defaultRouterTopK
+	}
+
+	decision := RouterDecision{Mode: RouterModeEmbedding}
+	for _, s := range scores {
+		if s.score < threshold || len(decision.Tools) >= topK {
+			break
+		}
+		decision.Tools = append(decision.Tools, s.name)
+	}
+	decision.NeedsTools = len(decision.Tools) > 0
+	decision.Reason = fmt.Sprintf("best match %s (similarity %.2f, threshold %.2f)", scores[0].name, scores[0].score, threshold)
+
+	r.app.logInfof("Router Agent: Embedding decision: %+v", decision)
+	return decision, nil
 }
 
-// GetToolManifestSchema retrieves all available tools and formats them into a JSON Schema.
-func (r *Router) GetToolManifestSchema() (map[string]interface{}, error) {
-	var toolNames []string
+// embedCached returns embeddings for the given texts, requesting only those
+// that are not cached yet.
+func (r *Router) embedCached(texts []string) (map[string][]float64, error) {
+	client := r.app.embeddingClient()
+	vectors := make(map[string][]float64, len(texts))
+
+	r.cacheMu.Lock()
+	var missing []string
+	for _, text := range texts {
+		if vector, ok := r.embeddingCache[client.BaseURL+"\x00"+text]; ok {
+			vectors[text] = vector
+		} else if _, queued := vectors[text]; !queued {
+			vectors[text] = nil
+			missing = append(missing, text)
+		}
+	}
+	r.cacheMu.Unlock()
+
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+	embedded, err := client.Embed(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	for i, text := range missing {
+		vectors[text] = embedded[i]
+		r.embeddingCache[client.BaseURL+"\x00"+text] = embedded[i]
+	}
+	return vectors, nil
+}
+
+// availableTools lists the tools of every connected MCP server.
+func (r *Router) availableTools() []mcp.Tool {
+	var tools []mcp.Tool
 	for serverName, client := range r.app.mcpClients {
 		if client == nil {
 			continue
 		}
-		tools, err := client.ListTools(context.Background())
+		serverTools, err := client.ListTools(context.Background())
 		if err != nil {
-			wailsruntime.LogErrorf(r.app.ctx, "Error listing tools for server '%s': %v", serverName, err)
+			r.app.logErrorf("Error listing tools for server '%s': %v", serverName, err)
 			continue
 		}
-		for _, tool := range tools {
-			toolNames = append(toolNames, tool.Name)
+		tools = append(tools, serverTools...)
+	}
+	return tools
+}
+
+// toolAllowed reports whether a tool is in the allowed list. An empty list allows every tool.
+func toolAllowed(allowedTools []string, name string) bool {
+	if len(allowedTools) == 0 {
+		return true
+	}
+	for _, allowed := range allowedTools {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsTools is the "Router Agent". It asks the LLM whether the user's query
+// requires tool usage. The answer is constrained to a small JSON object so that
+// replies like "Yes." or a think block cannot be misread, and the last few
+// turns of the conversation are included so follow-ups like "do it again" can
+// be routed correctly. Route only calls it with MCP servers connected.
+func (r *Router) NeedsTools(userQuery string, history []ChatMessage) (RouterDecision, error) {
+	r.app.logInfof("Router Agent: Checking if query needs tools: \"%s\"", userQuery)
+
+	var toolNames []string
+	var toolList strings.Builder
+	for _, tool := range r.availableTools() {
+		toolNames = append(toolNames, tool.Name)
+		toolList.WriteString(fmt.Sprintf("- %s: %s\n", tool.Name, tool.Description))
+	}
+
+	// Construct the prompt for the Router Agent
+	var prompt strings.Builder
+	prompt.WriteString("You are a dispatcher. Your only job is to decide if a user's request needs access to external tools to be answered.\n\n")
+	prompt.WriteString("Available tools:\n")
+	prompt.WriteString(toolList.String())
+	if recent := formatRecentTurns(history, routerHistoryMessages); recent != "" {
+		prompt.WriteString("\nRecent conversation:\n")
+		prompt.WriteString(recent)
+	}
+	prompt.WriteString(fmt.Sprintf("\nUser Request: \"%s\"\n\n", userQuery))
+	prompt.WriteString(`Respond with a JSON object: {"needs_tools": true or false, "tools": [names of the tools that would help], "reason": "one short sentence"}.`)
+
+	// Create a minimal message list for this check
+	messages := []ChatMessage{
+		{Role: "user", Content: prompt.String()},
+	}
+
+	// Make a non-streaming call to the LLM, constrained to the decision schema
+	responseFormat := &ResponseFormat{Type: "json_object", Schema: routerDecisionSchema(toolNames)}
+	responseContent, err := r.app.makeLLMRequest(messages, false, responseFormat, r.app.modelSampling())
+	if err != nil {
+		r.app.logErrorf("Router Agent: Error making LLM request: %v", err)
+		return RouterDecision{}, err
+	}
+
+	// Check the response
+	decision := parseRouterDecision(responseContent.Content, toolNames)
+	r.app.logInfof("Router Agent: Decision received: %+v", decision)
+	return decision, nil
+}
+
+// routerHistoryMessages is how many earlier messages the LLM router sees.
+const routerHistoryMessages = 4
+
+// routerHistoryMessageChars caps each earlier message in the router prompt.
+const routerHistoryMessageChars = 500
+
+// formatRecentTurns renders the last n messages as "role: content" lines.
+func formatRecentTurns(history []ChatMessage, n int) string {
+	if len(history) > n {
+		history = history[len(history)-n:]
+	}
+	var b strings.Builder
+	for _, msg := range history {
+		content := strings.TrimSpace(stripThinkTags(msg.Content))
+		if runes := []rune(content); len(runes) > routerHistoryMessageChars {
+			content = string(runes[:routerHistoryMessageChars]) + "..."
+		}
+		b.WriteString(fmt.Sprintf("%s: %s\n", msg.Role, content))
+	}
+	return b.String()
+}
+
+// routerDecisionSchema is the JSON Schema the LLM router's reply is constrained to.
+func routerDecisionSchema(toolNames []string) map[string]interface{} {
+	toolsItems := map[string]interface{}{"type": "string"}
+	if len(toolNames) > 0 {
+		toolsItems["enum"] = toolNames
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"needs_tools": map[string]interface{}{"type": "boolean"},
+			"tools": map[string]interface{}{
+				"type":  "array",
+				"items": toolsItems,
+			},
+			"reason": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"needs_tools", "tools", "reason"},
+	}
+}
+
+// parseRouterDecision reads the LLM router's reply. If the reply is not the
+// expected JSON (e.g. the server ignored the schema), a leading yes/no is
+// accepted as a fallback. Unknown tool names are dropped.
+func parseRouterDecision(content string, toolNames []string) RouterDecision {
+	text := strings.TrimSpace(stripThinkTags(harmonyVisibleText(content)))
+	decision := RouterDecision{Mode: RouterModeLLM}
+
+	var parsed RouterDecision
+	firstBrace := strings.Index(text, "{")
+	lastBrace := strings.LastIndex(text, "}")
+	if firstBrace != -1 && lastBrace > firstBrace && json.Unmarshal([]byte(text[firstBrace:lastBrace+1]), &parsed) == nil {
+		decision.NeedsTools = parsed.NeedsTools
+		decision.Reason = parsed.Reason
+		for _, name := range parsed.Tools {
+			for _, known := range toolNames {
+				if name == known {
+					decision.Tools = append(decision.Tools, name)
+					break
+				}
+			}
 		}
+		return decision
+	}
+
+	answer := strings.ToLower(text)
+	decision.NeedsTools = strings.HasPrefix(answer, "yes")
+	decision.Reason = fmt.Sprintf("unstructured reply: %q", text)
+	return decision
+}
+
+// GetToolManifestSchema formats the selected tools into a JSON Schema. If
+// tools were left out of the selection, more_tools is offered as well, and
+// read_more once a result has been truncated.
+func (r *Router) GetToolManifestSchema(selection *ToolSelection) (map[string]interface{}, error) {
+	toolNames := selection.Names()
+	if selection.Remaining > 0 {
+		toolNames = append(toolNames, moreToolsName)
+	}
+	if selection.Paging {
+		toolNames = append(toolNames, readMoreName)
 	}
 
 	schema := map[string]interface{}{
@@ -94,41 +375,37 @@ func (r *Router) GetToolManifestSchema() (map[string]interface{}, error) {
 	return schema, nil
 }
 
-// GetToolManifestText retrieves all available tools and formats them into a string for the system prompt.
-func (r *Router) GetToolManifestText() (string, error) {
+// GetToolManifestText formats the selected tools into a string for the system prompt.
+// Arguments are listed in a compact one-line form rather than as full JSON schemas.
+func (r *Router) GetToolManifestText(selection *ToolSelection) (string, error) {
 	var manifestBuilder strings.Builder
 	manifestBuilder.WriteString("You have access to the following tools. To use a tool, you must respond with a JSON object with 'tool_name' and 'arguments' keys.\n\n")
 	manifestBuilder.WriteString("Available Tools:\n")
 
-	for serverName, client := range r.app.mcpClients {
-		if client == nil {
-			continue
-		}
-		tools, err := client.ListTools(context.Background())
-		if err != nil {
-			wailsruntime.LogErrorf(r.app.ctx, "Error listing tools for server '%s': %v", serverName, err)
-			continue
-		}
-
-		for _, tool := range tools {
-			manifestBuilder.WriteString(fmt.Sprintf("- Tool: %s\n", tool.Name))
-			manifestBuilder.WriteString(fmt.Sprintf("  Description: %s\n", tool.Description))
-			// Attempt to add argument details from the InputSchema
-			schemaBytes, err := json.MarshalIndent(tool.InputSchema, "  ", "  ")
-			if err == nil {
-				// Add the schema to the prompt only if it's not an empty object
-				if string(schemaBytes) != "{}" {
-					manifestBuilder.WriteString(fmt.Sprintf("  Arguments Schema:\n  %s\n", string(schemaBytes)))
-				}
-			}
+	for _, tool := range selection.Offered {
+		manifestBuilder.WriteString(fmt.Sprintf("- %s: %s\n", tool.Name, truncateDescription(tool.Description)))
+		if args := compactToolArguments(tool); args != "" {
+			manifestBuilder.WriteString(fmt.Sprintf("  Arguments: %s\n", args))
 		}
 	}
+	if selection.Remaining > 0 {
+		manifestBuilder.WriteString(moreToolsManifestEntry(selection.Remaining))
+	}
+	if selection.Paging {
+		manifestBuilder.WriteString(readMoreManifestEntry)
+	}
 
 	return manifestBuilder.String(), nil
 }
 
+// moreToolsManifestEntry describes the more_tools pseudo-tool.
+func moreToolsManifestEntry(remaining int) string {
+	return fmt.Sprintf("- %s: None of the tools above fit? Call this to see more (%d not shown).\n  Arguments: query (string): what the tool you need should do\n", moreToolsName, remaining)
+}
+
 // ToolCall represents the structure of a tool call from the LLM.
 type ToolCall struct {
+	ID        string                 `json:"id,omitempty"` // Assigned by the Tool-Using Agent when it runs the call
 	ToolName  string                 `json:"tool_name"`
 	Arguments map[string]interface{} `json:"arguments"`
 }
@@ -144,14 +421,17 @@ func (r *Router) ExecuteToolCall(toolCallJSON string) (*mcp.CallToolResult, erro
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	// The cooldown applies to identical calls only; the same tool with
+	// different arguments may run right away.
+	signature := toolCallSignature(toolCall)
 	cooldown := time.Duration(r.app.config.ToolCallCooldown) * time.Second
-	if lastCall, found := r.lastToolCallTime[toolCall.ToolName]; found {
+	if lastCall, found := r.lastToolCallTime[signature]; found {
 		if time.Since(lastCall) < cooldown {
-			return nil, fmt.Errorf("tool '%s' is on cooldown. Please wait", toolCall.ToolName)
+			return nil, fmt.Errorf("tool '%s' was just called with the same arguments and is on cooldown. Use the earlier result or change the arguments", toolCall.ToolName)
 		}
 	}
 
-	wailsruntime.LogInfof(r.app.ctx, "Executing tool call: %s with args: %+v", toolCall.ToolName, toolCall.Arguments)
+	r.app.logInfof("Executing tool call: %s with args: %+v", toolCall.ToolName, toolCall.Arguments)
 
 	// Find the client that has the tool and execute it
 	for serverName, mcpClient := range r.app.mcpClients {
@@ -161,7 +441,7 @@ func (r *Router) ExecuteToolCall(toolCallJSON string) (*mcp.CallToolResult, erro
 
 		tools, err := mcpClient.ListTools(context.Background())
 		if err != nil {
-			wailsruntime.LogErrorf(r.app.ctx, "Failed to list tools for %s: %v", serverName, err)
+			r.app.logErrorf("Failed to list tools for %s: %v", serverName, err)
 			continue
 		}
 
@@ -171,7 +451,7 @@ func (r *Router) ExecuteToolCall(toolCallJSON string) (*mcp.CallToolResult, erro
 				if err != nil {
 					return nil, fmt.Errorf("failed to call tool %s: %w", tool.Name, err)
 				}
-				r.lastToolCallTime[toolCall.ToolName] = time.Now() // Update last call time
+				r.lastToolCallTime[signature] = time.Now() // Update last call time
 				return result, nil
 			}
 		}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"local-llm-chat/nativetools"
+)
+
+func TestParseRouterDecision(t *testing.T) {
+	toolNames := []string{"get_weather", "search"}
+	tests := []struct {
+		name    string
+		content string
+		want    RouterDecision
+	}{
+		{
+			name:    "json",
+			content: `{"needs_tools": true, "tools": ["get_weather"], "reason": "asks for the weather"}`,
+			want:    RouterDecision{NeedsTools: true, Tools: []string{"get_weather"}, Reason: "asks for the weather", Mode: RouterModeLLM},
+		},
+		{
+			name:    "json without tools",
+			content: `{"needs_tools": false, "tools": [], "reason": "small talk"}`,
+			want:    RouterDecision{Reason: "small talk", Mode: RouterModeLLM},
+		},
+		{
+			name:    "unknown tools dropped",
+			content: `{"needs_tools": true, "tools": ["search", "rm_rf", "get_weather"], "reason": ""}`,
+			want:    RouterDecision{NeedsTools: true, Tools: []string{"search", "get_weather"}, Mode: RouterModeLLM},
+		},
+		{
+			name:    "json after a think block and prose",
+			content: "<think>{\"needs_tools\": false}</think>Sure: {\"needs_tools\": true, \"tools\": [], \"reason\": \"lookup\"} done",
+			want:    RouterDecision{NeedsTools: true, Reason: "lookup", Mode: RouterModeLLM},
+		},
+		{
+			name:    "harmony",
+			content: "<|channel|>analysis<|message|>No tools.<|end|><|start|>assistant<|channel|>final<|message|>{\"needs_tools\": false, \"tools\": [], \"reason\": \"greeting\"}",
+			want:    RouterDecision{Reason: "greeting", Mode: RouterModeLLM},
+		},
+		{
+			name:    "plain yes",
+			content: "Yes. The user wants the weather.",
+			want:    RouterDecision{NeedsTools: true, Reason: `unstructured reply: "Yes. The user wants the weather."`, Mode: RouterModeLLM},
+		},
+		{
+			name:    "plain no",
+			content: "<think>hmm</think> no",
+			want:    RouterDecision{Reason: `unstructured reply: "no"`, Mode: RouterModeLLM},
+		},
+		{
+			name:    "broken json",
+			content: `{"needs_tools": tru`,
+			want:    RouterDecision{Reason: `unstructured reply: "{\"needs_tools\": tru"`, Mode: RouterModeLLM},
+		},
+		{
+			name:    "empty",
+			content: "",
+			want:    RouterDecision{Reason: `unstructured reply: ""`, Mode: RouterModeLLM},
+		},
+	}
+	for _, test := range tests {
+		if got := parseRouterDecision(test.content, toolNames); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRouteWithoutMcpServers(t *testing.T) {
+	app := NewApp()
+	app.config.RouterMode = ""
+	router := NewRouter(app)
+	for _, servers := range [][]string{nil, {nativetools.ServerName}} {
+		for _, name := range servers {
+			app.mcpClients[name] = nil
+		}
+		decision, err := router.Route("What is the weather?", nil)
+		if err != nil || decision.NeedsTools || decision.Mode != RouterModeNone {
+			t.Errorf("with servers %v: got %+v, %v; want no tools in mode %q", servers, decision, err, RouterModeNone)
+		}
+	}
+}
+
+func TestFormatRecentTurns(t *testing.T) {
+	history := []ChatMessage{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "<think>hidden</think> second"},
+		{Role: "user", Content: strings.Repeat("ä", routerHistoryMessageChars+10)},
+	}
+	got := formatRecentTurns(history, 2)
+	want := "assistant: second\nuser: " + strings.Repeat("ä", routerHistoryMessageChars) + "...\n"
+	if got != want {
+		t.Errorf("formatRecentTurns = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SamplingSettings holds the sampling parameters sent to llama-server with every
+// completion request. A nil field means "not set", so the server default (or the
+// value from a lower-priority layer) is used instead.
+type SamplingSettings struct {
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+	MaxTokens     *int     `json:"max_tokens,omitempty"`
+}
+
+// Merge returns a copy of s with every field that is set in override replacing
+// the corresponding value in s.
+func (s SamplingSettings) Merge(override *SamplingSettings) SamplingSettings {
+	if override == nil {
+		return s
+	}
+	merged := s
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		merged.TopK = override.TopK
+	}
+	if override.MinP != nil {
+		merged.MinP = override.MinP
+	}
+	if override.RepeatPenalty != nil {
+		merged.RepeatPenalty = override.RepeatPenalty
+	}
+	if override.Seed != nil {
+		merged.Seed = override.Seed
+	}
+	if override.Stop != nil {
+		merged.Stop = override.Stop
+	}
+	if override.MaxTokens != nil {
+		merged.MaxTokens = override.MaxTokens
+	}
+	return merged
+}
+
+// IsEmpty reports whether no sampling parameter is set.
+func (s SamplingSettings) IsEmpty() bool {
+	return s.Temperature == nil && s.TopP == nil && s.TopK == nil && s.MinP == nil &&
+		s.RepeatPenalty == nil && s.Seed == nil && s.Stop == nil && s.MaxTokens == nil
+}
+
+// nPredict returns the value for llama-server's n_predict field. -1 means
+// "generate until the model stops".
+func (s SamplingSettings) nPredict() int {
+	if s.MaxTokens != nil && *s.MaxTokens > 0 {
+		return *s.MaxTokens
+	}
+	return -1
+}
+
+// modelSampling returns the sampling defaults stored for the selected model.
+func (a *App) modelSampling() SamplingSettings {
+	if settings, ok := a.config.ModelSettings[a.config.SelectedModel]; ok && settings.Sampling != nil {
+		return *settings.Sampling
+	}
+	return SamplingSettings{}
+}
+
+// samplingForSession returns the effective sampling parameters for a session:
+// the selected model's defaults with the session's overrides applied on top.
+func (a *App) samplingForSession(sessionID int64) SamplingSettings {
+	sampling := a.modelSampling()
+	conv, ok := a.getConversation(sessionID)
+	if !ok {
+		return sampling
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+	return sampling.Merge(conv.sampling)
+}
+
+// GetSessionSampling returns the sampling overrides stored for a chat session as a JSON string.
+func (a *App) GetSessionSampling(sessionID int64) (string, error) {
+	session, err := a.db.GetChatSession(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if session.Sampling == nil {
+		return "{}", nil
+	}
+	samplingBytes, err := json.Marshal(session.Sampling)
+	if err != nil {
+		return "", err
+	}
+	return string(samplingBytes), nil
+}
+
+// UpdateSessionSampling replaces the sampling overrides for a chat session.
+// An empty string or "{}" clears the overrides so the model defaults apply.
+func (a *App) UpdateSessionSampling(sessionID int64, samplingJSON string) error {
+	var sampling *SamplingSettings
+	if samplingJSON != "" {
+		var parsed SamplingSettings
+		if err := json.Unmarshal([]byte(samplingJSON), &parsed); err != nil {
+			return fmt.Errorf("invalid sampling settings: %w", err)
+		}
+		if !parsed.IsEmpty() {
+			sampling = &parsed
+		}
+	}
+
+	if err := a.db.UpdateChatSessionSampling(sessionID, sampling); err != nil {
+		a.logErrorf("UpdateSessionSampling: Error updating sampling for session %d: %s", sessionID, err.Error())
+		return err
+	}
+
+	if conv, ok := a.getConversation(sessionID); ok {
+		conv.mu.Lock()
+		conv.sampling = sampling
+		conv.mu.Unlock()
+	}
+	return nil
+}
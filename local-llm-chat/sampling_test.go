@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func float64Ptr(v float64) *float64 { return &v }
+func intPtr(v int) *int             { return &v }
+
+func TestSamplingMerge(t *testing.T) {
+	model := SamplingSettings{Temperature: float64Ptr(0.7), TopK: intPtr(40), Stop: []string{"</s>"}, MaxTokens: intPtr(512)}
+	session := &SamplingSettings{Temperature: float64Ptr(0.2), Seed: intPtr(7), Stop: []string{}}
+
+	merged := model.Merge(session)
+	want := SamplingSettings{Temperature: float64Ptr(0.2), TopK: intPtr(40), Seed: intPtr(7), Stop: []string{}, MaxTokens: intPtr(512)}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("Merge = %+v, want %+v", merged, want)
+	}
+	if *model.Temperature != 0.7 {
+		t.Error("Merge changed the settings it was called on")
+	}
+	if got := model.Merge(nil); !reflect.DeepEqual(got, model) {
+		t.Errorf("Merge(nil) = %+v, want %+v", got, model)
+	}
+	if !(SamplingSettings{}).IsEmpty() || merged.IsEmpty() {
+		t.Error("IsEmpty is wrong")
+	}
+}
+
+func TestNPredict(t *testing.T) {
+	tests := []struct {
+		maxTokens *int
+		want      int
+	}{
+		{nil, -1},
+		{intPtr(0), -1},
+		{intPtr(-5), -1},
+		{intPtr(256), 256},
+	}
+	for _, test := range tests {
+		if got := (SamplingSettings{MaxTokens: test.maxTokens}).nPredict(); got != test.want {
+			t.Errorf("nPredict with MaxTokens %v = %d, want %d", test.maxTokens, got, test.want)
+		}
+	}
+}
+
+// TestSamplingForSession checks the layers: the session's overrides win over
+// the selected model's defaults, and what neither sets is left to the server.
+func TestSamplingForSession(t *testing.T) {
+	app := NewApp()
+	app.config.SelectedModel = "model.gguf"
+	app.config.ModelSettings = map[string]ModelSettings{
+		"model.gguf": {Sampling: &SamplingSettings{Temperature: float64Ptr(0.7), MaxTokens: intPtr(1024)}},
+		"other.gguf": {Sampling: &SamplingSettings{TopP: float64Ptr(0.5)}},
+	}
+	app.conversations[1] = &Conversation{sampling: &SamplingSettings{Temperature: float64Ptr(0.1)}}
+	app.conversations[2] = &Conversation{}
+
+	session := app.samplingForSession(1)
+	if *session.Temperature != 0.1 || *session.MaxTokens != 1024 || session.TopP != nil {
+		t.Errorf("session 1 sampling = %+v", session)
+	}
+	if session.nPredict() != 1024 {
+		t.Errorf("nPredict = %d, want the model's 1024", session.nPredict())
+	}
+	if model := app.samplingForSession(2); *model.Temperature != 0.7 {
+		t.Errorf("session without overrides got %+v, want the model's defaults", model)
+	}
+	if unloaded := app.samplingForSession(3); *unloaded.Temperature != 0.7 {
+		t.Errorf("unloaded session got %+v, want the model's defaults", unloaded)
+	}
+
+	app.config.SelectedModel = "unknown.gguf"
+	if server := app.samplingForSession(2); !server.IsEmpty() || server.nPredict() != -1 {
+		t.Errorf("model without settings got %+v, want the server defaults", server)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import "strings"
+
+// ReasoningTagPair marks the start and end of a reasoning block that a model
+// emits inline in its content.
+type ReasoningTagPair struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// defaultReasoningTags are used for models without reasoning_tags configured.
+var defaultReasoningTags = []ReasoningTagPair{
+	{Open: "<think>", Close: "</think>"},
+	{Open: "<reasoning>", Close: "</reasoning>"},
+}
+
+// reasoningTagsForModel returns the tag pairs configured for a model, falling
+// back to the common <think>/<reasoning> tags.
+func (a *App) reasoningTagsForModel(modelPath string) []ReasoningTagPair {
+	if settings, ok := a.config.ModelSettings[modelPath]; ok && len(settings.ReasoningTags) > 0 {
+		return settings.ReasoningTags
+	}
+	return defaultReasoningTags
+}
+
+// ThinkTagParser incrementally splits streamed content into answer text and
+// reasoning text. Tags may be split across chunks: any trailing text that
+// could be the beginning of a tag is held back until the next chunk decides it.
+type ThinkTagParser struct {
+	tags    []ReasoningTagPair
+	pending string
+	active  int // index into tags of the open reasoning block, or -1
+}
+
+// NewThinkTagParser creates a parser for the given tag pairs. Pairs with an
+// empty open or close tag are ignored.
+func NewThinkTagParser(tags []ReasoningTagPair) *ThinkTagParser {
+	var valid []ReasoningTagPair
+	for _, tag := range tags {
+		if tag.Open != "" && tag.Close != "" {
+			valid = append(valid, tag)
+		}
+	}
+	return &ThinkTagParser{tags: valid, active: -1}
+}
+
+// Feed consumes the next chunk and returns the answer and reasoning text that
+// can be emitted so far.
+func (p *ThinkTagParser) Feed(chunk string) (content, reasoning string) {
+	p.pending += chunk
+	var contentBuilder, reasoningBuilder strings.Builder
+
+	for p.pending != "" {
+		if p.active < 0 {
+			index, tag := p.findOpenTag()
+			if index < 0 {
+				safe := len(p.pending) - p.partialTagSuffix(p.openTags())
+				contentBuilder.WriteString(p.pending[:safe])
+				p.pending = p.pending[safe:]
+				break
+			}
+			contentBuilder.WriteString(p.pending[:index])
+			p.pending = p.pending[index+len(p.tags[tag].Open):]
+			p.active = tag
+			continue
+		}
+
+		closeTag := p.tags[p.active].Close
+		index := strings.Index(p.pending, closeTag)
+		if index < 0 {
+			safe := len(p.pending) - p.partialTagSuffix([]string{closeTag})
+			reasoningBuilder.WriteString(p.pending[:safe])
+			p.pending = p.pending[safe:]
+			break
+		}
+		reasoningBuilder.WriteString(p.pending[:index])
+		p.pending = p.pending[index+len(closeTag):]
+		p.active = -1
+	}
+
+	return contentBuilder.String(), reasoningBuilder.String()
+}
+
+// Flush returns whatever text is still held back once the stream has ended.
+// An unterminated reasoning block is returned as reasoning.
+func (p *ThinkTagParser) Flush() (content, reasoning string) {
+	rest := p.pending
+	p.pending = ""
+	if p.active >= 0 {
+		p.active = -1
+		return "", rest
+	}
+	return rest, ""
+}
+
+func (p *ThinkTagParser) openTags() []string {
+	open := make([]string, len(p.tags))
+	for i, tag := range p.tags {
+		open[i] = tag.Open
+	}
+	return open
+}
+
+// findOpenTag returns the position and index of the earliest open tag in the
+// pending text, or -1 if none is present.
+func (p *ThinkTagParser) findOpenTag() (int, int) {
+	best, bestTag := -1, -1
+	for i, tag := range p.tags {
+		index := strings.Index(p.pending, tag.Open)
+		if index >= 0 && (best < 0 || index < best) {
+			best, bestTag = index, i
+		}
+	}
+	return best, bestTag
+}
+
+// partialTagSuffix returns the length of the longest suffix of the pending text
+// that is a proper prefix of one of the given tags.
+func (p *ThinkTagParser) partialTagSuffix(tags []string) int {
+	longest := 0
+	for _, tag := range tags {
+		maxLen := len(tag) - 1
+		if maxLen > len(p.pending) {
+			maxLen = len(p.pending)
+		}
+		for n := maxLen; n > longest; n-- {
+			if strings.HasSuffix(p.pending, tag[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
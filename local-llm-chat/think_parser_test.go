@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+// parseChunks feeds chunks to a parser with the default tags and returns all
+// answer and reasoning text, including what Flush returns.
+func parseChunks(chunks []string) (content, reasoning string) {
+	parser := NewThinkTagParser(defaultReasoningTags)
+	for _, chunk := range chunks {
+		c, r := parser.Feed(chunk)
+		content += c
+		reasoning += r
+	}
+	c, r := parser.Flush()
+	return content + c, reasoning + r
+}
+
+// byteChunks splits text into chunks of one byte.
+func byteChunks(text string) []string {
+	chunks := make([]string, len(text))
+	for i := range text {
+		chunks[i] = text[i : i+1]
+	}
+	return chunks
+}
+
+func TestThinkTagParser(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        []string
+		wantContent   string
+		wantReasoning string
+	}{
+		{"no tags", []string{"Plain ", "answer."}, "Plain answer.", ""},
+		{"whole block", []string{"<think>Hmm.</think>Answer."}, "Answer.", "Hmm."},
+		{"open tag split", []string{"<thi", "nk>Hmm.</think>Answer."}, "Answer.", "Hmm."},
+		{"close tag split", []string{"<think>Hmm.</", "thi", "nk>Answer."}, "Answer.", "Hmm."},
+		{"both tags split", []string{"Before <", "think", ">a", "b</think", ">After"}, "Before After", "ab"},
+		{"several blocks", []string{"<think>one</think>A<reasoning>two</reason", "ing>B<think>three</think>"}, "AB", "onetwothree"},
+		{"unterminated block", []string{"<think>still thinking", " when the stream ends"}, "", "still thinking when the stream ends"},
+		{"unterminated close prefix", []string{"<think>cut off </thi"}, "", "cut off </thi"},
+		{"tag prefix that is not a tag", []string{"a <thin", "g> and x < y"}, "a <thing> and x < y", ""},
+		{"tag prefix at the end", []string{"less than <"}, "less than <", ""},
+		{"close tag of another pair", []string{"<think>a</reasoning>b</think>c"}, "c", "a</reasoning>b"},
+	}
+	for _, test := range tests {
+		content, reasoning := parseChunks(test.chunks)
+		if content != test.wantContent || reasoning != test.wantReasoning {
+			t.Errorf("%s: got content %q, reasoning %q; want %q, %q", test.name, content, reasoning, test.wantContent, test.wantReasoning)
+		}
+		var whole string
+		for _, chunk := range test.chunks {
+			whole += chunk
+		}
+		content, reasoning = parseChunks(byteChunks(whole))
+		if content != test.wantContent || reasoning != test.wantReasoning {
+			t.Errorf("%s, byte by byte: got content %q, reasoning %q; want %q, %q", test.name, content, reasoning, test.wantContent, test.wantReasoning)
+		}
+	}
+}
+
+func TestThinkTagParserHoldsBackOnlyTagPrefixes(t *testing.T) {
+	parser := NewThinkTagParser(defaultReasoningTags)
+	if content, _ := parser.Feed("Answer <th"); content != "Answer " {
+		t.Errorf("content = %q, want the text before the possible tag", content)
+	}
+	if content, _ := parser.Feed("e end"); content != "<the end" {
+		t.Errorf("content = %q, want the held back text once it is no tag", content)
+	}
+	parser.Feed("<think>x")
+	if _, reasoning := parser.Feed("y</thin"); reasoning != "y" {
+		t.Errorf("reasoning = %q, want the text before the possible close tag", reasoning)
+	}
+}
+
+func TestThinkTagParserCustomTags(t *testing.T) {
+	parser := NewThinkTagParser([]ReasoningTagPair{{Open: "[R]", Close: "[/R]"}, {Open: "", Close: "x"}})
+	content, reasoning := parser.Feed("[R]why[/R]<think>not a tag</think>")
+	if content != "<think>not a tag</think>" || reasoning != "why" {
+		t.Errorf("got content %q, reasoning %q", content, reasoning)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"encoding/base64"
 	_ "embed"
 	"log"
@@ -9,9 +8,11 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
+
+	"local-llm-chat/events"
 
 	"github.com/pkoukk/tiktoken-go"
-	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 //go:embed cl100k_base.tiktoken
@@ -49,7 +50,7 @@ func (l *embeddedBpeLoader) LoadTiktokenBpe(tiktokenBpeFile string) (map[string]
 
 // TokenCounter is responsible for counting tokens and calculating tokens per second.
 type TokenCounter struct {
-	ctx           context.Context
+	bus           *events.Bus
 	tkm           *tiktoken.Tiktoken
 	totalTokens   int
 	startTime     time.Time
@@ -58,7 +59,7 @@ type TokenCounter struct {
 }
 
 // NewTokenCounter creates a new TokenCounter.
-func NewTokenCounter(ctx context.Context) *TokenCounter {
+func NewTokenCounter(bus *events.Bus) *TokenCounter {
 	// Set the custom BPE loader that uses the embedded file.
 	tiktoken.SetBpeLoader(&embeddedBpeLoader{})
 
@@ -69,7 +70,7 @@ func NewTokenCounter(ctx context.Context) *TokenCounter {
 	}
 
 	return &TokenCounter{
-		ctx:           ctx,
+		bus:           bus,
 		tkm:           tkm,
 		sessionTotals: make(map[int64]int),
 	}
@@ -94,7 +95,7 @@ func (tc *TokenCounter) CountAndMeasure(text string) {
 	elapsed := time.Since(tc.startTime).Seconds()
 	if elapsed > 0 {
 		tps := float64(tc.totalTokens) / elapsed
-		wailsruntime.EventsEmit(tc.ctx, "token-stats", map[string]interface{}{
+		tc.bus.Publish(events.TokenStats, map[string]interface{}{
 			"tps": tps,
 		})
 	}
@@ -105,7 +106,7 @@ func (tc *TokenCounter) UpdateSessionTotal(sessionID int64) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
 	tc.sessionTotals[sessionID] += tc.totalTokens
-	wailsruntime.EventsEmit(tc.ctx, "session-token-total", map[string]interface{}{
+	tc.bus.Publish(events.SessionTokenTotal, map[string]interface{}{
 		"sessionID": sessionID,
 		"total":     tc.sessionTotals[sessionID],
 	})
@@ -117,3 +118,26 @@ func (tc *TokenCounter) GetSessionTotal(sessionID int64) int {
 	defer tc.mu.Unlock()
 	return tc.sessionTotals[sessionID]
 }
+
+// CountTokens returns the number of tokens in text without touching the stream metrics.
+func (tc *TokenCounter) CountTokens(text string) int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return len(tc.tkm.Encode(text, nil, nil))
+}
+
+// TruncateToTokens returns the longest prefix of text that fits in maxTokens
+// tokens, cut at a valid UTF-8 boundary, and the total token count of text.
+func (tc *TokenCounter) TruncateToTokens(text string, maxTokens int) (string, int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tokens := tc.tkm.Encode(text, nil, nil)
+	if len(tokens) <= maxTokens {
+		return text, len(tokens)
+	}
+	prefix := tc.tkm.Decode(tokens[:maxTokens])
+	for len(prefix) > 0 && !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix, len(tokens)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// toolAgentRun holds the state of one Tool-Using Agent run: the tools offered,
+// the prompt built for them and the calls made so far. The chat window and the
+// API server drive it with their own loops.
+type toolAgentRun struct {
+	app             *App
+	sessionID       int64
+	message         string // The user message the run answers
+	useHarmonyTools bool
+	selection       *ToolSelection
+	prompt          string
+	responseFormat  *ResponseFormat
+	loopDetector    *LoopDetector
+	trace           *agentTrace
+}
+
+// toolAgentStep is the outcome of one tool call.
+type toolAgentStep struct {
+	ToolCallID  string   // ID given to the call; its artifacts are linked to it
+	Result      string   // Text fed back to the model
+	Images      []string // Images of the result, for vision models
+	FinalAnswer bool     // The loop strategy ends the run; answer now
+}
+
+// newToolAgentRun selects the tools for a message and builds the agent's
+// prompt. If allowedTools is not empty, those are offered first.
+func (a *App) newToolAgentRun(sessionID int64, message string, allowedTools []string, trace *agentTrace) (*toolAgentRun, error) {
+	// Check if the current model uses the new Harmony (schema-based) tool format.
+	useHarmonyTools := false
+	if settings, ok := a.config.ModelSettings[a.config.SelectedModel]; ok {
+		useHarmonyTools = settings.UseHarmonyTools
+	}
+
+	selection := a.router.SelectTools(message, allowedTools)
+	prompt, responseFormat, err := a.toolAgentPrompt(selection, useHarmonyTools)
+	if err != nil {
+		return nil, err
+	}
+	return &toolAgentRun{
+		app:             a,
+		sessionID:       sessionID,
+		message:         message,
+		useHarmonyTools: useHarmonyTools,
+		selection:       selection,
+		prompt:          prompt,
+		responseFormat:  responseFormat,
+		loopDetector:    NewLoopDetector(),
+		trace:           trace,
+	}, nil
+}
+
+// rebuildPrompt updates the prompt after the selection changed.
+func (r *toolAgentRun) rebuildPrompt() {
+	if prompt, format, err := r.app.toolAgentPrompt(r.selection, r.useHarmonyTools); err != nil {
+		r.app.logErrorf("Tool Agent: Error rebuilding tool manifest: %v", err)
+	} else {
+		r.prompt, r.responseFormat = prompt, format
+	}
+}
+
+// parseResponse splits a completion into the text to keep in history, its
+// reasoning and the tool call it makes, if any.
+func (r *toolAgentRun) parseResponse(response LLMResponse) (content, reasoning, toolCallJSON string) {
+	content = response.Content
+	reasoning = response.ReasoningContent
+
+	if r.useHarmonyTools && containsHarmonyTokens(content) {
+		// Raw Harmony output: the analysis channel is reasoning and a
+		// commentary message addressed to functions.x is the tool call.
+		answer, harmonyReasoning, toolCalls := splitHarmony(ParseHarmony(content))
+		if harmonyReasoning != "" {
+			reasoning = strings.TrimSpace(reasoning + "\n" + harmonyReasoning)
+		}
+		content = answer
+		if len(toolCalls) > 0 {
+			toolCall, err := toolCalls[0].ToolCall()
+			if err != nil {
+				r.app.logErrorf("Tool Agent: Error parsing Harmony tool call: %v", err)
+			} else if callBytes, err := json.Marshal(toolCall); err == nil {
+				toolCallJSON = string(callBytes)
+				// Keep only the call itself in history; analysis is dropped between turns.
+				content = RenderHarmony(toolCalls[:1])
+			}
+		}
+		return content, reasoning, toolCallJSON
+	}
+
+	firstBrace := strings.Index(content, "{")
+	lastBrace := strings.LastIndex(content, "}")
+	if firstBrace != -1 && lastBrace != -1 && lastBrace > firstBrace {
+		toolCallJSON = content[firstBrace : lastBrace+1]
+	}
+	return content, reasoning, toolCallJSON
+}
+
+// executeToolCall runs a tool call, or one of the more_tools and read_more
+// pseudo-tools, and applies the loop strategy if the call repeats itself.
+// messageID is the saved assistant message that made the call; artifacts of
+// the result are linked to it.
+func (r *toolAgentRun) executeToolCall(toolCallJSON string, messageID int64) toolAgentStep {
+	a := r.app
+	a.logInfof("Tool Agent: Detected tool call: %s", toolCallJSON)
+
+	var step toolAgentStep
+	var parsedCall ToolCall
+	parseErr := json.Unmarshal([]byte(toolCallJSON), &parsedCall)
+	parsedCall.ID = newToolCallID()
+	step.ToolCallID = parsedCall.ID
+	if parseErr != nil {
+		a.logErrorf("Tool Agent: Error parsing tool call: %v", parseErr)
+		step.Result = fmt.Sprintf("Error executing tool: the tool call is not valid JSON: %v", parseErr)
+		r.trace.ToolCall(parsedCall, time.Now(), 0, true, parseErr)
+		return step
+	}
+	origin := toolCallOrigin{SessionID: r.sessionID, MessageID: messageID, ID: parsedCall.ID, ToolName: parsedCall.ToolName}
+	loopKind := r.loopDetector.Check(parsedCall)
+	r.loopDetector.Record(parsedCall)
+	loopStrategy := ""
+	if loopKind != "" {
+		loopStrategy = a.loopStrategy(r.loopDetector)
+		a.logWarningf("Tool Agent: Detected %s of %s, applying strategy %q", loopKind, parsedCall.ToolName, loopStrategy)
+		r.trace.Loop(parsedCall, loopKind, loopStrategy)
+	}
+
+	toolStarted := time.Now()
+	if loopStrategy == LoopStrategyBlock || loopStrategy == LoopStrategyFinalAnswer {
+		step.Result = loopMessage(loopKind, parsedCall, true)
+	} else if parsedCall.ToolName == moreToolsName {
+		// Not a real tool: widen the selection and rebuild the manifest.
+		added := a.router.SelectMoreTools(r.selection, moreToolsQuery(parsedCall, r.message))
+		if len(added) == 0 {
+			step.Result = "There are no more tools. Answer with the tools you have, or without tools."
+		} else {
+			step.Result = fmt.Sprintf("These tools are now available: %s", strings.Join(added, ", "))
+			r.rebuildPrompt()
+		}
+		r.trace.ToolCall(parsedCall, toolStarted, len(step.Result), false, nil)
+	} else if parsedCall.ToolName == readMoreName {
+		step.Result = a.readMoreResult(r.sessionID, parsedCall)
+		r.trace.ToolCall(parsedCall, toolStarted, len(step.Result), false, nil)
+	} else if result, err := a.router.ExecuteToolCall(toolCallJSON); err != nil {
+		a.logErrorf("Tool Agent: Error executing tool call: %v", err)
+		step.Result = fmt.Sprintf("Error executing tool: %v", err)
+		r.trace.ToolCall(parsedCall, toolStarted, 0, true, err)
+	} else {
+		if result.IsError {
+			a.logWarningf("Tool Agent: Tool %s reported an error", parsedCall.ToolName)
+		}
+		step.Result, step.Images = a.toolResultText(origin, result)
+		r.trace.ToolCall(parsedCall, toolStarted, len(step.Result), result.IsError, nil)
+		// Oversized results are stored in full and paged in through read_more.
+		var truncated bool
+		step.Result, truncated = a.pageToolResult(origin, step.Result)
+		if truncated && !r.selection.Paging {
+			r.selection.Paging = true
+			r.rebuildPrompt()
+		}
+	}
+	if loopStrategy == LoopStrategyHint {
+		step.Result += "\n\n" + loopMessage(loopKind, parsedCall, false)
+	}
+	step.FinalAnswer = loopStrategy == LoopStrategyFinalAnswer
+	return step
+}
+
+// newToolCallID returns an ID for a tool call, in the style of OpenAI's
+// call IDs. Models prompted by the agent do not give their calls IDs.
+func newToolCallID() string {
+	return "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+}
+
+// maxToolIterations returns how many LLM calls an agent run may make.
+func (a *App) maxToolIterations() int {
+	if a.config.ToolCallIterations <= 0 {
+		return 5 // Default
+	}
+	return a.config.ToolCallIterations
+}
+
+// maxIterationsMessage is the reply when an agent run ends without an answer.
+func maxIterationsMessage(maxIterations int) string {
+	return fmt.Sprintf("The assistant reached the maximum number of tool calls (%d) without providing a final answer. The task has been stopped.", maxIterations)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"local-llm-chat/artifacts"
+)
+
+// readMoreName is the pseudo-tool the Tool-Using Agent calls to page through a
+// tool result that was too large to include in full.
+const readMoreName = "read_more"
+
+// defaultMaxToolResultTokens caps tool results when max_tool_result_tokens is
+// not configured.
+const defaultMaxToolResultTokens = 2000
+
+// maxToolResultTokens returns the result budget for a tool: its entry in
+// tool_result_token_limits, else max_tool_result_tokens, else the default.
+func (a *App) maxToolResultTokens(toolName string) int {
+	if limit, ok := a.config.ToolResultTokenLimits[toolName]; ok && limit > 0 {
+		return limit
+	}
+	if a.config.MaxToolResultTokens > 0 {
+		return a.config.MaxToolResultTokens
+	}
+	return defaultMaxToolResultTokens
+}
+
+// pageToolResult returns a tool result that fits the tool's token budget. An
+// oversized result is stored in full as a document artifact, and the model
+// gets the first page plus a handle for read_more. The second return value
+// reports whether the result was truncated.
+func (a *App) pageToolResult(origin toolCallOrigin, result string) (string, bool) {
+	toolName := origin.ToolName
+	maxTokens := a.maxToolResultTokens(toolName)
+	preview, totalTokens := a.tokenCounter.TruncateToTokens(result, maxTokens)
+	if totalTokens <= maxTokens {
+		return result, false
+	}
+	if a.ArtifactService == nil {
+		return preview + fmt.Sprintf("\n[Result truncated: showing %d of %d tokens. The rest could not be stored.]", maxTokens, totalTokens), true
+	}
+
+	metadata := map[string]interface{}{"mime_type": "text/plain", "source_tool": toolName, "tokens": totalTokens, pagedResultKey: true}
+	name := fmt.Sprintf("%s_result.txt", toolName)
+	artifact, err := a.ArtifactService.AddMessageArtifact(origin.SessionID, origin.MessageID, origin.ID, artifacts.TypeDocument, name, base64.StdEncoding.EncodeToString([]byte(result)), metadata)
+	if err != nil {
+		a.logErrorf("Tool Agent: Error storing oversized result of %s: %v", toolName, err)
+		return preview + fmt.Sprintf("\n[Result truncated: showing %d of %d tokens. The rest could not be stored: %v]", maxTokens, totalTokens, err), true
+	}
+
+	a.logInfof("Tool Agent: Result of %s has %d tokens, stored as artifact %s", toolName, totalTokens, artifact.ID)
+	return preview + fmt.Sprintf("\n[The result has %d tokens; only the first %d are shown.", totalTokens, maxTokens) + pagingNote(artifact.ID, len(preview), len(result)), true
+}
+
+// pagedResultKey marks the metadata of results stored by pageToolResult; only
+// those can be read with read_more.
+const pagedResultKey = "paged_tool_result"
+
+// readMoreResult serves a read_more call of a tool call in a session: the page
+// of a result stored for that session that starts at the given byte offset.
+// Handles of other sessions and of other artifacts are unknown to it.
+func (a *App) readMoreResult(sessionID int64, toolCall ToolCall) string {
+	handle, _ := toolCall.Arguments["handle"].(string)
+	if handle == "" {
+		return "read_more needs a 'handle' argument."
+	}
+	offset := 0
+	switch value := toolCall.Arguments["offset"].(type) {
+	case float64:
+		offset = int(value)
+	case string:
+		offset, _ = strconv.Atoi(value)
+	}
+
+	if a.ArtifactService == nil {
+		return "Stored results are not available: artifact service not initialized."
+	}
+	artifact, err := a.ArtifactService.GetArtifact(handle)
+	if err != nil || artifact.Type != artifacts.TypeDocument || artifact.SessionID != sessionID || artifact.Metadata[pagedResultKey] != true {
+		return fmt.Sprintf("Unknown handle %s.", handle)
+	}
+	content, err := a.ArtifactService.ReadArtifactContent(handle)
+	if err != nil {
+		return fmt.Sprintf("Could not read handle %s: %v", handle, err)
+	}
+	text := string(content)
+	if offset < 0 || offset >= len(text) {
+		return fmt.Sprintf("Offset %d is past the end of handle %s (%d bytes).", offset, handle, len(text))
+	}
+	// Never start in the middle of a multi-byte character.
+	for offset > 0 && !utf8.RuneStart(text[offset]) {
+		offset--
+	}
+
+	maxTokens := a.maxToolResultTokens(readMoreName)
+	page, _ := a.tokenCounter.TruncateToTokens(text[offset:], maxTokens)
+	if page == "" {
+		// Always move on by at least one character, or the model would be
+		// sent back to the same offset forever.
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		page = text[offset : offset+size]
+	}
+	end := offset + len(page)
+	if end >= len(text) {
+		return page + fmt.Sprintf("\n[End of handle %s.]", handle)
+	}
+	return page + "\n[Truncated." + pagingNote(handle, end, len(text))
+}
+
+// pagingNote tells the model how to continue reading a stored result. It
+// completes a bracketed note opened by the caller.
+func pagingNote(handle string, nextOffset, totalBytes int) string {
+	return fmt.Sprintf(" Shown up to byte offset %d of %d. To read on, call %s with {\"handle\": %q, \"offset\": %d}.]", nextOffset, totalBytes, readMoreName, handle, nextOffset)
+}
+
+// readMoreManifestEntry describes the read_more pseudo-tool.
+const readMoreManifestEntry = "- " + readMoreName + ": Read the next part of a truncated tool result.\n  Arguments: handle (string, required): the handle from the truncated result; offset (integer, required): the byte offset to continue from\n"
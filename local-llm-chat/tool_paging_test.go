@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"local-llm-chat/artifacts"
+)
+
+// newPagingApp returns an app that stores tool results in a temporary
+// directory and pages them in pages of a few tokens.
+func newPagingApp(t *testing.T) *App {
+	t.Helper()
+	app := NewApp()
+	app.tokenCounter = NewTokenCounter(nil)
+	app.ArtifactService = artifacts.NewArtifactService(nil, t.TempDir())
+	app.config.MaxToolResultTokens = 5
+	app.config.ToolResultTokenLimits = map[string]int{readMoreName: 5}
+	return app
+}
+
+func TestReadMore(t *testing.T) {
+	app := newPagingApp(t)
+	result := strings.Repeat("Grüße aus Köln. ", 20)
+	preview, truncated := app.pageToolResult(toolCallOrigin{SessionID: 1, MessageID: 2, ID: "call", ToolName: "fetch"}, result)
+	if !truncated {
+		t.Fatal("result was not truncated")
+	}
+	stored := app.ArtifactService.AllArtifacts()
+	if len(stored) != 1 {
+		t.Fatalf("stored %d artifacts, want 1", len(stored))
+	}
+	handle := stored[0].ID
+	if !strings.Contains(preview, handle) {
+		t.Errorf("preview does not name the handle:\n%s", preview)
+	}
+
+	// Reading on from each page's offset yields the whole result.
+	text := preview[:strings.Index(preview, "\n[")]
+	offset := len(text)
+	for i := 0; ; i++ {
+		page := app.readMoreResult(1, ToolCall{ToolName: readMoreName, Arguments: map[string]interface{}{"handle": handle, "offset": float64(offset)}})
+		body := page[:strings.LastIndex(page, "\n[")]
+		text += body
+		offset += len(body)
+		if strings.HasSuffix(page, "[End of handle "+handle+".]") {
+			break
+		}
+		if i > len(result) {
+			t.Fatal("read_more never reached the end")
+		}
+	}
+	if text != result {
+		t.Errorf("pages add up to %q, want %q", text, result)
+	}
+}
+
+func TestReadMoreRejectsOtherHandles(t *testing.T) {
+	app := newPagingApp(t)
+	app.pageToolResult(toolCallOrigin{SessionID: 1, ID: "call", ToolName: "fetch"}, strings.Repeat("secret ", 100))
+	paged := app.ArtifactService.AllArtifacts()[0].ID
+	attached, err := app.ArtifactService.AddArtifactWithMetadata(2, artifacts.TypeDocument, "notes.txt", base64.StdEncoding.EncodeToString([]byte("private notes")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sessionID int64
+		handle    string
+	}{
+		{"result of another session", 2, paged},
+		{"document that is no paged result", 2, attached.ID},
+		{"made up handle", 1, "0123abcd-0123-4567-89ab-0123456789ab"},
+	}
+	for _, test := range tests {
+		got := app.readMoreResult(test.sessionID, ToolCall{ToolName: readMoreName, Arguments: map[string]interface{}{"handle": test.handle}})
+		if got != "Unknown handle "+test.handle+"." {
+			t.Errorf("%s: got %q", test.name, got)
+		}
+	}
+	if got := app.readMoreResult(1, ToolCall{ToolName: readMoreName, Arguments: map[string]interface{}{"handle": paged}}); !strings.HasPrefix(got, "secret") {
+		t.Errorf("own session: got %q", got)
+	}
+}
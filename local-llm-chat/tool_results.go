@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"local-llm-chat/artifacts"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// toolCallOrigin identifies a tool call, so the artifacts of its result are
+// linked to it and to the message that made it.
+type toolCallOrigin struct {
+	SessionID int64
+	MessageID int64  // The assistant message with the call; 0 if it was not saved
+	ID        string // Tool call ID
+	ToolName  string
+}
+
+// toolResultText turns a tool result into the message the model sees next.
+// Text is passed through; images, audio and embedded resources are saved as
+// artifacts and referenced by name. Images are also returned as data URLs so
+// they can be sent to models with vision enabled. Results flagged IsError are
+// marked as failures so the model does not mistake them for output.
+func (a *App) toolResultText(origin toolCallOrigin, result *mcp.CallToolResult) (string, []string) {
+	toolName := origin.ToolName
+	var parts []string
+	var images []string
+
+	for i, content := range result.Content {
+		switch c := content.(type) {
+		case mcp.TextContent:
+			parts = append(parts, c.Text)
+		case mcp.ImageContent:
+			name := toolArtifactName(toolName, "image", i, c.MIMEType)
+			parts = append(parts, a.saveToolArtifact(origin, artifacts.TypeImage, name, c.Data, c.MIMEType))
+			if a.modelSupportsImages() {
+				images = append(images, fmt.Sprintf("data:%s;base64,%s", c.MIMEType, c.Data))
+			}
+		case mcp.AudioContent:
+			name := toolArtifactName(toolName, "audio", i, c.MIMEType)
+			parts = append(parts, a.saveToolArtifact(origin, artifacts.TypeAudio, name, c.Data, c.MIMEType))
+		case mcp.EmbeddedResource:
+			parts = append(parts, a.saveToolResource(origin, i, c.Resource))
+		case mcp.ResourceLink:
+			parts = append(parts, fmt.Sprintf("[Resource link: %s (%s)]", c.Name, c.URI))
+		default:
+			a.logWarningf("Tool Agent: Ignoring unsupported content %T from tool %s", content, toolName)
+		}
+	}
+
+	text := strings.Join(parts, "\n")
+	if result.IsError {
+		if strings.TrimSpace(text) == "" {
+			text = "(no error message)"
+		}
+		text = fmt.Sprintf("Tool call failed: %s returned an error.\n%s", toolName, text)
+	}
+	return text, images
+}
+
+// saveToolResource stores an embedded resource as a document artifact. Text
+// resources are also included in the reply so the model can read them.
+func (a *App) saveToolResource(origin toolCallOrigin, index int, resource mcp.ResourceContents) string {
+	switch r := resource.(type) {
+	case mcp.TextResourceContents:
+		name := resourceArtifactName(r.URI, origin.ToolName, index, r.MIMEType)
+		reference := a.saveToolArtifact(origin, artifacts.TypeDocument, name, base64.StdEncoding.EncodeToString([]byte(r.Text)), r.MIMEType)
+		return fmt.Sprintf("%s\nContents of %s:\n%s", reference, r.URI, r.Text)
+	case mcp.BlobResourceContents:
+		name := resourceArtifactName(r.URI, origin.ToolName, index, r.MIMEType)
+		return a.saveToolArtifact(origin, artifacts.TypeDocument, name, r.Blob, r.MIMEType)
+	default:
+		return fmt.Sprintf("[Unsupported resource %T from %s]", resource, origin.ToolName)
+	}
+}
+
+// saveToolArtifact stores base64 content from a tool as an artifact and
+// returns the reference placed in the conversation.
+func (a *App) saveToolArtifact(origin toolCallOrigin, artifactType artifacts.ArtifactType, name, data, mimeType string) string {
+	toolName := origin.ToolName
+	if a.ArtifactService == nil {
+		return fmt.Sprintf("[%s %s from %s could not be saved: artifact service not initialized]", strings.ToLower(string(artifactType)), name, toolName)
+	}
+	metadata := map[string]interface{}{"mime_type": mimeType, "source_tool": toolName}
+	artifact, err := a.ArtifactService.AddMessageArtifact(origin.SessionID, origin.MessageID, origin.ID, artifactType, name, data, metadata)
+	if err != nil {
+		a.logErrorf("Tool Agent: Error saving %s from tool %s: %v", artifactType, toolName, err)
+		return fmt.Sprintf("[%s %s from %s could not be saved: %v]", strings.ToLower(string(artifactType)), name, toolName, err)
+	}
+	return fmt.Sprintf("[%s saved as artifact %q (id %s)]", strings.ToLower(string(artifactType)), name, artifact.ID)
+}
+
+// toolArtifactName builds a file name like "screenshot_image_0.png".
+func toolArtifactName(toolName, kind string, index int, mimeType string) string {
+	return fmt.Sprintf("%s_%s_%d%s", toolName, kind, index, extensionForMIME(mimeType))
+}
+
+// resourceArtifactName uses the last path element of the resource URI, falling
+// back to a name derived from the tool.
+func resourceArtifactName(uri, toolName string, index int, mimeType string) string {
+	if base := path.Base(uri); base != "" && base != "." && base != "/" && !strings.HasSuffix(base, ":") {
+		if path.Ext(base) == "" {
+			base += extensionForMIME(mimeType)
+		}
+		return base
+	}
+	return toolArtifactName(toolName, "resource", index, mimeType)
+}
+
+// mimeExtensions fixes the extension of common MIME types;
+// mime.ExtensionsByType depends on the platform's tables and may pick an
+// unusual one first, such as .jfif for image/jpeg.
+var mimeExtensions = map[string]string{
+	"image/png":        ".png",
+	"image/jpeg":       ".jpg",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"audio/mpeg":       ".mp3",
+	"audio/wav":        ".wav",
+	"audio/ogg":        ".ogg",
+	"text/plain":       ".txt",
+	"text/markdown":    ".md",
+	"text/html":        ".html",
+	"text/csv":         ".csv",
+	"application/json": ".json",
+	"application/pdf":  ".pdf",
+}
+
+// extensionForMIME returns a file extension for a MIME type, or ".bin".
+func extensionForMIME(mimeType string) string {
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = mediaType
+	}
+	if ext, ok := mimeExtensions[mimeType]; ok {
+		return ext
+	}
+	if extensions, err := mime.ExtensionsByType(mimeType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	if strings.HasPrefix(mimeType, "text/") {
+		return ".txt"
+	}
+	return ".bin"
+}
+
+// modelSupportsImages reports whether the selected model accepts images: it
+// was launched with a multimodal projector or has vision enabled in its
+// settings.
+func (a *App) modelSupportsImages() bool {
+	if _, model, mmproj := a.llmServer(); mmproj != "" && model == a.config.SelectedModel {
+		return true
+	}
+	settings, ok := a.config.ModelSettings[a.config.SelectedModel]
+	return ok && settings.Vision
+}
+
+// MarshalJSON sends messages that carry images as OpenAI-style content parts,
+// which llama-server forwards to the model's multimodal projector.
+func (r ChatCompletionRequest) MarshalJSON() ([]byte, error) {
+	type plainRequest ChatCompletionRequest
+	messages := make([]interface{}, len(r.Messages))
+	for i, msg := range r.Messages {
+		if len(msg.Images) == 0 {
+			messages[i] = msg
+			continue
+		}
+		messages[i] = struct {
+			Role    string        `json:"role"`
+			Content []ContentPart `json:"content"`
+		}{msg.Role, msg.contentParts()}
+	}
+	return json.Marshal(struct {
+		plainRequest
+		Messages []interface{} `json:"messages"`
+	}{plainRequest(r), messages})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// moreToolsName is the pseudo-tool the Tool-Using Agent calls when none of the
+// tools in its manifest fit the request.
+const moreToolsName = "more_tools"
+
+// defaultToolSelectionLimit is how many tools are offered per manifest when
+// tool_selection_limit is not configured.
+const defaultToolSelectionLimit = 5
+
+// toolDescriptionChars caps tool and argument descriptions in the manifest.
+const toolDescriptionChars = 200
+
+// ToolSelection tracks which tools have been offered to the Tool-Using Agent
+// during one turn.
+type ToolSelection struct {
+	Offered []mcp.Tool
+	// Remaining is the number of connected tools that have not been offered yet.
+	Remaining int
+	// Paging is set once a tool result has been truncated, which adds read_more.
+	Paging bool
+}
+
+// Names returns the names of the offered tools.
+func (s *ToolSelection) Names() []string {
+	names := make([]string, len(s.Offered))
+	for i, tool := range s.Offered {
+		names[i] = tool.Name
+	}
+	return names
+}
+
+// toolSelectionLimit returns the configured number of tools per manifest.
+func (r *Router) toolSelectionLimit() int {
+	if r.app.config.ToolSelectionLimit > 0 {
+		return r.app.config.ToolSelectionLimit
+	}
+	return defaultToolSelectionLimit
+}
+
+// SelectTools ranks the connected tools for a query and returns the best ones
+// up to the configured limit. If preferred is not empty (e.g. the tools picked
+// by the Router Agent), only those are offered at first; the rest stay
+// available through more_tools.
+func (r *Router) SelectTools(query string, preferred []string) *ToolSelection {
+	tools := r.availableTools()
+	var candidates []mcp.Tool
+	for _, tool := range tools {
+		if toolAllowed(preferred, tool.Name) {
+			candidates = append(candidates, tool)
+		}
+	}
+	offered, _ := r.rankTools(query, candidates, nil)
+	return &ToolSelection{Offered: offered, Remaining: len(tools) - len(offered)}
+}
+
+// SelectMoreTools adds the next best tools for query to a selection. It
+// returns the names of the tools that were added.
+func (r *Router) SelectMoreTools(selection *ToolSelection, query string) []string {
+	offered := make(map[string]bool, len(selection.Offered))
+	for _, tool := range selection.Offered {
+		offered[tool.Name] = true
+	}
+	added, remaining := r.rankTools(query, r.availableTools(), offered)
+	selection.Offered = append(selection.Offered, added...)
+	selection.Remaining = remaining
+
+	names := make([]string, len(added))
+	for i, tool := range added {
+		names[i] = tool.Name
+	}
+	return names
+}
+
+// rankTools scores the tools that are not excluded and returns the top ones
+// along with how many were left out.
+func (r *Router) rankTools(query string, tools []mcp.Tool, exclude map[string]bool) ([]mcp.Tool, int) {
+	var candidates []mcp.Tool
+	for _, tool := range tools {
+		if !exclude[tool.Name] {
+			candidates = append(candidates, tool)
+		}
+	}
+	limit := r.toolSelectionLimit()
+	if len(candidates) <= limit {
+		return candidates, 0
+	}
+
+	scores := make(map[string]float64, len(candidates))
+	queryTerms := searchTerms(query)
+	for _, tool := range candidates {
+		scores[tool.Name] = keywordScore(queryTerms, tool)
+	}
+
+	if embeddingScores, err := r.embeddingScores(query, candidates); err != nil {
+		r.app.logInfof("Tool selection: Using keyword ranking only: %v", err)
+	} else {
+		for name, score := range embeddingScores {
+			scores[name] += score
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return scores[candidates[i].Name] > scores[candidates[j].Name]
+	})
+	r.app.logInfof("Tool selection: Offering %d of %d tools", limit, len(candidates))
+	return candidates[:limit], len(candidates) - limit
+}
+
+// embeddingScores returns the similarity of the query to each tool's
+// description and configured examples. Embeddings are only used when an
+// embedding server is configured or the router runs in embedding mode.
+func (r *Router) embeddingScores(query string, tools []mcp.Tool) (map[string]float64, error) {
+	if r.app.config.RouterMode != RouterModeEmbedding && r.app.config.EmbeddingServerURL == "" {
+		return nil, fmt.Errorf("no embedding server configured")
+	}
+
+	toolTexts := make(map[string][]string, len(tools))
+	var texts []string
+	for _, tool := range tools {
+		toolTexts[tool.Name] = append([]string{toolEmbeddingText(tool)}, r.app.config.RouterExamples[tool.Name]...)
+		texts = append(texts, toolTexts[tool.Name]...)
+	}
+	vectors, err := r.embedCached(texts)
+	if err != nil {
+		return nil, err
+	}
+	queryVectors, err := r.app.embeddingClient().Embed([]string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]float64, len(tools))
+	for name, textsForTool := range toolTexts {
+		best := 0.0
+		for _, text := range textsForTool {
+			if similarity := cosineSimilarity(queryVectors[0], vectors[text]); similarity > best {
+				best = similarity
+			}
+		}
+		scores[name] = best
+	}
+	return scores, nil
+}
+
+// toolEmbeddingText is the text embedded for a tool's description.
+func toolEmbeddingText(tool mcp.Tool) string {
+	if tool.Description == "" {
+		return tool.Name
+	}
+	return tool.Name + ": " + tool.Description
+}
+
+// searchTerms lowercases text and splits it into words of at least three
+// letters or digits. Tool names like get_weather split into "get" and "weather".
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	var terms []string
+	for _, word := range words {
+		if len(word) >= 3 {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// keywordScore is the fraction of query terms that occur in the tool's name,
+// description or argument names.
+func keywordScore(queryTerms []string, tool mcp.Tool) float64 {
+	if len(queryTerms) == 0 {
+		return 0
+	}
+	schema := toolInputSchema(tool)
+	toolText := tool.Name + " " + tool.Description
+	for name := range schema.Properties {
+		toolText += " " + name
+	}
+	toolTerms := make(map[string]bool)
+	for _, term := range searchTerms(toolText) {
+		toolTerms[term] = true
+	}
+
+	matches := 0
+	for _, term := range queryTerms {
+		for toolTerm := range toolTerms {
+			// Prefix matching lets "files" match "file" and "weather" match "weathers".
+			if strings.HasPrefix(term, toolTerm) || strings.HasPrefix(toolTerm, term) {
+				matches++
+				break
+			}
+		}
+	}
+	return float64(matches) / float64(len(queryTerms))
+}
+
+// toolInputSchema returns a tool's input schema, decoding RawInputSchema if
+// the server supplied one.
+func toolInputSchema(tool mcp.Tool) mcp.ToolInputSchema {
+	if len(tool.RawInputSchema) == 0 {
+		return tool.InputSchema
+	}
+	var schema mcp.ToolInputSchema
+	if err := json.Unmarshal(tool.RawInputSchema, &schema); err != nil {
+		return tool.InputSchema
+	}
+	return schema
+}
+
+// compactToolArguments renders a tool's arguments on one line, e.g.
+// `city (string, required): City name; units ("c"|"f")`. This is much shorter
+// than the indented JSON schema and easier for small models to follow.
+func compactToolArguments(tool mcp.Tool) string {
+	schema := toolInputSchema(tool)
+	if len(schema.Properties) == 0 {
+		return ""
+	}
+	required := make(map[string]bool, len(schema.Required))
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	// Required arguments first, then alphabetical, so the manifest is stable.
+	sort.Slice(names, func(i, j int) bool {
+		if required[names[i]] != required[names[j]] {
+			return required[names[i]]
+		}
+		return names[i] < names[j]
+	})
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		property, _ := schema.Properties[name].(map[string]interface{})
+		var details []string
+		if enum, ok := property["enum"].([]interface{}); ok && len(enum) > 0 {
+			values := make([]string, len(enum))
+			for i, value := range enum {
+				valueBytes, _ := json.Marshal(value)
+				values[i] = string(valueBytes)
+			}
+			details = append(details, strings.Join(values, "|"))
+		} else if propertyType, ok := property["type"].(string); ok {
+			details = append(details, propertyType)
+		}
+		if required[name] {
+			details = append(details, "required")
+		}
+
+		part := name
+		if len(details) > 0 {
+			part += " (" + strings.Join(details, ", ") + ")"
+		}
+		if description, ok := property["description"].(string); ok && description != "" {
+			part += ": " + truncateDescription(description)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// truncateDescription shortens a description to its first line and at most
+// toolDescriptionChars characters.
+func truncateDescription(description string) string {
+	description = strings.TrimSpace(description)
+	if i := strings.IndexByte(description, '\n'); i >= 0 {
+		description = strings.TrimSpace(description[:i])
+	}
+	if len(description) > toolDescriptionChars {
+		description = strings.TrimSpace(description[:toolDescriptionChars]) + "..."
+	}
+	return description
+}
+
+// moreToolsQuery returns the query a more_tools call asks for, defaulting to
+// the user's original request.
+func moreToolsQuery(toolCall ToolCall, fallback string) string {
+	if query, ok := toolCall.Arguments["query"].(string); ok && strings.TrimSpace(query) != "" {
+		return query
+	}
+	return fallback
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"local-llm-chat/mcpclient"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// testTools are the tools of the server connectTestTools starts.
+var testTools = []mcp.Tool{
+	mcp.NewTool("get_weather", mcp.WithDescription("Current weather in a city"), mcp.WithString("city")),
+	mcp.NewTool("get_forecast", mcp.WithDescription("Weather forecast for the next days"), mcp.WithString("city")),
+	mcp.NewTool("read_file", mcp.WithDescription("Read a local file"), mcp.WithString("path")),
+	mcp.NewTool("list_files", mcp.WithDescription("List the files of a directory"), mcp.WithString("path")),
+	mcp.NewTool("send_email", mcp.WithDescription("Send an email"), mcp.WithString("recipient")),
+	mcp.NewTool("search_web", mcp.WithDescription("Search the web"), mcp.WithString("query")),
+	mcp.NewTool("convert_currency", mcp.WithDescription("Convert an amount between currencies"), mcp.WithNumber("amount")),
+}
+
+// connectTestTools connects an in-process MCP server offering testTools, whose
+// calls all return "ok". It returns the number of calls run so far.
+func connectTestTools(t *testing.T, app *App) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	s := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	for _, tool := range testTools {
+		s.AddTool(tool, func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			calls.Add(1)
+			return mcp.NewToolResultText("ok"), nil
+		})
+	}
+	client := mcpclient.NewMcpClient()
+	if err := client.ConnectInProcess(s); err != nil {
+		t.Fatalf("ConnectInProcess: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	app.mcpClients["test"] = client
+	return &calls
+}
+
+func toolNames(tools []mcp.Tool) []string {
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool.Name
+	}
+	return names
+}
+
+func TestSearchTerms(t *testing.T) {
+	got := searchTerms("What's the Weather in Zürich, 2024? get_forecast a to")
+	want := []string{"what", "the", "weather", "zürich", "2024", "get", "forecast"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchTerms = %q, want %q", got, want)
+	}
+}
+
+func TestKeywordScore(t *testing.T) {
+	weather := testTools[0]
+	tests := []struct {
+		query string
+		want  float64
+	}{
+		{"", 0},
+		{"a b", 0},
+		{"weather", 1},
+		{"weathers in Paris", 0.5},           // "weathers" matches by prefix, "paris" does not
+		{"current city conditions", 2.0 / 3}, // "city" is an argument name
+		{"send an email", 0},
+	}
+	for _, test := range tests {
+		if got := keywordScore(searchTerms(test.query), weather); got != test.want {
+			t.Errorf("keywordScore(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestRankToolsTopK(t *testing.T) {
+	app := NewApp()
+	app.config.ToolSelectionLimit = 3
+	router := NewRouter(app)
+
+	ranked, remaining := router.rankTools("weather forecast for my city", testTools, nil)
+	if remaining != len(testTools)-3 || len(ranked) != 3 {
+		t.Fatalf("got %d tools and %d remaining, want 3 and %d", len(ranked), remaining, len(testTools)-3)
+	}
+	if names := toolNames(ranked); names[0] != "get_forecast" || names[1] != "get_weather" {
+		t.Errorf("ranked = %v, want get_forecast and get_weather first", names)
+	}
+
+	// Excluded tools are never offered again.
+	ranked, remaining = router.rankTools("weather forecast", testTools, map[string]bool{"get_forecast": true, "get_weather": true})
+	for _, name := range toolNames(ranked) {
+		if strings.HasPrefix(name, "get_") {
+			t.Errorf("excluded tool %s was offered again", name)
+		}
+	}
+	if remaining != len(testTools)-2-3 {
+		t.Errorf("remaining = %d, want %d", remaining, len(testTools)-5)
+	}
+
+	// Up to the limit every tool is offered, however poorly it matches.
+	ranked, remaining = router.rankTools("weather", testTools[2:5], nil)
+	if remaining != 0 || !reflect.DeepEqual(toolNames(ranked), toolNames(testTools[2:5])) {
+		t.Errorf("got %v and %d remaining, want all three tools in order", toolNames(ranked), remaining)
+	}
+}
+
+func TestSelectTools(t *testing.T) {
+	app := NewApp()
+	app.config.ToolSelectionLimit = 2
+	connectTestTools(t, app)
+	router := NewRouter(app)
+
+	// The tools the router picked are offered even if others match better.
+	selection := router.SelectTools("weather", []string{"send_email"})
+	if got := selection.Names(); !reflect.DeepEqual(got, []string{"send_email"}) || selection.Remaining != len(testTools)-1 {
+		t.Fatalf("selection = %v with %d remaining, want only send_email", got, selection.Remaining)
+	}
+
+	added := router.SelectMoreTools(selection, "weather forecast")
+	sort.Strings(added)
+	if !reflect.DeepEqual(added, []string{"get_forecast", "get_weather"}) {
+		t.Errorf("more_tools added %v, want the weather tools", added)
+	}
+	if selection.Remaining != len(testTools)-3 || len(selection.Offered) != 3 {
+		t.Errorf("after more_tools: %v with %d remaining", selection.Names(), selection.Remaining)
+	}
+
+	manifest, err := router.GetToolManifestText(selection)
+	if err != nil || !strings.Contains(manifest, "- "+moreToolsName+":") || strings.Contains(manifest, readMoreName) {
+		t.Errorf("manifest should offer more_tools but not read_more:\n%s", manifest)
+	}
+	selection.Paging = true
+	schema, _ := router.GetToolManifestSchema(selection)
+	names := schema["properties"].(map[string]interface{})["tool_name"].(map[string]interface{})["enum"].([]string)
+	if names[len(names)-2] != moreToolsName || names[len(names)-1] != readMoreName {
+		t.Errorf("schema tool names = %v, want more_tools and read_more last", names)
+	}
+
+	// Once every tool has been offered, more_tools is no longer listed.
+	router.SelectMoreTools(selection, "")
+	router.SelectMoreTools(selection, "")
+	if selection.Remaining != 0 || len(selection.Offered) != len(testTools) {
+		t.Fatalf("after offering everything: %v with %d remaining", selection.Names(), selection.Remaining)
+	}
+	if manifest, _ := router.GetToolManifestText(selection); strings.Contains(manifest, moreToolsName) {
+		t.Errorf("manifest still offers more_tools:\n%s", manifest)
+	}
+}
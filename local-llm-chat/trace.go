@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Trace event kinds.
+const (
+	TraceEventRouter   = "router"
+	TraceEventLLMCall  = "llm_call"
+	TraceEventToolCall = "tool_call"
+	TraceEventLoop     = "loop"
+)
+
+// Trace outcomes.
+const (
+	TraceOutcomeAnswered      = "answered"
+	TraceOutcomeMaxIterations = "max_iterations"
+	TraceOutcomeError         = "error"
+)
+
+// AgentTrace records how one user message was handled: the router decision,
+// every LLM call and every tool call, in order.
+type AgentTrace struct {
+	ID         int64        `json:"id"`
+	SessionID  int64        `json:"session_id"`
+	MessageID  int64        `json:"message_id"` // The user message that started the run
+	StartedAt  string       `json:"started_at"`
+	FinishedAt string       `json:"finished_at,omitempty"`
+	Outcome    string       `json:"outcome,omitempty"`
+	Events     []TraceEvent `json:"events"`
+}
+
+// TraceEvent is a single step of an agent run.
+type TraceEvent struct {
+	ID               int64           `json:"id"`
+	Seq              int             `json:"seq"`
+	Kind             string          `json:"kind"` // One of the TraceEvent* kinds
+	Name             string          `json:"name"` // Tool name, or the purpose of an LLM call
+	StartedAt        string          `json:"started_at"`
+	DurationMs       int64           `json:"duration_ms"`
+	PromptTokens     int             `json:"prompt_tokens,omitempty"`
+	CompletionTokens int             `json:"completion_tokens,omitempty"`
+	Details          json.RawMessage `json:"details,omitempty"` // Kind-specific data, e.g. tool arguments
+	Error            string          `json:"error,omitempty"`
+}
+
+// tokenUsage is the usage block llama-server returns with a completion.
+type tokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// agentTrace collects the events of a running agent turn and writes them to
+// the database as they happen. A nil *agentTrace records nothing, so tracing
+// failures never interrupt a chat.
+type agentTrace struct {
+	app *App
+	id  int64
+	mu  sync.Mutex
+	seq int
+}
+
+// startTrace opens a trace for the user message that starts a turn.
+func (a *App) startTrace(sessionID, messageID int64) *agentTrace {
+	id, err := a.db.CreateAgentTrace(sessionID, messageID)
+	if err != nil {
+		a.logErrorf("Error creating agent trace: %s", err.Error())
+		return nil
+	}
+	return &agentTrace{app: a, id: id}
+}
+
+// turnTrace returns the trace of the turn currently running in a session.
+func (a *App) turnTrace(sessionID int64) *agentTrace {
+	conv, ok := a.getConversation(sessionID)
+	if !ok {
+		return nil
+	}
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+	return conv.trace
+}
+
+// record stores an event that started at started and ends now.
+func (t *agentTrace) record(event TraceEvent, started time.Time, details interface{}, err error) {
+	if t == nil {
+		return
+	}
+	event.StartedAt = started.Format(time.RFC3339Nano)
+	event.DurationMs = time.Since(started).Milliseconds()
+	if details != nil {
+		if detailsBytes, marshalErr := json.Marshal(details); marshalErr == nil {
+			event.Details = detailsBytes
+		}
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	t.seq++
+	event.Seq = t.seq
+	t.mu.Unlock()
+
+	if dbErr := t.app.db.AddAgentTraceEvent(t.id, event); dbErr != nil {
+		t.app.logErrorf("Error saving trace event: %s", dbErr.Error())
+	}
+}
+
+// RouterDecision records the Router Agent's decision and how long it took.
+func (t *agentTrace) RouterDecision(decision RouterDecision, started time.Time, err error) {
+	t.record(TraceEvent{Kind: TraceEventRouter, Name: decision.Mode}, started, decision, err)
+}
+
+// LLMCall records a completion request. name says what the call was for,
+// e.g. "tool_agent" or "answer".
+func (t *agentTrace) LLMCall(name string, started time.Time, usage tokenUsage, err error) {
+	t.record(TraceEvent{
+		Kind:             TraceEventLLMCall,
+		Name:             name,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+	}, started, nil, err)
+}
+
+// ToolCall records a tool execution with its arguments and the size of the result.
+func (t *agentTrace) ToolCall(call ToolCall, started time.Time, resultChars int, isError bool, err error) {
+	details := map[string]interface{}{
+		"arguments":    call.Arguments,
+		"result_chars": resultChars,
+		"is_error":     isError,
+	}
+	if call.ID != "" {
+		details["tool_call_id"] = call.ID
+	}
+	t.record(TraceEvent{Kind: TraceEventToolCall, Name: call.ToolName}, started, details, err)
+}
+
+// Loop records a repeated tool call and the strategy applied to it.
+func (t *agentTrace) Loop(call ToolCall, kind, strategy string) {
+	details := map[string]interface{}{
+		"arguments": call.Arguments,
+		"loop":      kind,
+		"strategy":  strategy,
+	}
+	t.record(TraceEvent{Kind: TraceEventLoop, Name: call.ToolName}, time.Now(), details, nil)
+}
+
+// Finish closes the trace with the outcome of the turn.
+func (t *agentTrace) Finish(outcome string) {
+	if t == nil {
+		return
+	}
+	if err := t.app.db.FinishAgentTrace(t.id, outcome); err != nil {
+		t.app.logErrorf("Error finishing agent trace: %s", err.Error())
+	}
+}
+
+// finishTurnTrace closes the trace of the turn running in a session, if any.
+func (a *App) finishTurnTrace(sessionID int64, outcome string) {
+	conv, ok := a.getConversation(sessionID)
+	if !ok {
+		return
+	}
+	conv.mu.Lock()
+	trace := conv.trace
+	conv.trace = nil
+	conv.mu.Unlock()
+	trace.Finish(outcome)
+}
+
+// GetAgentTraces returns the agent traces of a chat session, oldest first.
+func (a *App) GetAgentTraces(sessionID int64) ([]AgentTrace, error) {
+	traces, err := a.db.GetAgentTraces(sessionID)
+	if err != nil {
+		a.logErrorf("GetAgentTraces: Error loading traces for session %d: %s", sessionID, err.Error())
+		return nil, err
+	}
+	if traces == nil {
+		return []AgentTrace{}, nil
+	}
+	return traces, nil
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"local-llm-chat/artifacts"
+	"local-llm-chat/events"
+)
+
+// Text to speech. Replies are spoken by a SpeechBackend: a finished reply is
+// stored as an audio artifact on request, and with tts_stream replies are
+// spoken sentence by sentence while they stream.
+
+// Defaults of the tts_* settings.
+const (
+	defaultTTSModel  = "tts-1"
+	defaultTTSVoice  = "alloy"
+	defaultTTSFormat = "mp3"
+)
+
+const (
+	minSpeechSentence = 40   // Characters gathered before a sentence is spoken on its own
+	maxSpeechChars    = 4000 // Bytes per synthesis request
+)
+
+// SpeechBackend turns text into speech.
+type SpeechBackend interface {
+	// Synthesize returns the audio of the spoken text and its MIME type.
+	Synthesize(ctx context.Context, text string) ([]byte, string, error)
+}
+
+// OpenAISpeech is a SpeechBackend for servers with OpenAI's
+// /v1/audio/speech endpoint, such as Kokoro-FastAPI or a llama.cpp TTS model
+// behind an OpenAI-compatible proxy.
+type OpenAISpeech struct {
+	BaseURL string
+	Model   string
+	Voice   string
+	Format  string // mp3, wav, opus, aac, flac or pcm
+	client  *http.Client
+}
+
+// NewOpenAISpeech creates a backend for the speech server at baseURL.
+func NewOpenAISpeech(baseURL, model, voice, format string) *OpenAISpeech {
+	return &OpenAISpeech{
+		BaseURL: strings.TrimSuffix(strings.TrimRight(baseURL, "/"), "/v1"),
+		Model:   model,
+		Voice:   voice,
+		Format:  format,
+		client:  &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Synthesize implements SpeechBackend.
+func (s *OpenAISpeech) Synthesize(ctx context.Context, text string) ([]byte, string, error) {
+	reqBody, err := json.Marshal(map[string]string{
+		"model":           s.Model,
+		"input":           text,
+		"voice":           s.Voice,
+		"response_format": s.Format,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+"/v1/audio/speech", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("error making speech request: %w", err)
+	}
+	defer resp.Body.Close()
+	audio, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading speech response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("speech request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(audio)))
+	}
+	mimeType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(mimeType, "audio/") {
+		mimeType = speechMIMEType(s.Format)
+	}
+	return audio, mimeType, nil
+}
+
+// speechMIMEType returns the MIME type of an OpenAI speech response format.
+func speechMIMEType(format string) string {
+	switch format {
+	case "mp3":
+		return "audio/mpeg"
+	case "opus":
+		return "audio/ogg"
+	case "pcm":
+		return "audio/pcm"
+	default:
+		return "audio/" + format
+	}
+}
+
+// speechBackend returns the backend replies are spoken with: a.speech if set,
+// otherwise the server at tts_server_url.
+func (a *App) speechBackend() (SpeechBackend, error) {
+	if a.speech != nil {
+		return a.speech, nil
+	}
+	if a.config.TTSServerURL == "" {
+		return nil, fmt.Errorf("tts_server_url is not set")
+	}
+	model, voice, format := a.config.TTSModel, a.config.TTSVoice, a.config.TTSFormat
+	if model == "" {
+		model = defaultTTSModel
+	}
+	if voice == "" {
+		voice = defaultTTSVoice
+	}
+	if format == "" {
+		format = defaultTTSFormat
+	}
+	return NewOpenAISpeech(a.config.TTSServerURL, model, voice, format), nil
+}
+
+// SpeakMessage reads an assistant message aloud and stores the audio as
+// artifacts of the session. Long messages are spoken in several parts, one
+// artifact each.
+func (a *App) SpeakMessage(sessionID, messageID int64) ([]*artifacts.Artifact, error) {
+	if a.ArtifactService == nil {
+		return nil, fmt.Errorf("artifact service not initialized")
+	}
+	backend, err := a.speechBackend()
+	if err != nil {
+		return nil, err
+	}
+	history, err := a.db.GetChatMessages(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var text string
+	found := false
+	for _, msg := range history {
+		if msg.ID == messageID && msg.Role == "assistant" {
+			text, found = speakableText(harmonyVisibleText(stripThinkTags(msg.Content))), true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no assistant message %d in session %d", messageID, sessionID)
+	}
+	if text == "" {
+		return nil, fmt.Errorf("message %d has no text to speak", messageID)
+	}
+
+	parts := splitSpeech(text, maxSpeechChars)
+	var result []*artifacts.Artifact
+	for i, part := range parts {
+		audio, mimeType, err := backend.Synthesize(context.Background(), part)
+		if err != nil {
+			return result, err
+		}
+		name := fmt.Sprintf("message_%d%s", messageID, extensionForMIME(mimeType))
+		if len(parts) > 1 {
+			name = fmt.Sprintf("message_%d_part%d%s", messageID, i+1, extensionForMIME(mimeType))
+		}
+		metadata := map[string]interface{}{"mime_type": mimeType, "source": "tts", "text": part}
+		artifact, err := a.ArtifactService.AddMessageArtifact(sessionID, messageID, "", artifacts.TypeAudio, name, base64.StdEncoding.EncodeToString(audio), metadata)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, artifact)
+	}
+	return result, nil
+}
+
+// splitSpeech splits text into parts of at most maxChars bytes, between
+// sentences where possible and never inside a character.
+func splitSpeech(text string, maxChars int) []string {
+	var parts []string
+	var current strings.Builder
+	splitter := &sentenceSplitter{}
+	sentences := append(splitter.Feed(text), splitter.Flush())
+	for _, sentence := range sentences {
+		for len(sentence) > maxChars {
+			limit := maxChars
+			for limit > 0 && !utf8.RuneStart(sentence[limit]) {
+				limit--
+			}
+			if limit == 0 {
+				_, limit = utf8.DecodeRuneInString(sentence)
+			}
+			cut := strings.LastIndexByte(sentence[:limit], ' ')
+			if cut <= 0 {
+				cut = limit
+			}
+			parts = append(parts, strings.TrimSpace(sentence[:cut]))
+			sentence = sentence[cut:]
+		}
+		if current.Len() > 0 && current.Len()+1+len(sentence) > maxChars {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteByte(' ')
+		}
+		current.WriteString(strings.TrimSpace(sentence))
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+var (
+	speechCodeBlock  = regexp.MustCompile("(?s)```.*?(```|$)")
+	speechLink       = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	speechMarkup     = regexp.MustCompile("[*_`#>|~]+")
+	speechWhitespace = regexp.MustCompile(`\s+`)
+)
+
+// speakableText removes from Markdown what should not be read aloud: code
+// blocks, link targets and formatting characters.
+func speakableText(text string) string {
+	text = speechCodeBlock.ReplaceAllString(text, " ")
+	text = speechLink.ReplaceAllString(text, "$1")
+	text = speechMarkup.ReplaceAllString(text, "")
+	return strings.TrimSpace(speechWhitespace.ReplaceAllString(text, " "))
+}
+
+// sentenceSplitter cuts streamed text into sentences to speak. Text inside a
+// code block is held back until the block ends, and short sentences are
+// joined so each request has enough text to sound natural. Every byte is
+// scanned once, however the text arrives.
+type sentenceSplitter struct {
+	pending   strings.Builder
+	scanned   int  // Bytes of pending checked for a sentence end
+	inCode    bool // Whether the scanned text ends inside a code block
+	backticks int  // Backticks at the end of the scanned text, short of a fence
+}
+
+// Feed adds streamed text and returns the sentences it completed.
+func (s *sentenceSplitter) Feed(text string) []string {
+	s.pending.WriteString(text)
+	var sentences []string
+	for {
+		buffered := s.pending.String()
+		end := s.sentenceEnd(buffered, minSpeechSentence)
+		if end < 0 {
+			return sentences
+		}
+		sentences = append(sentences, buffered[:end])
+		s.reset()
+		s.pending.WriteString(buffered[end:])
+	}
+}
+
+// Flush returns the text that did not end a sentence yet.
+func (s *sentenceSplitter) Flush() string {
+	rest := s.pending.String()
+	s.reset()
+	return rest
+}
+
+func (s *sentenceSplitter) reset() {
+	s.pending.Reset()
+	s.scanned, s.inCode, s.backticks = 0, false, 0
+}
+
+// sentenceEnd continues scanning text, the pending text, and returns where
+// its first sentence that is at least minChars long ends, or -1. A sentence
+// ends with ., ! or ? followed by a space, or with a blank line; not inside a
+// code block.
+func (s *sentenceSplitter) sentenceEnd(text string, minChars int) int {
+	for ; s.scanned < len(text)-1; s.scanned++ {
+		i := s.scanned
+		c, next := text[i], rune(text[i+1])
+		if i >= minChars && !s.inCode {
+			if (c == '.' || c == '!' || c == '?') && unicode.IsSpace(next) {
+				return i + 1
+			}
+			if c == '\n' && next == '\n' {
+				return i + 1
+			}
+		}
+		// Every third backtick in a row opens or closes a code block.
+		if c == '`' {
+			s.backticks++
+			if s.backticks == 3 {
+				s.inCode = !s.inCode
+				s.backticks = 0
+			}
+		} else {
+			s.backticks = 0
+		}
+	}
+	return -1
+}
+
+// replySpeaker speaks a streaming reply sentence by sentence with tts_stream
+// on. Sentences are synthesized in order in the background and published as
+// SpeechAudio events, so playback can start before the reply is complete.
+// Sentences queue without bound, so a slow speech server never holds up the
+// stream.
+type replySpeaker struct {
+	app       *App
+	backend   SpeechBackend
+	sessionID int64
+	splitter  sentenceSplitter
+
+	mu      sync.Mutex
+	queued  []string
+	closed  bool
+	waiting chan struct{} // Signalled when a sentence is queued or the reply ends
+}
+
+// newReplySpeaker returns a speaker for a reply, or nil if replies are not
+// spoken while they stream. A nil speaker ignores all calls.
+func (a *App) newReplySpeaker(sessionID int64) *replySpeaker {
+	if !a.config.TTSStream {
+		return nil
+	}
+	backend, err := a.speechBackend()
+	if err != nil {
+		a.logWarningf("TTS: Not speaking the reply: %v", err)
+		return nil
+	}
+	s := &replySpeaker{app: a, backend: backend, sessionID: sessionID, waiting: make(chan struct{}, 1)}
+	go s.run()
+	return s
+}
+
+// Feed adds streamed reply text.
+func (s *replySpeaker) Feed(text string) {
+	if s == nil {
+		return
+	}
+	for _, sentence := range s.splitter.Feed(text) {
+		s.say(sentence)
+	}
+}
+
+// Close speaks the rest of the reply and ends the speaker.
+func (s *replySpeaker) Close() {
+	if s == nil {
+		return
+	}
+	s.say(s.splitter.Flush())
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *replySpeaker) say(sentence string) {
+	text := speakableText(sentence)
+	if text == "" {
+		return
+	}
+	s.mu.Lock()
+	s.queued = append(s.queued, text)
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *replySpeaker) signal() {
+	select {
+	case s.waiting <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next sentence to speak, waiting for one, or false once the
+// reply has ended and everything was spoken.
+func (s *replySpeaker) next() (string, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.queued) > 0 {
+			text := s.queued[0]
+			s.queued = s.queued[1:]
+			s.mu.Unlock()
+			return text, true
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return "", false
+		}
+		<-s.waiting
+	}
+}
+
+func (s *replySpeaker) run() {
+	for seq := 0; ; {
+		text, ok := s.next()
+		if !ok {
+			break
+		}
+		audio, mimeType, err := s.backend.Synthesize(context.Background(), text)
+		if err != nil {
+			s.app.logWarningf("TTS: Could not speak %q: %v", text, err)
+			continue
+		}
+		s.app.emit(events.SpeechAudio, map[string]interface{}{
+			"session_id": s.sessionID,
+			"seq":        seq,
+			"text":       text,
+			"mime_type":  mimeType,
+			"audio":      base64.StdEncoding.EncodeToString(audio),
+		})
+		seq++
+	}
+	s.app.emit(events.SpeechComplete, s.sessionID)
+}
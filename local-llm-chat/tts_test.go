@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// feedInChunks feeds text to a new splitter size bytes at a time and returns
+// the sentences and the rest.
+func feedInChunks(text string, size int) []string {
+	var splitter sentenceSplitter
+	var sentences []string
+	for start := 0; start < len(text); start += size {
+		end := start + size
+		if end > len(text) {
+			end = len(text)
+		}
+		sentences = append(sentences, splitter.Feed(text[start:end])...)
+	}
+	return append(sentences, splitter.Flush())
+}
+
+func TestSentenceSplitter(t *testing.T) {
+	text := "Short one. This sentence is long enough to be spoken on its own! " +
+		"Is this one long enough to stand alone as well? Yes.\n\n" +
+		"Here is code that must not be cut:\n```go\nfmt.Println(\"a. b. c. d. e. f. g. h. i. j. k. l. m.\")\n```\n" +
+		"And after the block the text goes on. The end"
+	want := []string{
+		"Short one. This sentence is long enough to be spoken on its own!",
+		" Is this one long enough to stand alone as well?",
+		" Yes.\n\nHere is code that must not be cut:\n```go\nfmt.Println(\"a. b. c. d. e. f. g. h. i. j. k. l. m.\")\n```\nAnd after the block the text goes on.",
+		" The end",
+	}
+	for _, size := range []int{len(text), 1, 2, 3, 7} {
+		if got := feedInChunks(text, size); !reflect.DeepEqual(got, want) {
+			t.Errorf("chunks of %d bytes:\ngot  %q\nwant %q", size, got, want)
+		}
+	}
+}
+
+func TestSentenceSplitterFences(t *testing.T) {
+	// Six backticks open and close a block; the sentence end after them counts.
+	text := strings.Repeat("x", minSpeechSentence) + " ``````. Next"
+	got := feedInChunks(text, 1)
+	if len(got) != 2 || got[1] != " Next" {
+		t.Errorf("got %q, want a sentence end after the empty block", got)
+	}
+
+	// Four backticks open a block that is still open.
+	text = strings.Repeat("x", minSpeechSentence) + " ````. Held back. Still held back."
+	if got := feedInChunks(text, 1); len(got) != 1 {
+		t.Errorf("got %q, want everything held back inside the open block", got)
+	}
+}
+
+func TestSentenceSplitterOpenCodeBlockIsLinear(t *testing.T) {
+	// A long code block streamed in small chunks used to be rescanned on
+	// every chunk; this would not finish in time if it still were.
+	var splitter sentenceSplitter
+	splitter.Feed(strings.Repeat("y", minSpeechSentence) + "\n```\n")
+	line := "x := compute(a. b) // Not a sentence. Really!\n\n"
+	for i := 0; i < 40_000; i++ {
+		for j := 0; j < len(line); j += 4 {
+			end := j + 4
+			if end > len(line) {
+				end = len(line)
+			}
+			if sentences := splitter.Feed(line[j:end]); len(sentences) != 0 {
+				t.Fatalf("sentence ended inside a code block: %q", sentences[0])
+			}
+		}
+	}
+	if sentences := splitter.Feed("```\nDone. Really done."); len(sentences) != 1 || !strings.HasSuffix(sentences[0], "```\nDone.") {
+		t.Errorf("after the block got %d sentences", len(sentences))
+	}
+}
+
+func TestSplitSpeech(t *testing.T) {
+	text := "First sentence here, long enough to stand alone. " + strings.Repeat("Zweite lange Sätze über Öl. ", 20)
+	parts := splitSpeech(text, 100)
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want several", len(parts))
+	}
+	joined := strings.Join(parts, " ")
+	if strings.Join(strings.Fields(joined), " ") != strings.Join(strings.Fields(text), " ") {
+		t.Errorf("parts do not add up to the text:\n%q", parts)
+	}
+	for _, part := range parts {
+		if len(part) > 100 {
+			t.Errorf("part of %d bytes: %q", len(part), part)
+		}
+	}
+}
+
+func TestSplitSpeechRuneBoundaries(t *testing.T) {
+	// No spaces or sentence ends: the text must be cut mid-word, but never
+	// inside a character.
+	text := strings.Repeat("日本語テキスト", 200)
+	for _, maxChars := range []int{1, 2, 10, 100, 1000} {
+		parts := splitSpeech(text, maxChars)
+		if strings.Join(parts, "") != text {
+			t.Errorf("maxChars %d: parts do not add up to the text", maxChars)
+		}
+		for _, part := range parts {
+			if !utf8.ValidString(part) {
+				t.Fatalf("maxChars %d: part %q is not valid UTF-8", maxChars, part)
+			}
+			if maxChars >= utf8.UTFMax && len(part) > maxChars {
+				t.Errorf("maxChars %d: part of %d bytes", maxChars, len(part))
+			}
+		}
+	}
+}
+
+func TestSpeakableText(t *testing.T) {
+	text := "# Result\n\nSee [the docs](https://example.com) for **details**.\n```sh\nrm -rf /tmp/x\n```\nDone_now."
+	if got, want := speakableText(text), "Result See the docs for details. Donenow."; got != want {
+		t.Errorf("speakableText = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"local-llm-chat/artifacts"
+)
+
+// Speech to text with whisper.cpp. Recordings are stored as audio artifacts
+// and transcribed by a whisper-server that the app launches from
+// whisper_cpp_dir, like llama-server, the first time it is needed.
+
+// defaultWhisperServerPort is the port of the server launched by
+// LaunchWhisperServer, next to the chat and embedding servers.
+const defaultWhisperServerPort = 8082
+
+// whisperServerURL is the address of the server launched by
+// LaunchWhisperServer.
+func (a *App) whisperServerURL() string {
+	port := a.config.WhisperServerPort
+	if port <= 0 {
+		port = defaultWhisperServerPort
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d", port)
+}
+
+// LaunchWhisperServer launches whisper-server with a whisper.cpp model. If
+// ffmpeg is on the PATH, the server converts recordings that are not 16 kHz
+// WAV, such as the WebM the browser records, itself.
+func (a *App) LaunchWhisperServer(modelPath string) (string, error) {
+	if a.config.WhisperCppDir == "" {
+		return "", fmt.Errorf("whisper_cpp_dir is not set")
+	}
+	if running, _ := a.whisperServer(); running != nil && running.Process != nil {
+		a.logInfo("Terminating existing whisper server process...")
+		if err := running.Process.Kill(); err != nil {
+			a.logErrorf("Failed to terminate existing whisper server: %v", err)
+		}
+	}
+
+	port := a.config.WhisperServerPort
+	if port <= 0 {
+		port = defaultWhisperServerPort
+	}
+	args := []string{"-m", modelPath, "--host", "127.0.0.1", "--port", strconv.Itoa(port)}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		args = append(args, "--convert")
+	}
+	if a.config.WhisperModelArgs != "" {
+		args = append(args, strings.Fields(a.config.WhisperModelArgs)...)
+	}
+
+	cmd, err := a.startServer(a.config.WhisperCppDir, "whisper-server", args, "whisper-server.log")
+	if err != nil {
+		return "", fmt.Errorf("failed to start whisper server: %w", err)
+	}
+	a.serverMu.Lock()
+	a.whisperCmd = cmd
+	a.whisperModel = modelPath
+	a.serverMu.Unlock()
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			a.logErrorf("Whisper server exited with error: %v", err)
+		}
+		a.serverMu.Lock()
+		defer a.serverMu.Unlock()
+		if a.whisperCmd == cmd {
+			a.whisperCmd = nil
+			a.whisperModel = ""
+		}
+	}()
+	return "Whisper server launched successfully!", nil
+}
+
+// whisperServer returns the running whisper server and the model it serves.
+// cmd is nil if no server is running.
+func (a *App) whisperServer() (cmd *exec.Cmd, model string) {
+	a.serverMu.Lock()
+	defer a.serverMu.Unlock()
+	return a.whisperCmd, a.whisperModel
+}
+
+// IsWhisperServerLoaded reports whether the server launched by
+// LaunchWhisperServer is running.
+func (a *App) IsWhisperServerLoaded() bool {
+	cmd, _ := a.whisperServer()
+	return cmd != nil && cmd.Process != nil
+}
+
+// ShutdownWhisperServer stops the server launched by LaunchWhisperServer.
+func (a *App) ShutdownWhisperServer() error {
+	cmd, _ := a.whisperServer()
+	if cmd == nil {
+		return nil
+	}
+	a.logInfo("Shutting down whisper server...")
+	if err := shutdownLLM(cmd); err != nil {
+		a.logErrorf("Failed to shut down whisper server: %v. Attempting to kill.", err)
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// GetWhisperModels returns the whisper.cpp models (ggml-*.bin) in the
+// whisper.cpp directory, for choosing whisper_model.
+func (a *App) GetWhisperModels() ([]string, error) {
+	models := []string{}
+	if a.config.WhisperCppDir == "" {
+		return models, nil
+	}
+	err := filepath.Walk(a.config.WhisperCppDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := strings.ToLower(info.Name())
+		if !info.IsDir() && strings.HasPrefix(name, "ggml-") && strings.HasSuffix(name, ".bin") {
+			models = append(models, path)
+		}
+		return nil
+	})
+	return models, err
+}
+
+// ensureWhisperServer launches whisper_model if the server does not already
+// serve it, and waits until it is ready.
+func (a *App) ensureWhisperServer() error {
+	a.launchMu.Lock()
+	defer a.launchMu.Unlock()
+
+	if a.config.WhisperModel == "" {
+		return fmt.Errorf("whisper_model is not set")
+	}
+	if cmd, model := a.whisperServer(); cmd != nil && model == a.config.WhisperModel {
+		return nil
+	}
+	a.logInfof("Launching whisper server for %s", a.config.WhisperModel)
+	if _, err := a.LaunchWhisperServer(a.config.WhisperModel); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(llmStartTimeout)
+	for time.Now().Before(deadline) {
+		if whisperServerReady(a.whisperServerURL()) {
+			return nil
+		}
+		if !a.IsWhisperServerLoaded() {
+			return fmt.Errorf("whisper server exited while loading %s", filepath.Base(a.config.WhisperModel))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("whisper server did not load %s within %s", filepath.Base(a.config.WhisperModel), llmStartTimeout)
+}
+
+// whisperServerReady reports whether the whisper-server at baseURL has loaded
+// its model. Servers without /health only listen once the model is loaded.
+func whisperServerReady(baseURL string) bool {
+	resp, err := http.Get(baseURL + "/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound
+}
+
+// TranscribeAudio stores a recording for a chat as an audio artifact and
+// returns what was said in it, to put into the message composer.
+func (a *App) TranscribeAudio(sessionID int64, name string, contentBase64 string) (string, error) {
+	if a.ArtifactService == nil {
+		return "", fmt.Errorf("artifact service not initialized")
+	}
+	content, err := base64.StdEncoding.DecodeString(contentBase64)
+	if err != nil {
+		return "", fmt.Errorf("invalid audio data: %w", err)
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(content)
+	}
+	metadata := map[string]interface{}{"mime_type": mimeType, "source": "recording"}
+	if _, err := a.ArtifactService.AddArtifactWithMetadata(sessionID, artifacts.TypeAudio, name, contentBase64, metadata); err != nil {
+		return "", err
+	}
+	return a.transcribe(name, content)
+}
+
+// transcribe sends audio to whisper-server and returns the transcript.
+func (a *App) transcribe(name string, content []byte) (string, error) {
+	if err := a.ensureWhisperServer(); err != nil {
+		return "", err
+	}
+	language := a.config.WhisperLanguage
+	if language == "" {
+		language = "auto"
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", filepath.Base(name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(content); err != nil {
+		return "", err
+	}
+	fields := map[string]string{"response_format": "json", "temperature": "0.0", "language": language}
+	for key, value := range fields {
+		if err := form.WriteField(key, value); err != nil {
+			return "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	started := time.Now()
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Post(a.whisperServerURL()+"/inference", form.FormDataContentType(), &body)
+	if err != nil {
+		return "", fmt.Errorf("error making transcription request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading transcription response: %w", err)
+	}
+
+	var result struct {
+		Text  string `json:"text"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("transcription request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("transcription failed: %s", result.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription request failed with status %d", resp.StatusCode)
+	}
+	a.logInfof("Transcribed %s in %s", name, time.Since(started).Round(time.Millisecond))
+	return strings.TrimSpace(result.Text), nil
+}